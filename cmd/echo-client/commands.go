@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"tls-lab/internal/echoproto"
+	"tls-lab/internal/framing"
+)
+
+// runCommands sends each command, prints the replies and checks expect
+// against the server's WHOAMI reply. It returns the process exit code.
+func runCommands(fr *framing.Reader, fw *framing.Writer, cmds []string, expect []echoproto.Expectation) int {
+	var whoami echoproto.Fields
+	sent := map[string]bool{}
+	send := func(cmd string) (string, error) {
+		sent[strings.ToUpper(cmd)] = true
+		if err := fw.WriteFrame([]byte(cmd)); err != nil {
+			return "", fmt.Errorf("write %s: %w", cmd, err)
+		}
+		msg, err := fr.ReadFrame()
+		if err != nil {
+			return "", fmt.Errorf("read %s reply: %w", cmd, err)
+		}
+		reply := string(msg)
+		if echoproto.Command(cmd) != "" && reply == cmd {
+			return "", fmt.Errorf("server echoed %s instead of answering it (is it running with -commands?)", cmd)
+		}
+		return reply, nil
+	}
+	parseWhoAmI := func(reply string) bool {
+		f, err := echoproto.ParseFields(reply)
+		if err != nil {
+			slog.Error("bad WHOAMI reply", "err", err)
+			return false
+		}
+		whoami = f
+		return true
+	}
+
+	for _, cmd := range cmds {
+		reply, err := send(cmd)
+		if err != nil {
+			slog.Error("command failed", "cmd", cmd, "err", err)
+			return 1
+		}
+		fmt.Printf("%s: %s\n", cmd, reply)
+		if echoproto.Command(cmd) == echoproto.WhoAmI && !parseWhoAmI(reply) {
+			return 1
+		}
+		if echoproto.Command(cmd) == echoproto.Quit {
+			break
+		}
+	}
+
+	code := 0
+	if len(expect) > 0 {
+		if whoami == nil {
+			if sent[echoproto.Quit] {
+				slog.Error("cannot check expectations after QUIT")
+				return 1
+			}
+			reply, err := send(echoproto.WhoAmI)
+			if err != nil {
+				slog.Error("command failed", "cmd", echoproto.WhoAmI, "err", err)
+				return 1
+			}
+			if !parseWhoAmI(reply) {
+				return 1
+			}
+		}
+		for _, msg := range echoproto.Check(whoami, expect) {
+			fmt.Printf("FAIL %s\n", msg)
+			code = 1
+		}
+		if code == 0 {
+			fmt.Printf("OK %d expectation(s) met\n", len(expect))
+		}
+	}
+	if !sent[echoproto.Quit] {
+		_, _ = send(echoproto.Quit)
+	}
+	return code
+}
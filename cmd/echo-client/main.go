@@ -3,66 +3,192 @@ package main
 import (
 	"bufio"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
 	"os"
 	"time"
 
+	"tls-lab/internal/checkcmd"
+	"tls-lab/internal/echoproto"
+	"tls-lab/internal/framing"
+	"tls-lab/internal/logging"
 	"tls-lab/internal/tlsutil"
 )
 
 func main() {
 	var (
-		address   = flag.String("addr", "127.0.0.1:8443", "Server address")
-		serverName = flag.String("servername", "localhost", "ServerName (SNI) to verify")
-		caFile    = flag.String("ca", "certs/ca.crt", "CA cert to trust (PEM)")
-		certFile  = flag.String("cert", "", "Client certificate (PEM) for mTLS")
-		keyFile   = flag.String("key", "", "Client private key (PEM) for mTLS")
-		timeout   = flag.Duration("timeout", 10*time.Second, "Dial timeout")
+		address      = flag.String("addr", "127.0.0.1:8443", "Server address")
+		serverName   = flag.String("servername", "localhost", "ServerName (SNI) to verify")
+		caFile       = flag.String("ca", "certs/ca.crt", "CA cert to trust (PEM)")
+		certFile     = flag.String("cert", "", "Client certificate (PEM) for mTLS")
+		keyFile      = flag.String("key", "", "Client private key (PEM) for mTLS")
+		timeout      = flag.Duration("timeout", 10*time.Second, "Dial timeout")
+		daneMode     = flag.String("dane", "off", "Authenticate the server via TLSA records: off, dane (instead of CA) or dane+ca")
+		daneResolver = flag.String("dane-resolver", "127.0.0.1:53", "DNS server (host:port) used for TLSA lookups")
+		daneAD       = flag.Bool("dane-require-ad", false, "Reject TLSA answers without the DNSSEC AD bit")
+		frameMode    = flag.String("frame", "raw", "Message framing: raw, line or length (must match the server)")
+		maxFrame     = flag.Int("max-frame", framing.DefaultMaxFrame, "Max message size in bytes for line/length framing")
+		commands     = flag.String("cmd", "", "Comma-separated commands to send to a -commands server (e.g. PING,INFO,WHOAMI), then exit")
+		expect       = flag.String("expect", "", "Comma-separated key=value checks on the server's WHOAMI reply (e.g. version=TLS 1.3,client_cert=client); exit 1 on mismatch")
+		startTLS     = flag.Bool("starttls", false, "Connect in plaintext and upgrade with STARTTLS (server must run with -starttls)")
+		wsMode       = flag.Bool("ws", false, "Connect to the wss:// echo endpoint of a server running with -ws")
+		wsPath       = flag.String("ws-path", "/ws", "WebSocket endpoint path for -ws")
+		wsOrigin     = flag.String("ws-origin", "", "Origin header to send with -ws (tests the server's -ws-origins allowlist)")
+		quicMode     = flag.Bool("quic", false, "Connect over QUIC (server must run with -quic-addr)")
+		quic0RTT     = flag.Bool("quic-0rtt", false, "With -quic, resume with 0-RTT (a warm-up connection fetches the ticket first)")
+		logFormat    = flag.String("log-format", "text", "Log output: text or json")
+		logLevel     = flag.String("log-level", "info", "Log level: debug, info, warn or error")
 	)
 	flag.Parse()
 
+	if _, err := logging.Setup(*logFormat, *logLevel); err != nil {
+		logging.Fatal(err.Error())
+	}
+
+	frame, err := framing.ParseMode(*frameMode)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	expectations, err := echoproto.ParseExpectations(*expect)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	cmds := checkcmd.SplitList(*commands)
+	scripted := len(cmds) > 0 || len(expectations) > 0
+	if scripted && frame == framing.Raw {
+		frame = framing.Line
+	}
+
+	dane, err := tlsutil.ParseDANEMode(*daneMode)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+
 	tlsCfg, err := tlsutil.NewClientTLSConfig(tlsutil.ClientTLSOptions{
-		CAFile:     *caFile,
-		CertFile:   *certFile,
-		KeyFile:    *keyFile,
-		ServerName: *serverName,
-		MinVersion: tls.VersionTLS12,
+		CAFile:      *caFile,
+		CertFile:    *certFile,
+		KeyFile:     *keyFile,
+		ServerName:  *serverName,
+		MinVersion:  tls.VersionTLS12,
 		EnableTLS13: true,
+
+		DANE:          dane,
+		DANEResolver:  *daneResolver,
+		DANETarget:    tlsutil.DANETarget(*address, *serverName),
+		DANERequireAD: *daneAD,
 	})
 	if err != nil {
-		log.Fatalf("failed to build TLS config: %v", err)
+		logging.Fatal("failed to build TLS config", "err", err)
 	}
 
-	dialer := &net.Dialer{Timeout: *timeout}
-	conn, err := tls.DialWithDialer(dialer, "tcp", *address, tlsCfg)
+	if *wsMode {
+		if *startTLS || scripted {
+			logging.Fatal("-ws cannot be combined with -starttls, -cmd or -expect")
+		}
+		runWS(*address, *wsPath, *wsOrigin, tlsCfg, *timeout, int64(*maxFrame))
+		return
+	}
+
+	var sess *session
+	switch {
+	case *quicMode:
+		if *startTLS {
+			logging.Fatal("-quic cannot be combined with -starttls")
+		}
+		sess, err = dialQUIC(*address, tlsCfg, *timeout, *quic0RTT, frame)
+	default:
+		dialer := &net.Dialer{Timeout: *timeout}
+		start := time.Now()
+		var conn *tls.Conn
+		if *startTLS {
+			conn, err = dialSTARTTLS(dialer, *address, tlsCfg)
+		} else {
+			conn, err = tls.DialWithDialer(dialer, "tcp", *address, tlsCfg)
+		}
+		if err == nil {
+			sess = tcpSession(conn, start)
+		}
+	}
 	if err != nil {
-		log.Fatalf("dial error: %v", err)
+		if r := tlsutil.ClassifyHandshake(err); r != "" && r != tlsutil.ReasonOther {
+			logging.Fatal("TLS handshake failed", "addr", *address, "reason", r, "hint", r.Hint(), "err", err)
+		}
+		logging.Fatal("dial error", "addr", *address, "err", err)
+	}
+	defer sess.close()
+	if *quicMode {
+		sess.logConnected("QUIC", *address)
+	} else {
+		sess.logConnected("TCP", *address)
 	}
-	defer conn.Close()
 
-	log.Printf("Connected to %s", *address)
-	state := conn.ConnectionState()
-	log.Printf("TLS version=%x cipher=%x", state.Version, state.CipherSuite)
+	// Replies are read concurrently so pipelined input is not lost.
+	fr := framing.NewReader(sess, frame, *maxFrame)
+	fw := framing.NewWriter(sess, frame, *maxFrame)
+	if scripted {
+		code := runCommands(fr, fw, cmds, expectations)
+		_ = sess.close()
+		os.Exit(code)
+	}
+	replies := make(chan error, 1)
+	go func() { replies <- printReplies(fr, frame) }()
 
 	fmt.Println("Type messages; Ctrl+C to exit")
 	reader := bufio.NewScanner(os.Stdin)
+	reader.Buffer(make([]byte, 64*1024), 16<<20)
 	for reader.Scan() {
-		line := reader.Text()
-		if _, err := conn.Write([]byte(line + "\n")); err != nil {
-			log.Fatalf("write error: %v", err)
+		line := reader.Bytes()
+		if frame == framing.Raw {
+			line = append(line, '\n')
 		}
-		reply, err := bufio.NewReader(conn).ReadString('\n')
-		if err != nil {
-			log.Fatalf("read error: %v", err)
+		if err := fw.WriteFrame(line); err != nil {
+			if errors.Is(err, framing.ErrFrameTooLarge) {
+				slog.Warn("not sent", "err", err)
+				continue
+			}
+			fatalIO("write error", err)
 		}
-		fmt.Printf("echo: %s", reply)
 	}
 	if err := reader.Err(); err != nil {
-		log.Fatalf("stdin error: %v", err)
+		logging.Fatal("stdin error", "err", err)
+	}
+	// Let the server finish echoing what is in flight.
+	_ = sess.closeWrite()
+	if err := <-replies; err != nil {
+		fatalIO("read error", err)
 	}
+	sess.logSummary()
 }
 
+// fatalIO exits on a read or write error. With TLS 1.3 the server checks
+// the client certificate after our side of the handshake is done, so its
+// rejection arrives as an alert on the first read.
+func fatalIO(msg string, err error) {
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "remote error" {
+		r := tlsutil.ClassifyHandshake(err)
+		logging.Fatal("TLS handshake rejected by server", "reason", r, "hint", r.Hint(), "err", err)
+	}
+	logging.Fatal(msg, "err", err)
+}
 
+func printReplies(fr *framing.Reader, frame framing.Mode) error {
+	for {
+		msg, err := fr.ReadFrame()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if frame == framing.Raw {
+			fmt.Printf("echo: %s", msg)
+		} else {
+			fmt.Printf("echo: %s\n", msg)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"tls-lab/internal/echoproto"
+	"tls-lab/internal/framing"
+	"tls-lab/internal/logging"
+)
+
+// quicALPN must match echo-server's QUIC listener.
+const quicALPN = "tls-lab-echo"
+
+// session is one echo exchange over a TCP+TLS connection or a QUIC stream,
+// with byte counters so both transports report the same figures.
+type session struct {
+	rw         io.ReadWriter
+	closeWrite func() error
+	close      func() error
+	state      tls.ConnectionState
+	used0RTT   bool
+
+	start     time.Time
+	handshake time.Duration
+	// For 0-RTT, the stream takes data after early, before the handshake
+	// completes; state, used0RTT and handshake are only set once
+	// handshakeDone is closed.
+	early         time.Duration
+	handshakeDone chan struct{}
+
+	firstByte atomic.Int64 // nanoseconds from start to the first reply byte
+	sent      atomic.Int64
+	received  atomic.Int64
+}
+
+func (s *session) Read(p []byte) (int, error) {
+	n, err := s.rw.Read(p)
+	if n > 0 && s.firstByte.Load() == 0 {
+		s.firstByte.CompareAndSwap(0, int64(time.Since(s.start)))
+	}
+	s.received.Add(int64(n))
+	return n, err
+}
+
+func (s *session) Write(p []byte) (int, error) {
+	n, err := s.rw.Write(p)
+	s.sent.Add(int64(n))
+	return n, err
+}
+
+// logConnected reports the TLS parameters and handshake time. A 0-RTT
+// session is usable before its handshake completes, so it logs when the
+// stream opens and reports the handshake once it is done.
+func (s *session) logConnected(transport, addr string) {
+	if s.handshakeDone == nil {
+		slog.Info("connected", "addr", addr, "transport", transport, logging.TLS(&s.state),
+			"handshake", s.handshake.Round(time.Microsecond), "0rtt", s.used0RTT)
+		return
+	}
+	slog.Info("stream open before handshake", "addr", addr, "transport", transport, "early", s.early.Round(time.Microsecond))
+	go func() {
+		<-s.handshakeDone
+		if s.handshake > 0 {
+			slog.Info("connected", "addr", addr, "transport", transport, logging.TLS(&s.state),
+				"handshake", s.handshake.Round(time.Microsecond), "0rtt", s.used0RTT)
+		}
+	}()
+}
+
+// logSummary reports bytes moved, the time to the first reply byte and
+// throughput since the session could first carry data.
+func (s *session) logSummary() {
+	usable := s.handshake
+	if s.handshakeDone != nil {
+		usable = s.early
+	}
+	elapsed := time.Since(s.start) - usable
+	sent, received := s.sent.Load(), s.received.Load()
+	rate := 0.0
+	if secs := elapsed.Seconds(); secs > 0 {
+		rate = float64(sent+received) / secs / 1e6
+	}
+	slog.Info("session", "sent", sent, "received", received, "first_byte", time.Duration(s.firstByte.Load()).Round(time.Microsecond),
+		"elapsed", elapsed.Round(time.Millisecond), "mb_per_sec", fmt.Sprintf("%.2f", rate))
+}
+
+func tcpSession(conn *tls.Conn, start time.Time) *session {
+	return &session{
+		rw:         conn,
+		closeWrite: conn.CloseWrite,
+		close:      conn.Close,
+		state:      conn.ConnectionState(),
+		start:      start,
+		handshake:  time.Since(start),
+	}
+}
+
+// dialQUIC opens a QUIC connection and one bidirectional stream. With
+// zeroRTT a first connection is made to obtain a session ticket, and the
+// measured connection then resumes with 0-RTT and returns as soon as its
+// stream takes data.
+func dialQUIC(addr string, cfg *tls.Config, timeout time.Duration, zeroRTT bool, frame framing.Mode) (*session, error) {
+	cfg = cfg.Clone()
+	cfg.NextProtos = []string{quicALPN}
+	cfg.MinVersion = tls.VersionTLS13
+	qcfg := &quic.Config{HandshakeIdleTimeout: timeout}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if zeroRTT {
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+		if err := warmUp(ctx, addr, cfg, qcfg, frame); err != nil {
+			return nil, fmt.Errorf("0-RTT warm-up: %w", err)
+		}
+	}
+
+	start := time.Now()
+	var (
+		conn *quic.Conn
+		err  error
+	)
+	if zeroRTT {
+		conn, err = quic.DialAddrEarly(ctx, addr, cfg, qcfg)
+	} else {
+		conn, err = quic.DialAddr(ctx, addr, cfg, qcfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		_ = conn.CloseWithError(0, "")
+		return nil, err
+	}
+	s := &session{
+		rw:         stream,
+		closeWrite: stream.Close,
+		close:      func() error { return conn.CloseWithError(0, "") },
+		start:      start,
+	}
+	if !zeroRTT {
+		cs := conn.ConnectionState()
+		s.state, s.used0RTT, s.handshake = cs.TLS, cs.Used0RTT, time.Since(start)
+		return s, nil
+	}
+	s.early = time.Since(start)
+	s.handshakeDone = make(chan struct{})
+	go func() {
+		defer close(s.handshakeDone)
+		select {
+		case <-conn.HandshakeComplete():
+			s.handshake = time.Since(start)
+			cs := conn.ConnectionState()
+			s.state, s.used0RTT = cs.TLS, cs.Used0RTT
+		case <-conn.Context().Done():
+		}
+	}()
+	return s, nil
+}
+
+// warmUp makes one round trip on a throwaway connection. The server sends
+// its session ticket right after the handshake, so it has arrived by the
+// time the reply does.
+func warmUp(ctx context.Context, addr string, cfg *tls.Config, qcfg *quic.Config, frame framing.Mode) error {
+	conn, err := quic.DialAddr(ctx, addr, cfg, qcfg)
+	if err != nil {
+		return err
+	}
+	defer conn.CloseWithError(0, "")
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+	// PING is echoed, or answered in -commands mode; either way one frame
+	// comes back.
+	if err := framing.NewWriter(stream, frame, 0).WriteFrame([]byte(echoproto.Ping)); err != nil {
+		return err
+	}
+	_, err = framing.NewReader(stream, frame, 0).ReadFrame()
+	return err
+}
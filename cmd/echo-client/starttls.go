@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"tls-lab/internal/framing"
+)
+
+// dialSTARTTLS connects in plaintext, asks an echo-server in -starttls mode
+// to upgrade and runs the TLS handshake over the same connection. Bytes the
+// server sends after its OK are never handed to TLS: they would have been
+// injected before encryption started.
+func dialSTARTTLS(d *net.Dialer, addr string, cfg *tls.Config) (*tls.Conn, error) {
+	raw, err := d.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.Timeout > 0 {
+		_ = raw.SetDeadline(time.Now().Add(d.Timeout))
+	}
+	tc, err := upgrade(raw, cfg)
+	if err != nil {
+		_ = raw.Close()
+		return nil, err
+	}
+	_ = raw.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+func upgrade(raw net.Conn, cfg *tls.Config) (*tls.Conn, error) {
+	fr := framing.NewReader(raw, framing.Line, 512)
+	fw := framing.NewWriter(raw, framing.Line, 512)
+	greeting, err := fr.ReadFrame()
+	if err != nil {
+		return nil, fmt.Errorf("read greeting: %w", err)
+	}
+	if !strings.HasPrefix(string(greeting), "READY") {
+		return nil, fmt.Errorf("unexpected greeting %q (is the server running with -starttls?)", greeting)
+	}
+	if err := fw.WriteFrame([]byte("STARTTLS")); err != nil {
+		return nil, err
+	}
+	reply, err := fr.ReadFrame()
+	if err != nil {
+		return nil, fmt.Errorf("read STARTTLS reply: %w", err)
+	}
+	if !strings.HasPrefix(string(reply), "OK") {
+		return nil, fmt.Errorf("server refused STARTTLS: %s", reply)
+	}
+	if fr.Buffered() > 0 {
+		return nil, fmt.Errorf("server sent %d byte(s) after the STARTTLS reply; refusing to upgrade", fr.Buffered())
+	}
+	tc := tls.Client(raw, cfg)
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
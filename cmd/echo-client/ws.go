@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"tls-lab/internal/logging"
+)
+
+// runWS is the -ws mode: stdin lines are sent as text messages and echoes
+// are printed. "/ping" sends a ping and prints the round trip; stdin EOF
+// sends a normal close (1000) and waits for the server's close.
+func runWS(addr, path, origin string, cfg *tls.Config, timeout time.Duration, maxMessage int64) {
+	d := websocket.Dialer{
+		NetDialContext:   (&net.Dialer{Timeout: timeout}).DialContext,
+		TLSClientConfig:  cfg,
+		HandshakeTimeout: timeout,
+	}
+	u := url.URL{Scheme: "wss", Host: addr, Path: path}
+	var hdr http.Header
+	if origin != "" {
+		hdr = http.Header{"Origin": {origin}}
+	}
+	c, resp, err := d.Dial(u.String(), hdr)
+	if err != nil {
+		if resp != nil {
+			logging.Fatal("websocket dial error", "url", u.String(), "err", err, "status", resp.Status)
+		}
+		logging.Fatal("websocket dial error", "url", u.String(), "err", err)
+	}
+	defer c.Close()
+	c.SetReadLimit(maxMessage)
+	if tc, ok := c.NetConn().(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		slog.Info("connected", "url", u.String(), logging.TLS(&state))
+	}
+
+	pings := make(chan time.Time, 1)
+	c.SetPongHandler(func(string) error {
+		select {
+		case sent := <-pings:
+			fmt.Printf("pong: %s\n", time.Since(sent).Round(time.Microsecond))
+		default:
+		}
+		return nil
+	})
+
+	replies := make(chan error, 1)
+	go func() {
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				var ce *websocket.CloseError
+				if errors.As(err, &ce) && ce.Code == websocket.CloseNormalClosure {
+					err = nil
+				}
+				replies <- err
+				return
+			}
+			if mt == websocket.BinaryMessage {
+				fmt.Printf("echo (binary, %d bytes): %x\n", len(msg), msg)
+				continue
+			}
+			fmt.Printf("echo: %s\n", msg)
+		}
+	}()
+
+	fmt.Println("Type messages (/ping to ping); Ctrl+C to exit")
+	reader := bufio.NewScanner(os.Stdin)
+	reader.Buffer(make([]byte, 64*1024), 16<<20)
+	for reader.Scan() {
+		line := reader.Text()
+		if strings.TrimSpace(line) == "/ping" {
+			select {
+			case pings <- time.Now():
+			default:
+			}
+			if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+				logging.Fatal("ping error", "err", err)
+			}
+			continue
+		}
+		if int64(len(line)) > maxMessage {
+			slog.Warn("not sent: message too large", "bytes", len(line), "max", maxMessage)
+			continue
+		}
+		if err := c.WriteMessage(websocket.TextMessage, []byte(line)); err != nil {
+			logging.Fatal("write error", "err", err)
+		}
+	}
+	if err := reader.Err(); err != nil {
+		logging.Fatal("stdin error", "err", err)
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	_ = c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(timeout))
+	select {
+	case err := <-replies:
+		if err != nil {
+			logging.Fatal("read error", "err", err)
+		}
+	case <-time.After(timeout):
+		slog.Warn("no close from server", "timeout", timeout)
+	}
+}
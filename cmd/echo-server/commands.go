@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"tls-lab/internal/echoproto"
+	"tls-lab/internal/framing"
+	"tls-lab/internal/tlsutil"
+)
+
+// serverInfo is the INFO reply: build details and the TLS policy in effect.
+func serverInfo(cfg *tls.Config, frame framing.Mode, maxFrame int) echoproto.Fields {
+	var f echoproto.Fields
+	f.Add("server", "echo-server")
+	version, revision, goVersion := "", "", ""
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		version, goVersion = bi.Main.Version, bi.GoVersion
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				revision = s.Value
+			}
+		}
+	}
+	f.Add("version", version)
+	f.Add("revision", revision)
+	f.Add("go", goVersion)
+
+	f.Add("min_tls", tls.VersionName(cfg.MinVersion))
+	maxVersion := uint16(tls.VersionTLS13)
+	if cfg.MaxVersion != 0 {
+		maxVersion = cfg.MaxVersion
+	}
+	f.Add("max_tls", tls.VersionName(maxVersion))
+	f.Add("client_auth", cfg.ClientAuth.String())
+	var suites []string
+	for _, id := range cfg.CipherSuites {
+		suites = append(suites, tls.CipherSuiteName(id))
+	}
+	f.Add("tls12_suites", strings.Join(suites, ","))
+	var curves []string
+	for _, id := range cfg.CurvePreferences {
+		curves = append(curves, id.String())
+	}
+	f.Add("curves", strings.Join(curves, ","))
+	f.Add("alpn", strings.Join(cfg.NextProtos, ","))
+	f.Add("frame", string(frame))
+	f.Add("max_frame", strconv.Itoa(maxFrame))
+	return f
+}
+
+// whoAmI is the WHOAMI reply: the TLS parameters the server saw for this
+// connection.
+func whoAmI(state *tls.ConnectionState) echoproto.Fields {
+	var f echoproto.Fields
+	if state == nil {
+		f.Add("tls", "false")
+		return f
+	}
+	f.Add("tls", "true")
+	f.Add("version", tls.VersionName(state.Version))
+	f.Add("cipher", tls.CipherSuiteName(state.CipherSuite))
+	f.Add("alpn", state.NegotiatedProtocol)
+	f.Add("sni", state.ServerName)
+	f.Add("resumed", strconv.FormatBool(state.DidResume))
+	f.Add("client_cert", tlsutil.PeerIdentity(*state))
+	return f
+}
+
+// command answers one line in -commands mode; quit ends the connection after
+// the reply is written.
+func (s *echoServer) command(state *tls.ConnectionState, msg []byte) (reply []byte, quit bool) {
+	switch echoproto.Command(string(msg)) {
+	case echoproto.Ping:
+		return []byte(echoproto.Pong), false
+	case echoproto.Time:
+		return []byte(time.Now().UTC().Format(time.RFC3339Nano)), false
+	case echoproto.Info:
+		return []byte(s.info.String()), false
+	case echoproto.WhoAmI:
+		return []byte(whoAmI(state).String()), false
+	case echoproto.Quit:
+		return []byte(echoproto.Bye), true
+	}
+	return msg, false
+}
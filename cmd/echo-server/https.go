@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"tls-lab/internal/accesslog"
+	"tls-lab/internal/connreg"
+	"tls-lab/internal/lifecycle"
+	"tls-lab/internal/logging"
+	"tls-lab/internal/proxyproto"
+)
+
+// httpEcho is the JSON body returned by -http mode.
+type httpEcho struct {
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Proto      string              `json:"proto"`
+	Host       string              `json:"host"`
+	RemoteAddr string              `json:"remote_addr"`
+	Headers    map[string][]string `json:"headers"`
+	BodyBytes  int                 `json:"body_bytes"`
+	Body       string              `json:"body,omitempty"`
+	BodyBase64 string              `json:"body_base64,omitempty"`
+	TLS        *httpTLS            `json:"tls,omitempty"`
+}
+
+type httpTLS struct {
+	Version        string     `json:"version"`
+	Cipher         string     `json:"cipher"`
+	ALPN           string     `json:"alpn"`
+	SNI            string     `json:"sni"`
+	Resumed        bool       `json:"resumed"`
+	ClientVerified bool       `json:"client_verified"`
+	ClientChain    []httpCert `json:"client_chain"`
+}
+
+type httpCert struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	SHA256    string    `json:"sha256"`
+}
+
+// echoHTTP reflects the request and describes its TLS session.
+func echoHTTP(maxBody int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Debug("http request", "method", r.Method, "url", r.URL.String(), "proto", r.Proto, logging.TLS(r.TLS))
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out := httpEcho{
+			Method:     r.Method,
+			URL:        r.URL.String(),
+			Proto:      r.Proto,
+			Host:       r.Host,
+			RemoteAddr: r.RemoteAddr,
+			Headers:    r.Header,
+			BodyBytes:  len(body),
+		}
+		if utf8.Valid(body) {
+			out.Body = string(body)
+		} else {
+			out.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+		}
+		if cs := r.TLS; cs != nil {
+			t := &httpTLS{
+				Version:        tls.VersionName(cs.Version),
+				Cipher:         tls.CipherSuiteName(cs.CipherSuite),
+				ALPN:           cs.NegotiatedProtocol,
+				SNI:            cs.ServerName,
+				Resumed:        cs.DidResume,
+				ClientVerified: len(cs.VerifiedChains) > 0,
+				ClientChain:    []httpCert{},
+			}
+			for _, c := range cs.PeerCertificates {
+				sum := sha256.Sum256(c.Raw)
+				t.ClientChain = append(t.ClientChain, httpCert{
+					Subject:   c.Subject.String(),
+					Issuer:    c.Issuer.String(),
+					Serial:    c.SerialNumber.Text(16),
+					NotBefore: c.NotBefore,
+					NotAfter:  c.NotAfter,
+					DNSNames:  c.DNSNames,
+					SHA256:    hex.EncodeToString(sum[:]),
+				})
+			}
+			out.TLS = t
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(out)
+	})
+}
+
+// serveHTTPS runs -http/-ws mode on inner until s.ctx is cancelled. HTTP/2
+// and HTTP/1.1 are offered via ALPN on the same TLS config. Connections go
+// through the same handshake gate, identity quota, registry and access log
+// as raw TCP; handlers find the connection's logger with
+// logging.FromContext. WebSocket sessions, which http.Server lets go when
+// they hijack the connection, are drained from hijacked within the same
+// timeout.
+func (s *echoServer) serveHTTPS(inner net.Listener, h http.Handler, hijacked *lifecycle.Group, drain time.Duration) {
+	cfg := s.tlsCfg.Clone()
+	cfg.NextProtos = []string{"h2", "http/1.1"}
+	tracker := &lifecycle.HTTPTracker{}
+	srv := &http.Server{
+		Handler:           h,
+		TLSConfig:         cfg,
+		ReadHeaderTimeout: s.rt,
+		ReadTimeout:       s.rt,
+		WriteTimeout:      s.wt,
+		ConnState:         tracker.ConnState,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if hc, ok := c.(*tls.Conn).NetConn().(*httpConn); ok {
+				return logging.NewContext(ctx, hc.lg)
+			}
+			return ctx
+		},
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-s.ctx.Done()
+		slog.Info("shutting down: draining HTTP connections", "active", tracker.Active()+hijacked.Active(), "timeout", drain)
+		start := time.Now()
+		sum := lifecycle.StopHTTP(srv, tracker, drain)
+		// Once Shutdown returns no handler can hijack another connection.
+		ws := hijacked.Drain(max(drain-time.Since(start), 0))
+		sum.Active += ws.Active
+		sum.Drained += ws.Drained
+		sum.Killed += ws.Killed
+		sum.Elapsed = time.Since(start)
+		slog.Info("shutdown complete", "summary", sum.String())
+	}()
+	if err := srv.Serve(newHandshakeListener(s, inner, cfg)); !errors.Is(err, http.ErrServerClosed) {
+		logging.Fatal("http serve error", "err", err)
+	}
+	<-stopped
+}
+
+// handshakeListener hands http.Server only connections that were admitted
+// by echoServer.admit. Each handshake runs in its own goroutine so a slow
+// client does not hold up Accept.
+type handshakeListener struct {
+	net.Listener
+	s     *echoServer
+	cfg   *tls.Config
+	conns chan *tls.Conn
+	done  chan struct{} // closed when the inner listener fails
+	err   error         // set before done is closed
+}
+
+func newHandshakeListener(s *echoServer, inner net.Listener, cfg *tls.Config) *handshakeListener {
+	l := &handshakeListener{Listener: inner, s: s, cfg: cfg, conns: make(chan *tls.Conn), done: make(chan struct{})}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *handshakeListener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			if lifecycle.IsClosed(err) {
+				l.err = err
+				close(l.done)
+				return
+			}
+			slog.Error("accept error", "err", err)
+			continue
+		}
+		go l.handshake(c)
+	}
+}
+
+// handshake admits c and queues it for Accept. The access log entry is
+// written when the connection is closed, by http.Server or a WebSocket
+// handler that hijacked it.
+func (l *handshakeListener) handshake(c net.Conn) {
+	connID := l.s.registry.NextID()
+	hc := &httpConn{
+		Conn: c,
+		lg:   slog.With("conn", connID, "remote", c.RemoteAddr().String()),
+		acc:  accesslog.NewEntry("echo-server", "http", connID, proxyproto.PeerAddr(c), c.LocalAddr()),
+		s:    l.s,
+	}
+	if proxyproto.HeaderOf(c) != nil {
+		hc.acc.Client = c.RemoteAddr().String()
+	}
+	tc := tls.Server(hc, l.cfg)
+	entry, release, reason := l.s.admit(tc, connID, "http", hc.lg, hc.acc)
+	if entry == nil {
+		hc.reason = reason
+		_ = tc.Close()
+		return
+	}
+	hc.release = release
+	hc.entry.Store(entry)
+	select {
+	case l.conns <- tc:
+	case <-l.done:
+		hc.reason = "server shutdown"
+		_ = tc.Close()
+	}
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case tc := <-l.conns:
+		return tc, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// httpConn sits under the *tls.Conn of an -http/-ws connection. Once the
+// connection is registered it counts bytes into the entry (TLS records,
+// since http.Server needs the *tls.Conn itself), and its first Close ends
+// the connection's registration and writes the access log entry; the
+// close reason comes from the first read error, if any.
+type httpConn struct {
+	net.Conn
+	lg      *slog.Logger
+	acc     *accesslog.Entry
+	s       *echoServer
+	entry   atomic.Pointer[connreg.Entry]
+	release func()
+	reason  string // set when refused before serving
+	readErr atomic.Pointer[error]
+	once    sync.Once
+}
+
+func (c *httpConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if e := c.entry.Load(); e != nil {
+		e.AddIn(n)
+	}
+	// http.Server interrupts its background reads with a past deadline, so
+	// timeouts say nothing about why the connection ended.
+	if ne, ok := err.(net.Error); err != nil && !(ok && ne.Timeout()) {
+		c.readErr.CompareAndSwap(nil, &err)
+	}
+	return n, err
+}
+
+func (c *httpConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if e := c.entry.Load(); e != nil {
+		e.AddOut(n)
+	}
+	return n, err
+}
+
+// NetConn returns the wrapped connection, for fingerprint.Of and
+// proxyproto.HeaderOf.
+func (c *httpConn) NetConn() net.Conn { return c.Conn }
+
+func (c *httpConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		entry := c.entry.Load()
+		reason := c.reason
+		if entry != nil {
+			c.release()
+			entry.Unregister()
+			switch readErr := c.readErr.Load(); {
+			case c.s.ctx.Err() != nil:
+				reason = "server shutdown"
+			case readErr != nil:
+				reason = accesslog.Reason(*readErr)
+			default:
+				reason = "closed by server"
+			}
+		}
+		c.s.finish(c.lg, c.acc, entry, reason)
+	})
+	return err
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+
+	"tls-lab/internal/framing"
+)
+
+// hubStats counts delivered messages and slow consumers disconnected.
+var hubStats = expvar.NewMap("echo_hub")
+
+// hub fans messages out between connected clients in -hub mode.
+//
+// Protocol, one frame per line:
+//
+//	<text>              to every other client: MSG * <sender> <text>
+//	JOIN <room>         reply OK JOIN <room>; members get JOINED <room> <sender>
+//	LEAVE <room>        reply OK LEAVE <room>; members get LEFT <room> <sender>
+//	SAY <room> <text>   to the other members: MSG <room> <sender> <text>
+//
+// The sender is the client certificate CN. Each client has a bounded
+// outbound queue; a client whose queue overflows is disconnected so one
+// stalled reader cannot hold up the others. Text that would not fit in
+// one frame once the MSG prefix is added is refused with an ERR reply.
+type hub struct {
+	queue    int
+	maxFrame int
+
+	mu      sync.Mutex
+	clients map[*hubClient]struct{}
+	rooms   map[string]map[*hubClient]struct{}
+}
+
+func newHub(queue, maxFrame int) *hub {
+	if queue <= 0 {
+		queue = 1
+	}
+	if maxFrame <= 0 {
+		maxFrame = framing.DefaultMaxFrame
+	}
+	return &hub{
+		queue:    queue,
+		maxFrame: maxFrame,
+		clients:  map[*hubClient]struct{}{},
+		rooms:    map[string]map[*hubClient]struct{}{},
+	}
+}
+
+type hubClient struct {
+	id    string
+	conn  net.Conn
+	lg    *slog.Logger
+	out   chan []byte
+	done  chan struct{}
+	rooms map[string]bool // guarded by hub.mu
+	once  sync.Once
+}
+
+// send queues msg without blocking; must be called with h.mu held.
+func (c *hubClient) send(msg string) {
+	select {
+	case c.out <- []byte(msg):
+	default:
+		c.once.Do(func() {
+			hubStats.Add("dropped_slow", 1)
+			c.lg.Warn("hub: client is not keeping up; disconnecting", "identity", c.id)
+			_ = c.conn.Close()
+		})
+	}
+}
+
+func (c *hubClient) writeLoop(fw *framing.Writer) {
+	for {
+		select {
+		case msg := <-c.out:
+			err := fw.WriteFrame(msg)
+			if errors.Is(err, framing.ErrFrameTooLarge) {
+				// Not this client's fault; handle should have refused it.
+				c.lg.Warn("hub: dropping oversized message", "identity", c.id, "err", err)
+				continue
+			}
+			if err != nil {
+				_ = c.conn.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// serve runs one client until it disconnects and returns the read error
+// that ended it.
+func (h *hub) serve(c net.Conn, id string, lg *slog.Logger, fr *framing.Reader, fw *framing.Writer) error {
+	cl := &hubClient{
+		id:    id,
+		conn:  c,
+		lg:    lg,
+		out:   make(chan []byte, h.queue),
+		done:  make(chan struct{}),
+		rooms: map[string]bool{},
+	}
+	h.mu.Lock()
+	h.clients[cl] = struct{}{}
+	h.mu.Unlock()
+	defer h.remove(cl)
+	go cl.writeLoop(fw)
+
+	for {
+		msg, err := fr.ReadFrame()
+		if err != nil {
+			return err
+		}
+		h.handle(cl, string(msg))
+	}
+}
+
+func (h *hub) remove(cl *hubClient) {
+	h.mu.Lock()
+	for room := range cl.rooms {
+		h.leave(cl, room)
+	}
+	delete(h.clients, cl)
+	h.mu.Unlock()
+	close(cl.done)
+}
+
+func (h *hub) handle(cl *hubClient, line string) {
+	verb, rest, _ := strings.Cut(line, " ")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch strings.ToUpper(verb) {
+	case "JOIN":
+		room, ok := roomName(rest)
+		if !ok {
+			h.fail(cl, "invalid room name")
+			return
+		}
+		if !h.fits(cl, "JOINED "+room+" "+cl.id, room) {
+			return
+		}
+		if !cl.rooms[room] {
+			for m := range h.rooms[room] {
+				m.send("JOINED " + room + " " + cl.id)
+			}
+			if h.rooms[room] == nil {
+				h.rooms[room] = map[*hubClient]struct{}{}
+			}
+			h.rooms[room][cl] = struct{}{}
+			cl.rooms[room] = true
+		}
+		cl.send("OK JOIN " + room)
+	case "LEAVE":
+		room, ok := roomName(rest)
+		if !ok || !cl.rooms[room] {
+			h.fail(cl, "not in room "+rest)
+			return
+		}
+		h.leave(cl, room)
+		cl.send("OK LEAVE " + room)
+	case "SAY":
+		room, text, _ := strings.Cut(rest, " ")
+		if !cl.rooms[room] {
+			h.fail(cl, "not in room "+room)
+			return
+		}
+		if msg := "MSG " + room + " " + cl.id + " " + text; h.fits(cl, msg, text) {
+			h.fanout(cl, h.rooms[room], msg)
+		}
+	default:
+		if msg := "MSG * " + cl.id + " " + line; h.fits(cl, msg, line) {
+			h.fanout(cl, h.clients, msg)
+		}
+	}
+}
+
+// fits reports whether msg, built around the client's text, fits in one
+// frame, and otherwise tells the client how much text is allowed. It must
+// be called with h.mu held.
+func (h *hub) fits(cl *hubClient, msg, text string) bool {
+	if len(msg) <= h.maxFrame {
+		return true
+	}
+	h.fail(cl, fmt.Sprintf("too long: at most %d bytes here", max(0, h.maxFrame-(len(msg)-len(text)))))
+	return false
+}
+
+// fail sends an ERR reply, cut to the frame size since it may quote the
+// client. It must be called with h.mu held.
+func (h *hub) fail(cl *hubClient, reason string) {
+	msg := "ERR " + reason
+	cl.send(msg[:min(len(msg), h.maxFrame)])
+}
+
+// leave must be called with h.mu held.
+func (h *hub) leave(cl *hubClient, room string) {
+	delete(cl.rooms, room)
+	delete(h.rooms[room], cl)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+		return
+	}
+	for m := range h.rooms[room] {
+		m.send("LEFT " + room + " " + cl.id)
+	}
+}
+
+// fanout must be called with h.mu held.
+func (h *hub) fanout(from *hubClient, to map[*hubClient]struct{}, msg string) {
+	for m := range to {
+		if m != from {
+			m.send(msg)
+			hubStats.Add("delivered", 1)
+		}
+	}
+}
+
+func roomName(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "*" || strings.ContainsAny(s, " \t") {
+		return "", false
+	}
+	return s, true
+}
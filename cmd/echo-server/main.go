@@ -1,17 +1,32 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"time"
 
-	_ "net/http/pprof"
+	"github.com/quic-go/quic-go"
+
+	"tls-lab/internal/accesslog"
+	"tls-lab/internal/admin"
+	"tls-lab/internal/checkcmd"
+	"tls-lab/internal/connreg"
+	"tls-lab/internal/echoproto"
+	"tls-lab/internal/fingerprint"
+	"tls-lab/internal/framing"
+	"tls-lab/internal/health"
+	"tls-lab/internal/lifecycle"
+	"tls-lab/internal/limit"
+	"tls-lab/internal/logging"
 	bufpool "tls-lab/internal/pool"
+	"tls-lab/internal/proxyproto"
 	"tls-lab/internal/tlsutil"
 )
 
@@ -24,79 +39,446 @@ func main() {
 		requireClientCert = flag.Bool("mtls", false, "Require client certificate (mTLS)")
 		readTimeout       = flag.Duration("read-timeout", 30*time.Second, "Per-connection read timeout")
 		writeTimeout      = flag.Duration("write-timeout", 30*time.Second, "Per-connection write timeout")
-		pprofAddr         = flag.String("pprof", "", "pprof listen address (e.g. 127.0.0.1:6061); empty to disable")
+		certCheck         = flag.String("cert-check", "warn", "Startup certificate check policy: off, warn or fail")
+		certNames         = flag.String("cert-names", "localhost", "Comma-separated names the server cert must cover (listen host is added)")
+		issuerCA          = flag.String("issuer-ca", "certs/ca.crt", "CA expected to issue the server cert; empty for system roots")
+		expiryWarn        = flag.Duration("expiry-warn", 30*24*time.Hour, "Warn when the server cert has less validity left than this")
+		intermediates     = flag.String("intermediates", "", "Intermediate CA bundle (PEM) used to build the served chain")
+		fetchAIA          = flag.Bool("fetch-aia", false, "Fetch missing issuers from the certificate AIA URLs")
+		hsTimeout         = flag.Duration("handshake-timeout", 10*time.Second, "TLS handshake deadline")
+		maxHandshakes     = flag.Int("max-handshakes", 256, "Max concurrent TLS handshakes (0 = unlimited)")
+		hsQueue           = flag.Duration("handshake-queue", time.Second, "How long a handshake may wait for a slot before being shed (0 = shed immediately)")
+		hsRate            = flag.Float64("handshake-rate", 0, "New handshakes per second per source IP (0 = unlimited)")
+		hsBurst           = flag.Int("handshake-burst", 0, "Burst for -handshake-rate (0 = 1)")
+		maxConns          = flag.Int("max-conns", 0, "Max concurrent connections (0 = unlimited)")
+		maxConnsPerIP     = flag.Int("max-conns-per-ip", 0, "Max concurrent connections per source IP (0 = unlimited)")
+		connRate          = flag.Float64("conn-rate", 0, "New connections per second overall (0 = unlimited)")
+		connBurst         = flag.Int("conn-burst", 100, "Burst for -conn-rate")
+		connRatePerIP     = flag.Float64("conn-rate-per-ip", 0, "New connections per second per source IP (0 = unlimited)")
+		connBurstPerIP    = flag.Int("conn-burst-per-ip", 20, "Burst for -conn-rate-per-ip")
+		maxPerIdentity    = flag.Int("max-conns-per-identity", 0, "Max concurrent connections per client certificate CN (0 = unlimited)")
+		frameMode         = flag.String("frame", "raw", "Message framing: raw, line or length (must match the client)")
+		maxFrame          = flag.Int("max-frame", framing.DefaultMaxFrame, "Max message size in bytes for line/length framing")
+		commands          = flag.Bool("commands", false, "Answer PING, TIME, INFO, WHOAMI and QUIT; echo other lines (uses line framing unless -frame length)")
+		hubMode           = flag.Bool("hub", false, "Chat hub: fan messages out to other clients or rooms, tagged with the sender's cert CN (needs -mtls)")
+		hubQueue          = flag.Int("hub-queue", 256, "Per-client outbound queue in -hub mode; clients that overflow it are disconnected")
+		proxyFrom         = flag.String("proxy-protocol-from", "", "Comma-separated CIDRs allowed to send PROXY v1/v2 headers; empty to disable")
+		proxyTimeout      = flag.Duration("proxy-header-timeout", 5*time.Second, "Deadline for reading a PROXY header")
+		fpAllow           = flag.String("fingerprint-allow", "", "Comma-separated JA3 hashes or JA4 fingerprints allowed to connect (@file reads one per line); empty allows all")
+		fpDeny            = flag.String("fingerprint-deny", "", "Comma-separated JA3 hashes or JA4 fingerprints refused during the handshake (@file reads one per line)")
+		startTLS          = flag.Bool("starttls", false, "Listen in plaintext and upgrade to TLS after a STARTTLS command")
+		httpMode          = flag.Bool("http", false, "Serve HTTPS (HTTP/2 and HTTP/1.1) and reflect each request and its TLS session as JSON")
+		httpMaxBody       = flag.Int64("http-max-body", 1<<20, "Max request body reflected in -http mode")
+		wsMode            = flag.Bool("ws", false, "Serve a wss:// echo endpoint (HTTPS listener, alone or alongside -http)")
+		wsPath            = flag.String("ws-path", "/ws", "Path of the WebSocket endpoint")
+		wsOrigins         = flag.String("ws-origins", "", "Comma-separated allowed browser Origins (e.g. https://tools.example.com), * for any; empty allows same-host only")
+		wsPing            = flag.Duration("ws-ping", 15*time.Second, "Interval between server pings on WebSocket connections (0 = off)")
+		quicAddr          = flag.String("quic-addr", "", "Also serve the echo over QUIC on this UDP address (e.g. 0.0.0.0:8443); empty to disable")
+		quicStreams       = flag.Int64("quic-streams", 100, "Max concurrent streams per QUIC connection")
+		quic0RTT          = flag.Bool("quic-0rtt", false, "Accept 0-RTT data on QUIC (replayable; only enable for idempotent traffic)")
+		adminAddr         = flag.String("admin-addr", "", "Admin API listen address (mTLS: dashboard, health, metrics, pprof, connections, log level); empty to disable")
+		adminCert         = flag.String("admin-cert", "certs/server.crt", "Admin API server certificate (PEM)")
+		adminKey          = flag.String("admin-key", "certs/server.key", "Admin API server key (PEM)")
+		adminCA           = flag.String("admin-ca", "certs/ca.crt", "CA that issues admin client certificates (PEM)")
+		adminClients      = flag.String("admin-clients", "", "Comma-separated client certificate identities with full admin access; empty (with -admin-readers) allows any cert from -admin-ca")
+		adminReaders      = flag.String("admin-readers", "", "Comma-separated client certificate identities allowed read-only admin access (GET, no pprof)")
+		adminSocket       = flag.String("admin-socket", "", "Also serve the admin API on this Unix socket (owner-only, no TLS); empty to disable")
+		drainTimeout      = flag.Duration("drain-timeout", 15*time.Second, "On shutdown, wait this long for active connections before closing them")
+		accessLog         = flag.String("access-log", "", "Append one JSON line per connection (TLS, client cert, bytes, close reason) to this file; empty to disable")
+		accessMaxSize     = flag.Int64("access-log-max-size", 100<<20, "Rotate the access log at this many bytes (0 = no size limit)")
+		accessMaxAge      = flag.Duration("access-log-max-age", 24*time.Hour, "Rotate the access log after this long (0 = no age limit)")
+		logFormat         = flag.String("log-format", "text", "Log output: text or json")
+		logLevel          = flag.String("log-level", "info", "Log level: debug, info, warn or error (changeable at runtime via the admin API)")
 	)
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(checkcmd.Run("echo-server", os.Args[2:]))
+	}
 	flag.Parse()
 
-	if *pprofAddr != "" {
-		go func() {
-			log.Printf("pprof listening on http://%s/debug/pprof/", *pprofAddr)
-			_ = http.ListenAndServe(*pprofAddr, nil)
-		}()
+	logLevelVar, err := logging.Setup(*logFormat, *logLevel)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	policy, err := tlsutil.ParseCheckPolicy(*certCheck)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	frame, err := framing.ParseMode(*frameMode)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	trustedProxies, err := proxyproto.ParseCIDRs(*proxyFrom)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	allowFP, err := fingerprint.ParseList(*fpAllow)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	denyFP, err := fingerprint.ParseList(*fpDeny)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	if *commands && *hubMode {
+		logging.Fatal("-commands and -hub cannot be combined")
+	}
+	if (*httpMode || *wsMode) && (*commands || *hubMode || *startTLS) {
+		logging.Fatal("-http and -ws cannot be combined with -commands, -hub or -starttls")
+	}
+	if *quicAddr != "" && (*httpMode || *wsMode || *hubMode) {
+		logging.Fatal("-quic-addr cannot be combined with -http, -ws or -hub")
+	}
+	if *quicAddr != "" && (len(allowFP) > 0 || len(denyFP) > 0) {
+		// quic-go parses the ClientHello itself, so QUIC clients could not
+		// be filtered.
+		logging.Fatal("-quic-addr cannot be combined with -fingerprint-allow or -fingerprint-deny")
+	}
+	if *hubMode && !*requireClientCert {
+		logging.Fatal("-hub needs -mtls so senders can be identified")
+	}
+	if (*commands || *hubMode) && frame == framing.Raw {
+		frame = framing.Line
+	}
+	var access *accesslog.Log
+	if *accessLog != "" {
+		access, err = accesslog.Open(*accessLog, *accessMaxSize, *accessMaxAge)
+		if err != nil {
+			logging.Fatal(err.Error())
+		}
+		defer access.Close()
 	}
 
 	tlsCfg, err := tlsutil.NewServerTLSConfig(tlsutil.ServerTLSOptions{
-		CertFile:          *certFile,
-		KeyFile:           *keyFile,
-		CAFile:            *caFile,
-		RequireClientCert: *requireClientCert,
-		MinVersion:        tls.VersionTLS12,
-		EnableTLS13:       true,
+		CertFile:           *certFile,
+		KeyFile:            *keyFile,
+		CAFile:             *caFile,
+		RequireClientCert:  *requireClientCert,
+		MinVersion:         tls.VersionTLS12,
+		EnableTLS13:        true,
 		PreferServerCipher: true,
+		CheckPolicy:        policy,
+		CheckNames:         append(checkcmd.SplitList(*certNames), tlsutil.HostNames(*address)...),
+		IssuerCAFile:       *issuerCA,
+		ExpiryWarning:      *expiryWarn,
+		IntermediatesFile:  *intermediates,
+		FetchAIA:           *fetchAIA,
 	})
 	if err != nil {
-		log.Fatalf("failed to build TLS config: %v", err)
+		logging.Fatal("failed to build TLS config", "err", err)
 	}
+	tlsCfg = fingerprint.NewPolicy("echo", allowFP, denyFP).Config(tlsCfg)
+	defer tlsutil.MonitorExpiry("server", tlsutil.LeafOf(tlsCfg), *expiryWarn, time.Hour)()
+	ready := health.New()
+	ready.Add("cert", health.Cert(tlsCfg))
 
-	ln, err := tls.Listen("tcp", *address, tlsCfg)
+	tcpLn, err := net.Listen("tcp", *address)
 	if err != nil {
-		log.Fatalf("listen error: %v", err)
+		logging.Fatal("listen error", "err", err)
+	}
+	ready.Set("listener", nil)
+	if *quicAddr != "" {
+		ready.Set("quic_listener", errors.New("not bound yet"))
+	}
+	// Connection limits apply before the TLS layer sees the connection, and
+	// to the TCP peer: behind a proxy that is the proxy itself. QUIC
+	// connections count against the same limits.
+	limited := limit.NewListener(tcpLn, "echo", limit.ConnLimits{
+		MaxConns:   *maxConns,
+		MaxPerIP:   *maxConnsPerIP,
+		Rate:       *connRate,
+		Burst:      *connBurst,
+		PerIPRate:  *connRatePerIP,
+		PerIPBurst: *connBurstPerIP,
+	})
+	var inner net.Listener = limited
+	if len(trustedProxies) > 0 {
+		inner = proxyproto.NewListener(inner, trustedProxies, *proxyTimeout)
+	}
+	ln := inner
+	if !*startTLS {
+		// STARTTLS connections are wrapped when they upgrade.
+		inner = fingerprint.NewListener(inner)
+		ln = tls.NewListener(inner, tlsCfg)
 	}
-	log.Printf("TLS Echo Server listening on %s (mTLS=%v)", *address, *requireClientCert)
+	slog.Info("TLS Echo Server listening", "addr", *address, "mtls", *requireClientCert, "starttls", *startTLS, "frame", frame, "commands", *commands, "hub", *hubMode)
 	defer ln.Close()
 
+	ctx, stop := lifecycle.SignalContext()
+	defer stop()
+	ready.DrainOnDone(ctx)
+	registry := connreg.New("echo-server")
+	if *adminAddr != "" || *adminSocket != "" {
+		adm := admin.New()
+		adm.HandleReadiness(ready)
+		adm.HandleConnections(registry)
+		adm.HandleLogLevel(logLevelVar)
+		adm.HandleDashboard("echo-server")
+		stopAdmin, err := adm.Start(admin.Options{
+			Addr:     *adminAddr,
+			CertFile: *adminCert,
+			KeyFile:  *adminKey,
+			CAFile:   *adminCA,
+			Clients:  checkcmd.SplitList(*adminClients),
+			Readers:  checkcmd.SplitList(*adminReaders),
+			Socket:   *adminSocket,
+		})
+		if err != nil {
+			logging.Fatal(err.Error())
+		}
+		defer stopAdmin()
+	}
+
+	srv := &echoServer{
+		gate: limit.NewHandshakeGate("echo", limit.HandshakeLimits{
+			Timeout:       *hsTimeout,
+			MaxConcurrent: *maxHandshakes,
+			QueueTimeout:  *hsQueue,
+			PerIPRate:     *hsRate,
+			PerIPBurst:    *hsBurst,
+		}),
+		identities: limit.NewIdentityQuota("echo", *maxPerIdentity),
+		conns:      limited,
+		rt:         *readTimeout,
+		wt:         *writeTimeout,
+		frame:      frame,
+		maxFrame:   *maxFrame,
+		commands:   *commands,
+		info:       serverInfo(tlsCfg, frame, *maxFrame),
+		tlsCfg:     tlsCfg,
+		starttls:   *startTLS,
+		registry:   registry,
+		access:     access,
+		ready:      ready,
+		ctx:        ctx,
+	}
+	if *httpMode || *wsMode {
+		mux := http.NewServeMux()
+		hijacked := lifecycle.NewGroup()
+		if *httpMode {
+			slog.Info("serving HTTPS echo", "url", "https://"+*address+"/")
+			mux.Handle("/", echoHTTP(*httpMaxBody))
+		}
+		if *wsMode {
+			slog.Info("serving WebSocket echo", "url", "wss://"+*address+*wsPath)
+			mux.Handle(*wsPath, newWSEcho(ctx, hijacked, checkcmd.SplitList(*wsOrigins), int64(*maxFrame), *wsPing, *readTimeout, *writeTimeout))
+		}
+		srv.serveHTTPS(inner, mux, hijacked, *drainTimeout)
+		return
+	}
+	lifecycle.CloseOnDone(ctx, ln)
+
+	if *hubMode {
+		srv.hub = newHub(*hubQueue, *maxFrame)
+	}
+	quicDone := make(chan struct{})
+	if *quicAddr != "" {
+		go func() {
+			defer close(quicDone)
+			srv.serveQUIC(ctx, *quicAddr, tlsCfg, &quic.Config{
+				HandshakeIdleTimeout: *hsTimeout,
+				MaxIdleTimeout:       *readTimeout,
+				MaxIncomingStreams:   *quicStreams,
+				Allow0RTT:            *quic0RTT,
+			}, *drainTimeout)
+		}()
+	} else {
+		close(quicDone)
+	}
+
+	conns := lifecycle.NewGroup()
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			log.Printf("accept error: %v", err)
+			if lifecycle.IsClosed(err) {
+				break
+			}
+			slog.Error("accept error", "err", err)
 			continue
 		}
-		go handleConn(conn, *readTimeout, *writeTimeout)
+		done := conns.Track(conn)
+		go func() {
+			defer done()
+			srv.handleConn(conn)
+		}()
 	}
+	slog.Info("shutting down: draining connections", "active", conns.Active(), "timeout", *drainTimeout)
+	slog.Info("shutdown complete", "summary", conns.Drain(*drainTimeout).String())
+	<-quicDone
+}
+
+type echoServer struct {
+	gate       *limit.HandshakeGate
+	identities *limit.IdentityQuota
+	conns      *limit.Listener // for QUIC connections
+	rt, wt     time.Duration
+	frame      framing.Mode
+	maxFrame   int
+	commands   bool
+	info       echoproto.Fields
+	hub        *hub
+	tlsCfg     *tls.Config
+	starttls   bool
+	registry   *connreg.Registry
+	access     *accesslog.Log
+	ready      *health.Checker
+	ctx        context.Context // cancelled on shutdown
 }
 
-func handleConn(c net.Conn, rt, wt time.Duration) {
+func (s *echoServer) handleConn(c net.Conn) {
+	connID := s.registry.NextID()
+	lg := slog.With("conn", connID, "remote", c.RemoteAddr().String())
+	acc := accesslog.NewEntry("echo-server", "tcp", connID, proxyproto.PeerAddr(c), c.LocalAddr())
+	if proxyproto.HeaderOf(c) != nil {
+		acc.Client = c.RemoteAddr().String()
+	}
+	var entry *connreg.Entry
+	reason := "client closed"
+	defer func() { s.finish(lg, acc, entry, reason) }()
+
+	if s.starttls {
+		tc, err := s.startTLS(c)
+		if err != nil {
+			lg.Warn("STARTTLS failed", "err", err)
+			reason = "STARTTLS failed: " + err.Error()
+			_ = c.Close()
+			return
+		}
+		c = tc
+	}
 	defer c.Close()
+	var state *tls.ConnectionState
 	if tlsConn, ok := c.(*tls.Conn); ok {
-		if err := tlsConn.Handshake(); err != nil {
-			log.Printf("TLS handshake failed: %v", err)
+		var release func()
+		var refused string
+		entry, release, refused = s.admit(tlsConn, connID, "tcp", lg, acc)
+		if entry == nil {
+			reason = refused
 			return
 		}
-		state := tlsConn.ConnectionState()
-		log.Printf("New TLS connection: %s | version=%x | cipher=%x | mTLS=%v",
-			c.RemoteAddr().String(),
-			state.Version,
-			state.CipherSuite,
-			len(state.PeerCertificates) > 0,
-		)
-	}
-
-	// Use pooled buffer and io.Copy with deadlines to reduce allocations
-	bufPtr := bufpool.Get()
-	defer bufpool.Put(bufPtr)
-	buf := *bufPtr
-
-	reader := deadlineReader{c, rt}
-	writer := deadlineWriter{c, wt}
-	_, _ = io.CopyBuffer(writer, reader, buf)
+		defer release()
+		defer entry.Unregister()
+		cs := tlsConn.ConnectionState()
+		state = &cs
+		c = &connreg.Conn{Conn: c, Entry: entry}
+	}
+
+	var err error
+	if s.hub != nil {
+		// Idle hub members are normal, so reads have no deadline.
+		err = s.hub.serve(c, tlsutil.PeerIdentity(*state), lg, framing.NewReader(c, s.frame, s.maxFrame), framing.NewWriter(deadlineWriter{c, s.wt}, s.frame, s.maxFrame))
+	} else {
+		err = s.serve(c, lg, state)
+	}
+	reason = s.closeReason(err)
+}
+
+// admit runs the gated handshake on tc, then applies the identity quota and
+// registers the connection under id. If the connection must not be served
+// it returns a nil entry and the close reason; otherwise the caller calls
+// release and entry.Unregister when the connection ends.
+func (s *echoServer) admit(tc *tls.Conn, id uint64, transport string, lg *slog.Logger, acc *accesslog.Entry) (entry *connreg.Entry, release func(), reason string) {
+	err := s.gate.Handshake(tc, limit.HostOf(tc.RemoteAddr()))
+	fp := fingerprint.Of(tc)
+	if fp != nil {
+		acc.JA3, acc.JA4 = fp.JA3, fp.JA4
+	}
+	if err != nil {
+		lg.Warn("TLS handshake failed", "reason", limit.FailureReason(err), "fingerprint", fp, "err", err)
+		return nil, nil, "TLS handshake failed: " + err.Error()
+	}
+	if h := proxyproto.HeaderOf(tc); h != nil {
+		lg.Info("proxied connection", "proxy", h.String())
+	}
+	cs := tc.ConnectionState()
+	acc.SetTLS(&cs)
+	peer := tlsutil.PeerIdentity(cs)
+	lg.Info("new TLS connection", logging.TLS(&cs), "identity", peer, "fingerprint", fp)
+	release, ok := s.identities.Acquire(peer)
+	if !ok {
+		lg.Warn("connection limit for identity reached; closing", "identity", peer)
+		return nil, nil, "identity connection limit"
+	}
+	return s.registry.Register(id, transport, "", tc.RemoteAddr(), tc.LocalAddr(), &cs, tc), release, ""
+}
+
+// closeReason is accesslog.Reason, telling a shutdown apart from other
+// server-side closes.
+func (s *echoServer) closeReason(err error) string {
+	if errors.Is(err, net.ErrClosed) && s.ctx.Err() != nil {
+		return "server shutdown"
+	}
+	return accesslog.Reason(err)
+}
+
+// finish reports a finished connection at debug level and writes its access
+// log entry. entry is nil if the connection ended before registration.
+func (s *echoServer) finish(lg *slog.Logger, acc *accesslog.Entry, entry *connreg.Entry, reason string) {
+	if entry != nil {
+		info := entry.Info()
+		acc.BytesIn, acc.BytesOut = info.BytesIn, info.BytesOut
+		if entry.Killed() {
+			reason = "killed via admin API"
+		}
+	}
+	acc.CloseReason = reason
+	if err := s.access.Write(acc); err != nil {
+		lg.Error("access log write failed", "err", err)
+	}
+	lg.Debug("connection closed", "reason", reason, "bytes_in", acc.BytesIn, "bytes_out", acc.BytesOut, "duration", time.Since(acc.Connect).Round(time.Millisecond))
+}
+
+// serve runs the echo session on a TCP connection or a QUIC stream and
+// returns the error that ended it (nil if the client closed or quit).
+func (s *echoServer) serve(rw deadlineConn, lg *slog.Logger, state *tls.ConnectionState) error {
+	reader := deadlineReader{rw, s.rt}
+	writer := deadlineWriter{rw, s.wt}
+	if s.frame == framing.Raw {
+		// Use pooled buffer and io.Copy with deadlines to reduce allocations
+		bufPtr := bufpool.Get()
+		defer bufpool.Put(bufPtr)
+		_, err := io.CopyBuffer(writer, reader, *bufPtr)
+		return err
+	}
+	return s.echoFrames(lg, state, framing.NewReader(reader, s.frame, s.maxFrame), framing.NewWriter(writer, s.frame, s.maxFrame))
+}
+
+// echoFrames reflects one frame at a time, or answers it in -commands mode;
+// oversized frames get an explicit protocol error and end the connection.
+func (s *echoServer) echoFrames(lg *slog.Logger, state *tls.ConnectionState, fr *framing.Reader, fw *framing.Writer) error {
+	for {
+		msg, err := fr.ReadFrame()
+		if err != nil {
+			if errors.Is(err, framing.ErrFrameTooLarge) {
+				lg.Warn("protocol error", "err", err)
+				_ = fw.WriteError(err.Error())
+			} else if !isEOF(err) {
+				lg.Warn("read error", "err", err)
+			}
+			return err
+		}
+		reply, quit := msg, false
+		if s.commands {
+			reply, quit = s.command(state, msg)
+		}
+		if err := fw.WriteFrame(reply); err != nil || quit {
+			return err
+		}
+	}
 }
 
 func isEOF(err error) bool {
 	return err == io.EOF || os.IsTimeout(err)
 }
 
+// deadlineConn is what the echo loop needs from a net.Conn or QUIC stream.
+type deadlineConn interface {
+	io.ReadWriter
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
 type deadlineReader struct {
-	r  net.Conn
+	r  deadlineConn
 	rt time.Duration
 }
 
@@ -106,7 +488,7 @@ func (d deadlineReader) Read(p []byte) (int, error) {
 }
 
 type deadlineWriter struct {
-	w  net.Conn
+	w  deadlineConn
 	wt time.Duration
 }
 
@@ -114,5 +496,3 @@ func (d deadlineWriter) Write(p []byte) (int, error) {
 	_ = d.w.SetWriteDeadline(time.Now().Add(d.wt))
 	return d.w.Write(p)
 }
-
-
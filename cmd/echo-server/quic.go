@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"tls-lab/internal/accesslog"
+	"tls-lab/internal/connreg"
+	"tls-lab/internal/lifecycle"
+	"tls-lab/internal/logging"
+	"tls-lab/internal/tlsutil"
+)
+
+// quicALPN must match echo-client's -quic mode.
+const quicALPN = "tls-lab-echo"
+
+type quicListener interface {
+	Accept(context.Context) (*quic.Conn, error)
+	Close() error
+}
+
+// quicCloser closes a QUIC connection for drains and admin kills.
+type quicCloser struct{ *quic.Conn }
+
+func (c quicCloser) Close() error { return c.CloseWithError(0, "server shutting down") }
+
+// countedStream reports a stream's traffic to the connection registry.
+type countedStream struct {
+	*quic.Stream
+	entry *connreg.Entry
+}
+
+func (s countedStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	s.entry.AddIn(n)
+	return n, err
+}
+
+func (s countedStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	s.entry.AddOut(n)
+	return n, err
+}
+
+// serveQUIC runs the echo service over QUIC on addr until ctx is cancelled.
+// Every bidirectional stream is an independent echo session with the same
+// framing and command handling as a TCP connection.
+func (s *echoServer) serveQUIC(ctx context.Context, addr string, cfg *tls.Config, qcfg *quic.Config, drain time.Duration) {
+	cfg = cfg.Clone()
+	cfg.NextProtos = []string{quicALPN}
+	cfg.MinVersion = tls.VersionTLS13
+
+	var (
+		ln  quicListener
+		err error
+	)
+	if qcfg.Allow0RTT {
+		ln, err = quic.ListenAddrEarly(addr, cfg, qcfg)
+	} else {
+		ln, err = quic.ListenAddr(addr, cfg, qcfg)
+	}
+	if err != nil {
+		logging.Fatal("quic listen error", "err", err)
+	}
+	s.ready.Set("quic_listener", nil)
+	slog.Info("QUIC Echo Server listening", "addr", addr, "0rtt", qcfg.Allow0RTT, "max_streams", qcfg.MaxIncomingStreams)
+
+	conns := lifecycle.NewGroup()
+	for {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, quic.ErrServerClosed) {
+				break
+			}
+			slog.Error("quic accept error", "err", err)
+			continue
+		}
+		release, ok := s.conns.Admit(conn.RemoteAddr())
+		if !ok {
+			_ = conn.CloseWithError(0, "connection limit reached")
+			continue
+		}
+		done := conns.Track(quicCloser{conn})
+		go func() {
+			defer done()
+			defer release()
+			s.handleQUIC(conn)
+		}()
+	}
+	_ = ln.Close()
+	slog.Info("shutting down: draining QUIC connections", "active", conns.Active(), "timeout", drain)
+	slog.Info("QUIC shutdown complete", "summary", conns.Drain(drain).String())
+}
+
+// quicCloseReason describes the error that closed a QUIC connection.
+func (s *echoServer) quicCloseReason(err error) string {
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) {
+		switch {
+		case appErr.Remote:
+			return "client closed"
+		case s.ctx.Err() != nil:
+			return "server shutdown"
+		}
+		return "closed by server"
+	}
+	return accesslog.Reason(err)
+}
+
+func (s *echoServer) handleQUIC(conn *quic.Conn) {
+	defer conn.CloseWithError(0, "")
+	connID := s.registry.NextID()
+	lg := slog.With("conn", connID, "remote", conn.RemoteAddr().String())
+	acc := accesslog.NewEntry("echo-server", "quic", connID, conn.RemoteAddr(), conn.LocalAddr())
+	var entry *connreg.Entry
+	reason := "client closed"
+	defer func() { s.finish(lg, acc, entry, reason) }()
+
+	// With -quic-0rtt the listener returns connections before the
+	// handshake is done, when the client certificate is not known yet.
+	select {
+	case <-conn.HandshakeComplete():
+	case <-conn.Context().Done():
+		err := context.Cause(conn.Context())
+		lg.Warn("QUIC handshake failed", "err", err)
+		reason = "TLS handshake failed: " + err.Error()
+		return
+	}
+	cs := conn.ConnectionState()
+	state := &cs.TLS
+	acc.SetTLS(state)
+	id := tlsutil.PeerIdentity(*state)
+	lg.Info("new QUIC connection", logging.TLS(state), "identity", id, "0rtt", cs.Used0RTT)
+	release, ok := s.identities.Acquire(id)
+	if !ok {
+		lg.Warn("connection limit for identity reached; closing", "identity", id)
+		reason = "identity connection limit"
+		return
+	}
+	defer release()
+	entry = s.registry.Register(connID, "quic", "", conn.RemoteAddr(), conn.LocalAddr(), state, quicCloser{conn})
+	defer entry.Unregister()
+
+	for {
+		stream, err := conn.AcceptStream(conn.Context())
+		if err != nil {
+			reason = s.quicCloseReason(err)
+			return
+		}
+		go func() {
+			defer stream.Close()
+			s.serve(countedStream{stream, entry}, lg.With("stream", int64(stream.StreamID())), state)
+		}()
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"tls-lab/internal/connreg"
+	"tls-lab/internal/framing"
+	"tls-lab/internal/health"
+	"tls-lab/internal/limit"
+)
+
+// testTLS returns an mTLS server config and a matching client config whose
+// certificate identity is "client".
+func testTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	issue := func(tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+		tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	ca, caKey := issue(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	srv, srvKey := issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	cli, cliKey := issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{srv.Raw}, PrivateKey: srvKey}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	client = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cli.Raw}, PrivateKey: cliKey}},
+		RootCAs:      pool,
+		ServerName:   "localhost",
+		NextProtos:   []string{quicALPN},
+	}
+	return server, client
+}
+
+// freeUDPAddr returns a loopback UDP address that was free a moment ago.
+func freeUDPAddr(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().String()
+}
+
+// echo sends msg on a new stream of conn and reads it back.
+func echo(ctx context.Context, conn *quic.Conn, msg string) error {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	_ = stream.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stream.Write([]byte(msg)); err != nil {
+		return err
+	}
+	_, err = io.ReadFull(stream, make([]byte, len(msg)))
+	return err
+}
+
+// TestQUIC0RTTIdentityQuota checks that with -quic-0rtt, whose listener
+// returns connections before the handshake is done, the client certificate
+// is known when the identity quota is applied and the connection registered.
+func TestQUIC0RTTIdentityQuota(t *testing.T) {
+	serverCfg, clientCfg := testTLS(t)
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpLn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &echoServer{
+		gate:       limit.NewHandshakeGate("test", limit.HandshakeLimits{}),
+		identities: limit.NewIdentityQuota("test", 1),
+		conns:      limit.NewListener(tcpLn, "test", limit.ConnLimits{}),
+		rt:         5 * time.Second,
+		wt:         5 * time.Second,
+		frame:      framing.Raw,
+		maxFrame:   framing.DefaultMaxFrame,
+		registry:   connreg.New("test"),
+		ready:      health.New(),
+		ctx:        ctx,
+	}
+	addr := freeUDPAddr(t)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.serveQUIC(ctx, addr, serverCfg, &quic.Config{Allow0RTT: true, MaxIncomingStreams: 10}, time.Second)
+	}()
+	defer func() { cancel(); <-served }()
+
+	dialCtx, dialCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer dialCancel()
+	// waitConns waits for the registry to hold n connections.
+	waitConns := func(n int) []connreg.Info {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			list := s.registry.List()
+			if len(list) == n {
+				return list
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("registry has %d connections, want %d", len(list), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// A first connection gets the session ticket for 0-RTT.
+	resuming := clientCfg.Clone()
+	resuming.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	var warm *quic.Conn
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if warm, err = quic.DialAddr(dialCtx, addr, resuming, nil); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := echo(dialCtx, warm, "warm-up"); err != nil {
+		t.Fatal(err)
+	}
+	_ = warm.CloseWithError(0, "")
+	waitConns(0)
+
+	early, err := quic.DialAddrEarly(dialCtx, addr, resuming, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer early.CloseWithError(0, "")
+	if err := echo(dialCtx, early, "early"); err != nil {
+		t.Fatal(err)
+	}
+	if !early.ConnectionState().Used0RTT {
+		t.Fatal("connection did not use 0-RTT")
+	}
+	if list := waitConns(1); list[0].Identity != "client" {
+		t.Errorf("0-RTT connection registered with identity %q, want client", list[0].Identity)
+	}
+
+	// The identity has its one connection, so a second one is closed,
+	// whether it resumes with 0-RTT or does a full handshake.
+	full := clientCfg.Clone()
+	for name, cfg := range map[string]*tls.Config{"0-rtt": resuming, "full handshake": full} {
+		conn, err := quic.DialAddrEarly(dialCtx, addr, cfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := echo(dialCtx, conn, "over quota"); err == nil {
+			t.Errorf("%s: connection over the identity quota was served", name)
+		}
+		_ = conn.CloseWithError(0, "")
+	}
+	waitConns(1)
+}
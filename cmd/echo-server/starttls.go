@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"tls-lab/internal/fingerprint"
+	"tls-lab/internal/framing"
+)
+
+// maxPlainLine bounds a plaintext command before the upgrade.
+const maxPlainLine = 512
+
+var errDataAfterSTARTTLS = errors.New("client sent data after STARTTLS before the handshake")
+
+// startTLS runs the plaintext phase of -starttls mode: every line but
+// STARTTLS is refused, and on STARTTLS the connection is upgraded in place.
+//
+// Anything the client pipelined after STARTTLS is already buffered in
+// plaintext; it is never fed to the TLS layer (the classic STARTTLS
+// command-injection bug) and the connection is dropped instead.
+func (s *echoServer) startTLS(c net.Conn) (*tls.Conn, error) {
+	_ = c.SetDeadline(time.Now().Add(s.rt))
+	defer c.SetDeadline(time.Time{})
+
+	fr := framing.NewReader(c, framing.Line, maxPlainLine)
+	fw := framing.NewWriter(c, framing.Line, maxPlainLine)
+	if err := fw.WriteFrame([]byte("READY send STARTTLS to begin TLS")); err != nil {
+		return nil, err
+	}
+	for {
+		line, err := fr.ReadFrame()
+		if err != nil {
+			return nil, fmt.Errorf("before STARTTLS: %w", err)
+		}
+		if !strings.EqualFold(strings.TrimSpace(string(line)), "STARTTLS") {
+			if err := fw.WriteFrame([]byte("ERR STARTTLS required")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if fr.Buffered() > 0 {
+			_ = fw.WriteError(errDataAfterSTARTTLS.Error())
+			return nil, errDataAfterSTARTTLS
+		}
+		if err := fw.WriteFrame([]byte("OK begin TLS")); err != nil {
+			return nil, err
+		}
+		return tls.Server(fingerprint.Wrap(c), s.tlsCfg), nil
+	}
+}
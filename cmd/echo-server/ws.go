@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"tls-lab/internal/lifecycle"
+	"tls-lab/internal/logging"
+)
+
+// wsEcho is the wss:// endpoint: text and binary messages are echoed with
+// their type, pings are answered, and the server pings idle clients.
+// Messages over maxMessage are refused with close code 1009. When ctx is
+// cancelled every session is sent a 1001 (going away) close frame; sessions
+// are tracked in a Group because http.Server neither waits for nor closes
+// hijacked connections.
+type wsEcho struct {
+	upgrader   websocket.Upgrader
+	ctx        context.Context
+	sessions   *lifecycle.Group
+	maxMessage int64
+	ping       time.Duration
+	rt, wt     time.Duration
+}
+
+func newWSEcho(ctx context.Context, sessions *lifecycle.Group, origins []string, maxMessage int64, ping, rt, wt time.Duration) *wsEcho {
+	ws := &wsEcho{ctx: ctx, sessions: sessions, maxMessage: maxMessage, ping: ping, rt: rt, wt: wt}
+	if len(origins) > 0 {
+		ws.upgrader.CheckOrigin = originAllowed(origins)
+	}
+	return ws
+}
+
+// originAllowed accepts requests without an Origin header (non-browser
+// clients) and browser requests whose origin is listed; "*" allows all.
+func originAllowed(origins []string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, o := range origins {
+			if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+				return true
+			}
+		}
+		logging.FromContext(r.Context()).Warn("websocket: origin not allowed", "origin", origin)
+		return false
+	}
+}
+
+func (ws *wsEcho) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has already replied
+	}
+	defer ws.sessions.Track(c)()
+	defer c.Close()
+	c.SetReadLimit(ws.maxMessage)
+	lg := logging.FromContext(r.Context())
+	lg.Info("websocket opened", "path", r.URL.Path)
+
+	extend := func() { _ = c.SetReadDeadline(time.Now().Add(ws.rt)) }
+	extend()
+	c.SetPongHandler(func(string) error { extend(); return nil })
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		var tick <-chan time.Time
+		if ws.ping > 0 {
+			t := time.NewTicker(ws.ping)
+			defer t.Stop()
+			tick = t.C
+		}
+		for {
+			select {
+			case <-tick:
+				if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(ws.wt)); err != nil {
+					return
+				}
+			case <-ws.ctx.Done():
+				// The read loop ends when the client answers the close.
+				msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+				_ = c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(ws.wt))
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		mt, msg, err := c.ReadMessage()
+		if err != nil {
+			var ce *websocket.CloseError
+			switch {
+			case errors.As(err, &ce):
+				lg.Info("websocket closed", "code", ce.Code, "reason", ce.Text)
+			case errors.Is(err, websocket.ErrReadLimit):
+				lg.Warn("websocket message too large; closed with 1009", "max", ws.maxMessage)
+			default:
+				lg.Warn("websocket read error", "err", err)
+			}
+			return
+		}
+		extend()
+		_ = c.SetWriteDeadline(time.Now().Add(ws.wt))
+		if err := c.WriteMessage(mt, msg); err != nil {
+			return
+		}
+	}
+}
@@ -4,7 +4,8 @@ import (
 	"context"
 	"crypto/tls"
 	"flag"
-	"log"
+	"log/slog"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc"
@@ -12,6 +13,7 @@ import (
 	"google.golang.org/grpc/encoding"
 
 	"tls-lab/internal/grpcjson"
+	"tls-lab/internal/logging"
 	"tls-lab/internal/tlsutil"
 )
 
@@ -32,9 +34,15 @@ func main() {
 		keyFile    = flag.String("key", "", "Client key (PEM, optional for mTLS)")
 		message    = flag.String("msg", "hello grpc", "Message to echo")
 		timeout    = flag.Duration("timeout", 5*time.Second, "RPC timeout")
+		logFormat  = flag.String("log-format", "text", "Log output: text or json")
+		logLevel   = flag.String("log-level", "info", "Log level: debug, info, warn or error")
 	)
 	flag.Parse()
 
+	if _, err := logging.Setup(*logFormat, *logLevel); err != nil {
+		logging.Fatal(err.Error())
+	}
+
 	tcfg, err := tlsutil.NewClientTLSConfig(tlsutil.ClientTLSOptions{
 		CAFile:      *caFile,
 		CertFile:    *certFile,
@@ -44,7 +52,7 @@ func main() {
 		EnableTLS13: true,
 	})
 	if err != nil {
-		log.Fatalf("tls: %v", err)
+		logging.Fatal("failed to build TLS config", "err", err)
 	}
 
 	cc, err := grpc.Dial(
@@ -52,10 +60,14 @@ func main() {
 		grpc.WithTransportCredentials(credentials.NewTLS(tcfg)),
 		grpc.WithDefaultCallOptions(grpc.ForceCodec(encoding.GetCodec(grpcjson.Name))),
 		grpc.WithBlock(),
+		grpc.WithReturnConnectionError(),
 		grpc.WithTimeout(*timeout),
 	)
 	if err != nil {
-		log.Fatalf("dial: %v", err)
+		if r := handshakeFailure(err); r != "" {
+			logging.Fatal("TLS handshake failed", "addr", *addr, "reason", r, "hint", r.Hint(), "err", err)
+		}
+		logging.Fatal("dial error", "addr", *addr, "err", err)
 	}
 	defer cc.Close()
 
@@ -65,9 +77,16 @@ func main() {
 	req := &EchoRequest{Message: *message}
 	var resp EchoReply
 	if err := cc.Invoke(ctx, "/echo.Echo/Say", req, &resp); err != nil {
-		log.Fatalf("rpc: %v", err)
+		logging.Fatal("rpc error", "err", err)
 	}
-	log.Printf("reply: %s", resp.Message)
+	slog.Info("reply", "message", resp.Message)
 }
 
-
+// handshakeFailure classifies err if it is a failed TLS handshake, which
+// gRPC only reports as text.
+func handshakeFailure(err error) tlsutil.HandshakeReason {
+	if !strings.Contains(err.Error(), "authentication handshake failed") {
+		return ""
+	}
+	return tlsutil.ClassifyHandshake(err)
+}
@@ -4,17 +4,22 @@ import (
 	"context"
 	"crypto/tls"
 	"flag"
-	"log"
+	"log/slog"
 	"net"
-	"net/http"
-
-	_ "net/http/pprof"
+	"os"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 
 	"tls-lab/api/echo"
+	"tls-lab/internal/accesslog"
+	"tls-lab/internal/admin"
+	"tls-lab/internal/checkcmd"
+	"tls-lab/internal/health"
+	"tls-lab/internal/lifecycle"
+	"tls-lab/internal/logging"
 	"tls-lab/internal/tlsutil"
 )
 
@@ -28,20 +33,45 @@ func (s *echoServer) Say(_ context.Context, in *echo.EchoRequest) (*echo.EchoRep
 
 func main() {
 	var (
-		addr      = flag.String("addr", "0.0.0.0:9443", "gRPC listen address")
-		certFile  = flag.String("cert", "certs/server.crt", "Server cert (PEM)")
-		keyFile   = flag.String("key", "certs/server.key", "Server key (PEM)")
-		caFile    = flag.String("ca", "certs/ca.crt", "Client CA for mTLS (optional)")
-		mtls      = flag.Bool("mtls", false, "Require client certs (mTLS)")
-		pprofAddr = flag.String("pprof", "", "pprof listen address (e.g. 127.0.0.1:6063); empty to disable")
+		addr          = flag.String("addr", "0.0.0.0:9443", "gRPC listen address")
+		certFile      = flag.String("cert", "certs/server.crt", "Server cert (PEM)")
+		keyFile       = flag.String("key", "certs/server.key", "Server key (PEM)")
+		caFile        = flag.String("ca", "certs/ca.crt", "Client CA for mTLS (optional)")
+		mtls          = flag.Bool("mtls", false, "Require client certs (mTLS)")
+		certCheck     = flag.String("cert-check", "warn", "Startup certificate check policy: off, warn or fail")
+		certNames     = flag.String("cert-names", "localhost", "Comma-separated names the server cert must cover (listen host is added)")
+		issuerCA      = flag.String("issuer-ca", "certs/ca.crt", "CA expected to issue the server cert; empty for system roots")
+		expiryWarn    = flag.Duration("expiry-warn", 30*24*time.Hour, "Warn when the server cert has less validity left than this")
+		intermediates = flag.String("intermediates", "", "Intermediate CA bundle (PEM) used to build the served chain")
+		fetchAIA      = flag.Bool("fetch-aia", false, "Fetch missing issuers from the certificate AIA URLs")
+		hsTimeout     = flag.Duration("handshake-timeout", 10*time.Second, "Deadline for the TLS handshake of new connections")
+		drainTimeout  = flag.Duration("drain-timeout", 15*time.Second, "On shutdown, wait this long for in-flight RPCs before stopping")
+		healthPoll    = flag.Duration("health-interval", 5*time.Second, "How often readiness is re-checked for the grpc.health.v1 service")
+		accessLog     = flag.String("access-log", "", "Append one JSON line per connection (TLS, client cert, bytes, close reason) to this file; empty to disable")
+		accessSize    = flag.Int64("access-log-max-size", 100<<20, "Rotate the access log at this many bytes (0 = no size limit)")
+		accessAge     = flag.Duration("access-log-max-age", 24*time.Hour, "Rotate the access log after this long (0 = no age limit)")
+		adminAddr     = flag.String("admin-addr", "", "Admin API listen address (mTLS: dashboard, health, metrics, pprof, log level); empty to disable")
+		adminCert     = flag.String("admin-cert", "certs/server.crt", "Admin API server certificate (PEM)")
+		adminKey      = flag.String("admin-key", "certs/server.key", "Admin API server key (PEM)")
+		adminCA       = flag.String("admin-ca", "certs/ca.crt", "CA that issues admin client certificates (PEM)")
+		adminClients  = flag.String("admin-clients", "", "Comma-separated client certificate identities with full admin access; empty (with -admin-readers) allows any cert from -admin-ca")
+		adminReaders  = flag.String("admin-readers", "", "Comma-separated client certificate identities allowed read-only admin access (GET, no pprof)")
+		adminSocket   = flag.String("admin-socket", "", "Also serve the admin API on this Unix socket (owner-only, no TLS); empty to disable")
+		logFormat     = flag.String("log-format", "text", "Log output: text or json")
+		logLevel      = flag.String("log-level", "info", "Log level: debug, info, warn or error (changeable at runtime via the admin API)")
 	)
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(checkcmd.Run("grpcpb-server", os.Args[2:]))
+	}
 	flag.Parse()
 
-	if *pprofAddr != "" {
-		go func() {
-			log.Printf("pprof listening on http://%s/debug/pprof/", *pprofAddr)
-			_ = http.ListenAndServe(*pprofAddr, nil)
-		}()
+	logLevelVar, err := logging.Setup(*logFormat, *logLevel)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	policy, err := tlsutil.ParseCheckPolicy(*certCheck)
+	if err != nil {
+		logging.Fatal(err.Error())
 	}
 
 	tcfg, err := tlsutil.NewServerTLSConfig(tlsutil.ServerTLSOptions{
@@ -52,25 +82,81 @@ func main() {
 		MinVersion:         tls.VersionTLS12,
 		EnableTLS13:        true,
 		PreferServerCipher: true,
+		CheckPolicy:        policy,
+		CheckNames:         append(checkcmd.SplitList(*certNames), tlsutil.HostNames(*addr)...),
+		IssuerCAFile:       *issuerCA,
+		ExpiryWarning:      *expiryWarn,
+		IntermediatesFile:  *intermediates,
+		FetchAIA:           *fetchAIA,
 	})
 	if err != nil {
-		log.Fatalf("tls: %v", err)
+		logging.Fatal("failed to build TLS config", "err", err)
 	}
+	defer tlsutil.MonitorExpiry("server", tlsutil.LeafOf(tcfg), *expiryWarn, time.Hour)()
+	ready := health.New()
+	ready.Add("cert", health.Cert(tcfg))
 
 	lis, err := net.Listen("tcp", *addr)
 	if err != nil {
-		log.Fatalf("listen: %v", err)
+		logging.Fatal("listen error", "err", err)
 	}
 	defer lis.Close()
+	ready.Set("listener", nil)
+
+	conns := &logging.GRPCConns{Server: "grpcpb-server"}
+	if *accessLog != "" {
+		conns.Access, err = accesslog.Open(*accessLog, *accessSize, *accessAge)
+		if err != nil {
+			logging.Fatal(err.Error())
+		}
+		defer conns.Access.Close()
+	}
 
-	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(tcfg)))
+	rpcs := &lifecycle.RPCTracker{}
+	grpcServer := grpc.NewServer(
+		grpc.Creds(conns.Creds(credentials.NewTLS(tcfg))),
+		grpc.ConnectionTimeout(*hsTimeout),
+		grpc.StatsHandler(conns),
+		grpc.ChainUnaryInterceptor(rpcs.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(rpcs.StreamInterceptor()),
+	)
 	echo.RegisterEchoServer(grpcServer, &echoServer{})
 	reflection.Register(grpcServer)
 
-	log.Printf("gRPC PB Echo Server on %s (mTLS=%v)", *addr, *mtls)
+	ctx, stop := lifecycle.SignalContext()
+	defer stop()
+	ready.DrainOnDone(ctx)
+	ready.RegisterGRPC(ctx, grpcServer, *healthPoll, nil)
+	if *adminAddr != "" || *adminSocket != "" {
+		adm := admin.New()
+		adm.HandleReadiness(ready)
+		adm.HandleLogLevel(logLevelVar)
+		adm.HandleDashboard("grpcpb-server")
+		stopAdmin, err := adm.Start(admin.Options{
+			Addr:     *adminAddr,
+			CertFile: *adminCert,
+			KeyFile:  *adminKey,
+			CAFile:   *adminCA,
+			Clients:  checkcmd.SplitList(*adminClients),
+			Readers:  checkcmd.SplitList(*adminReaders),
+			Socket:   *adminSocket,
+		})
+		if err != nil {
+			logging.Fatal(err.Error())
+		}
+		defer stopAdmin()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		slog.Info("shutting down: draining RPCs", "active", rpcs.Active(), "timeout", *drainTimeout)
+		slog.Info("shutdown complete", "summary", lifecycle.StopGRPC(grpcServer, rpcs, *drainTimeout).String())
+	}()
+
+	slog.Info("gRPC PB Echo Server listening", "addr", *addr, "mtls", *mtls)
 	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatalf("serve: %v", err)
+		logging.Fatal("serve error", "err", err)
 	}
+	<-stopped
 }
-
-
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"tls-lab/internal/logging"
+	"tls-lab/internal/tlsutil"
+)
+
+type report struct {
+	Findings []tlsutil.LintFinding `json:"findings"`
+	Summary  map[string]int        `json:"summary"`
+	Pass     bool                  `json:"pass"`
+}
+
+func main() {
+	var (
+		mode       = flag.String("mode", "server", "Options to lint: server, client or none (certificate files only)")
+		certFile   = flag.String("cert", "certs/server.crt", "Certificate (PEM)")
+		keyFile    = flag.String("key", "certs/server.key", "Private key (PEM)")
+		caFile     = flag.String("ca", "certs/ca.crt", "CA file (client CA for server mode, trust store for client mode)")
+		mtls       = flag.Bool("mtls", false, "Server requires client certificates")
+		minVersion = flag.String("min-version", "1.2", "MinVersion: 1.0, 1.1, 1.2, 1.3 or 0 (unset)")
+		tls13      = flag.Bool("tls13", true, "TLS 1.3 enabled")
+		certCheck  = flag.String("cert-check", "warn", "Server startup cert check policy being linted")
+		format     = flag.String("format", "json", "Output format: json or text")
+		failOn     = flag.String("fail-on", "error", "Exit non-zero when a finding is at least this severe: info, warn or error")
+		logFormat  = flag.String("log-format", "text", "Log output (stderr): text or json")
+		logLevel   = flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: tlslint [flags] [cert.pem ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if _, err := logging.Setup(*logFormat, *logLevel); err != nil {
+		logging.Fatal(err.Error())
+	}
+
+	mv, err := parseVersion(*minVersion)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	failSeverity, err := tlsutil.ParseSeverity(*failOn)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	in := tlsutil.LintInput{CertFiles: flag.Args()}
+	switch *mode {
+	case "server":
+		policy, err := tlsutil.ParseCheckPolicy(*certCheck)
+		if err != nil {
+			logging.Fatal(err.Error())
+		}
+		in.Server = &tlsutil.ServerTLSOptions{
+			CertFile:          *certFile,
+			KeyFile:           *keyFile,
+			CAFile:            *caFile,
+			RequireClientCert: *mtls,
+			MinVersion:        mv,
+			EnableTLS13:       *tls13,
+			CheckPolicy:       policy,
+		}
+	case "client":
+		in.Client = &tlsutil.ClientTLSOptions{
+			CAFile:      *caFile,
+			CertFile:    *certFile,
+			KeyFile:     *keyFile,
+			MinVersion:  mv,
+			EnableTLS13: *tls13,
+		}
+	case "none":
+	default:
+		logging.Fatal("unknown -mode", "mode", *mode)
+	}
+
+	r := report{Findings: tlsutil.Lint(in), Summary: map[string]int{}, Pass: true}
+	for _, f := range r.Findings {
+		r.Summary[string(f.Severity)]++
+		if tlsutil.SeverityAtLeast(f.Severity, failSeverity) {
+			r.Pass = false
+		}
+	}
+	if r.Findings == nil {
+		r.Findings = []tlsutil.LintFinding{}
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(r)
+	case "text":
+		for _, f := range r.Findings {
+			fmt.Printf("%-5s %-22s %s: %s\n", f.Severity, f.ID, f.Target, f.Message)
+			if f.Remediation != "" {
+				fmt.Printf("      fix: %s\n", f.Remediation)
+			}
+		}
+		fmt.Printf("errors=%d warnings=%d info=%d pass=%v\n",
+			r.Summary[string(tlsutil.SeverityError)], r.Summary[string(tlsutil.SeverityWarn)], r.Summary[string(tlsutil.SeverityInfo)], r.Pass)
+	default:
+		logging.Fatal("unknown -format", "format", *format)
+	}
+	if !r.Pass {
+		os.Exit(1)
+	}
+}
+
+func parseVersion(s string) (uint16, error) {
+	switch s {
+	case "0", "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", s)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"tls-lab/internal/checkcmd"
+	"tls-lab/internal/logging"
+	"tls-lab/internal/tlsutil"
+)
+
+type certDesc struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	NotAfter  time.Time `json:"not_after"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	IPs       []string  `json:"ips,omitempty"`
+	KeyType   string    `json:"key_type"`
+	Signature string    `json:"signature"`
+}
+
+type certInfo struct {
+	Chain       []certDesc `json:"chain"`
+	Verified    bool       `json:"verified"`
+	VerifyError string     `json:"verify_error,omitempty"`
+	Stapled     bool       `json:"ocsp_stapled"`
+}
+
+type check struct {
+	Name    string `json:"name"`
+	Pass    bool   `json:"pass"`
+	Details string `json:"details,omitempty"`
+}
+
+type result struct {
+	Address      string   `json:"address"`
+	ServerName   string   `json:"server_name"`
+	Versions     []string `json:"versions"`
+	CipherSuites []string `json:"cipher_suites_tls12"` // server preference order
+	TLS13Suite   string   `json:"cipher_suite_tls13,omitempty"`
+	Groups       []string `json:"groups"`
+	ALPN         []string `json:"alpn"`
+	Certificate  certInfo `json:"certificate"`
+	Resumption   bool     `json:"resumption"`
+	Profile      string   `json:"profile"`
+	Checks       []check  `json:"checks"`
+	Pass         bool     `json:"pass"`
+}
+
+func main() {
+	var (
+		addr       = flag.String("addr", "127.0.0.1:8443", "Endpoint to scan (host:port)")
+		serverName = flag.String("servername", "localhost", "SNI and name to verify the certificate against")
+		caFile     = flag.String("ca", "certs/ca.crt", "CA used to verify the chain (PEM); empty for system roots")
+		profile    = flag.String("profile", "default", "tlsutil profile to check against: "+strings.Join(tlsutil.ProfileNames(), ", "))
+		alpn       = flag.String("alpn", "h2,http/1.1,grpc-exp", "Comma-separated ALPN protocols to probe")
+		timeout    = flag.Duration("timeout", 5*time.Second, "Per-probe timeout")
+		format     = flag.String("format", "json", "Output format: json or text")
+		logFormat  = flag.String("log-format", "text", "Log output (stderr): text or json")
+		logLevel   = flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	)
+	flag.Parse()
+
+	if _, err := logging.Setup(*logFormat, *logLevel); err != nil {
+		logging.Fatal(err.Error())
+	}
+	prof, err := tlsutil.ProfileByName(*profile)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	p := &prober{addr: *addr, serverName: *serverName, timeout: *timeout}
+	if *caFile != "" {
+		b, err := os.ReadFile(*caFile)
+		if err != nil {
+			logging.Fatal("read CA file", "err", err)
+		}
+		p.roots = x509.NewCertPool()
+		if ok := p.roots.AppendCertsFromPEM(b); !ok {
+			logging.Fatal("append CA certs failed")
+		}
+	}
+	if c, err := net.DialTimeout("tcp", *addr, *timeout); err != nil {
+		logging.Fatal("connect error", "addr", *addr, "err", err)
+	} else {
+		c.Close()
+	}
+
+	res := scan(p, prof, checkcmd.SplitList(*alpn))
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(res)
+	case "text":
+		printText(res)
+	default:
+		logging.Fatal("unknown -format", "format", *format)
+	}
+	if !res.Pass {
+		os.Exit(1)
+	}
+}
+
+func scan(p *prober, prof tlsutil.Profile, alpn []string) result {
+	res := result{Address: p.addr, ServerName: p.serverName, Profile: prof.Name}
+
+	versions := p.versions()
+	for _, v := range versions {
+		res.Versions = append(res.Versions, tls.VersionName(v))
+	}
+	var suites []uint16
+	if hasVersion(versions, tls.VersionTLS12) {
+		suites = p.suites12()
+		for _, id := range suites {
+			res.CipherSuites = append(res.CipherSuites, tls.CipherSuiteName(id))
+		}
+	}
+	if id, ok := p.suite13(); ok {
+		res.TLS13Suite = tls.CipherSuiteName(id)
+	}
+	groups := p.groups()
+	for _, g := range groups {
+		res.Groups = append(res.Groups, g.String())
+	}
+	res.ALPN = p.alpn(alpn)
+	info, chainErr := p.chain()
+	if chainErr == nil {
+		res.Certificate = info
+	}
+	res.Resumption, _ = p.resumption()
+
+	// Compare against the profile.
+	var bad []string
+	for _, v := range versions {
+		if v < prof.MinVersion || v > prof.MaxVersion {
+			bad = append(bad, tls.VersionName(v))
+		}
+	}
+	res.add("versions", len(bad) == 0 && len(versions) > 0, bad)
+	res.add("min-version-supported", hasVersion(versions, prof.MinVersion), nil)
+
+	bad = nil
+	for _, id := range suites {
+		if !prof.AllowsSuite(id) {
+			bad = append(bad, tls.CipherSuiteName(id))
+		}
+	}
+	res.add("cipher-suites", len(bad) == 0, bad)
+
+	bad = nil
+	for _, g := range groups {
+		if !prof.AllowsCurve(g) {
+			bad = append(bad, g.String())
+		}
+	}
+	res.add("groups", len(bad) == 0 && len(groups) > 0, bad)
+
+	var certIssues []string
+	if chainErr != nil {
+		certIssues = append(certIssues, "no certificate: "+chainErr.Error())
+	} else if !res.Certificate.Verified {
+		certIssues = append(certIssues, res.Certificate.VerifyError)
+	}
+	res.add("certificate", len(certIssues) == 0, certIssues)
+
+	res.Pass = true
+	for _, c := range res.Checks {
+		res.Pass = res.Pass && c.Pass
+	}
+	return res
+}
+
+func (r *result) add(name string, pass bool, details []string) {
+	r.Checks = append(r.Checks, check{Name: name, Pass: pass, Details: strings.Join(details, ", ")})
+}
+
+func printText(r result) {
+	fmt.Printf("%s (SNI %s)\n", r.Address, r.ServerName)
+	fmt.Printf("  versions:     %s\n", strings.Join(r.Versions, ", "))
+	fmt.Printf("  TLS 1.2:      %s\n", strings.Join(r.CipherSuites, ", "))
+	fmt.Printf("  TLS 1.3:      %s\n", r.TLS13Suite)
+	fmt.Printf("  groups:       %s\n", strings.Join(r.Groups, ", "))
+	fmt.Printf("  ALPN:         %s\n", strings.Join(r.ALPN, ", "))
+	fmt.Printf("  stapling:     %v\n", r.Certificate.Stapled)
+	fmt.Printf("  resumption:   %v\n", r.Resumption)
+	for i, c := range r.Certificate.Chain {
+		fmt.Printf("  cert[%d]:      %s (issuer %s, expires %s)\n", i, c.Subject, c.Issuer, c.NotAfter.Format("2006-01-02"))
+	}
+	fmt.Printf("profile %s:\n", r.Profile)
+	for _, c := range r.Checks {
+		status := "PASS"
+		if !c.Pass {
+			status = "FAIL"
+		}
+		fmt.Printf("  %s %-22s %s\n", status, c.Name, c.Details)
+	}
+}
+
+func hasVersion(vs []uint16, v uint16) bool {
+	for _, x := range vs {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+type prober struct {
+	addr       string
+	serverName string
+	roots      *x509.CertPool
+	timeout    time.Duration
+}
+
+// handshake dials addr and runs a TLS handshake with cfg. The peer chain is
+// never verified here; verification is reported separately.
+func (p *prober) handshake(cfg *tls.Config) (*tls.Conn, tls.ConnectionState, error) {
+	cfg.ServerName = p.serverName
+	cfg.InsecureSkipVerify = true
+	dialer := &net.Dialer{Timeout: p.timeout}
+	raw, err := dialer.Dial("tcp", p.addr)
+	if err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	_ = raw.SetDeadline(time.Now().Add(p.timeout))
+	conn := tls.Client(raw, cfg)
+	if err := conn.Handshake(); err != nil {
+		raw.Close()
+		return nil, tls.ConnectionState{}, err
+	}
+	return conn, conn.ConnectionState(), nil
+}
+
+func (p *prober) try(cfg *tls.Config) (tls.ConnectionState, error) {
+	conn, state, err := p.handshake(cfg)
+	if err != nil {
+		return state, err
+	}
+	conn.Close()
+	return state, nil
+}
+
+// allSuites returns every cipher suite the Go client can offer for version v.
+func allSuites(v uint16) []uint16 {
+	var ids []uint16
+	for _, list := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, cs := range list {
+			for _, sv := range cs.SupportedVersions {
+				if sv == v && v != tls.VersionTLS13 {
+					ids = append(ids, cs.ID)
+					break
+				}
+			}
+		}
+	}
+	return ids
+}
+
+var scanVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+var scanCurves = []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+func (p *prober) versions() []uint16 {
+	var out []uint16
+	for _, v := range scanVersions {
+		cfg := &tls.Config{MinVersion: v, MaxVersion: v}
+		if v < tls.VersionTLS13 {
+			cfg.CipherSuites = allSuites(v)
+		}
+		if _, err := p.try(cfg); err == nil {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// suites12 finds the TLS 1.2 suites the server accepts, in the order the
+// server picks them when all remaining ones are offered.
+func (p *prober) suites12() []uint16 {
+	remaining := allSuites(tls.VersionTLS12)
+	var order []uint16
+	for len(remaining) > 0 {
+		state, err := p.try(&tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: remaining,
+		})
+		if err != nil {
+			break
+		}
+		order = append(order, state.CipherSuite)
+		next := remaining[:0:0]
+		for _, id := range remaining {
+			if id != state.CipherSuite {
+				next = append(next, id)
+			}
+		}
+		if len(next) == len(remaining) {
+			break
+		}
+		remaining = next
+	}
+	return order
+}
+
+func (p *prober) suite13() (uint16, bool) {
+	state, err := p.try(&tls.Config{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13})
+	if err != nil {
+		return 0, false
+	}
+	return state.CipherSuite, true
+}
+
+// groups offers one key exchange group at a time with the highest version.
+func (p *prober) groups() []tls.CurveID {
+	var out []tls.CurveID
+	for _, c := range scanCurves {
+		cfg := &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			CurvePreferences: []tls.CurveID{c},
+			CipherSuites:     ecdheSuites(),
+		}
+		if _, err := p.try(cfg); err == nil {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func ecdheSuites() []uint16 {
+	var ids []uint16
+	for _, id := range allSuites(tls.VersionTLS12) {
+		name := tls.CipherSuiteName(id)
+		if strings.HasPrefix(name, "TLS_ECDHE_") {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (p *prober) alpn(protos []string) (negotiated []string) {
+	for _, proto := range protos {
+		state, err := p.try(&tls.Config{MinVersion: tls.VersionTLS12, NextProtos: []string{proto}})
+		if err == nil && state.NegotiatedProtocol == proto {
+			negotiated = append(negotiated, proto)
+		}
+	}
+	return negotiated
+}
+
+// resumption connects twice with a shared session cache. TLS 1.3 tickets
+// arrive after the handshake, so the first connection reads briefly.
+func (p *prober) resumption() (bool, error) {
+	cache := tls.NewLRUClientSessionCache(4)
+	conn, _, err := p.handshake(&tls.Config{MinVersion: tls.VersionTLS12, ClientSessionCache: cache})
+	if err != nil {
+		return false, err
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	var b [1]byte
+	_, _ = conn.Read(b[:])
+	conn.Close()
+
+	state, err := p.try(&tls.Config{MinVersion: tls.VersionTLS12, ClientSessionCache: cache})
+	if err != nil {
+		return false, err
+	}
+	return state.DidResume, nil
+}
+
+func (p *prober) chain() (certInfo, error) {
+	state, err := p.try(&tls.Config{MinVersion: tls.VersionTLS12})
+	if err != nil {
+		return certInfo{}, err
+	}
+	info := certInfo{Stapled: len(state.OCSPResponse) > 0}
+	for _, c := range state.PeerCertificates {
+		info.Chain = append(info.Chain, describeCert(c))
+	}
+	if len(state.PeerCertificates) == 0 {
+		return info, errors.New("no peer certificates")
+	}
+	inter := x509.NewCertPool()
+	for _, c := range state.PeerCertificates[1:] {
+		inter.AddCert(c)
+	}
+	_, verr := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       p.serverName,
+		Roots:         p.roots,
+		Intermediates: inter,
+	})
+	info.Verified = verr == nil
+	if verr != nil {
+		info.VerifyError = verr.Error()
+	}
+	return info, nil
+}
+
+func describeCert(c *x509.Certificate) certDesc {
+	d := certDesc{
+		Subject:   c.Subject.String(),
+		Issuer:    c.Issuer.String(),
+		NotAfter:  c.NotAfter,
+		DNSNames:  c.DNSNames,
+		KeyType:   c.PublicKeyAlgorithm.String(),
+		Signature: c.SignatureAlgorithm.String(),
+	}
+	for _, ip := range c.IPAddresses {
+		d.IPs = append(d.IPs, ip.String())
+	}
+	return d
+}
@@ -1,17 +1,26 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"io"
-	"log"
+	"log/slog"
 	"net"
-	"net/http"
 	"time"
 
-	_ "net/http/pprof"
-	"tls-lab/internal/tlsutil"
+	"tls-lab/internal/accesslog"
+	"tls-lab/internal/admin"
+	"tls-lab/internal/checkcmd"
+	"tls-lab/internal/connreg"
+	"tls-lab/internal/health"
+	"tls-lab/internal/lifecycle"
+	"tls-lab/internal/limit"
+	"tls-lab/internal/logging"
 	bufpool "tls-lab/internal/pool"
+	"tls-lab/internal/proxyproto"
+	"tls-lab/internal/tlsutil"
 )
 
 func main() {
@@ -25,19 +34,57 @@ func main() {
 		clientKey    = flag.String("key", "", "Client key for upstream mTLS (optional, PEM)")
 		readTimeout  = flag.Duration("read-timeout", 60*time.Second, "Read deadline per direction")
 		writeTimeout = flag.Duration("write-timeout", 60*time.Second, "Write deadline per direction")
-		pprofAddr    = flag.String("pprof", "", "pprof listen address (e.g. 127.0.0.1:6060); empty to disable")
+		daneMode     = flag.String("dane", "off", "Authenticate upstream via TLSA records: off, dane (instead of CA) or dane+ca")
+		daneResolver = flag.String("dane-resolver", "127.0.0.1:53", "DNS server (host:port) used for TLSA lookups")
+		daneAD       = flag.Bool("dane-require-ad", false, "Reject TLSA answers without the DNSSEC AD bit")
+		dialTimeout  = flag.Duration("dial-timeout", 10*time.Second, "Timeout for connecting to the target")
+		hsTimeout    = flag.Duration("handshake-timeout", 10*time.Second, "Upstream TLS handshake deadline")
+		maxHS        = flag.Int("max-handshakes", 256, "Max concurrent upstream TLS handshakes (0 = unlimited)")
+		hsQueue      = flag.Duration("handshake-queue", time.Second, "How long a handshake may wait for a slot before being shed (0 = shed immediately)")
+		maxConns     = flag.Int("max-conns", 0, "Max concurrent tunnels (0 = unlimited)")
+		maxPerIP     = flag.Int("max-conns-per-ip", 0, "Max concurrent tunnels per source IP (0 = unlimited)")
+		connRate     = flag.Float64("conn-rate", 0, "New connections per second overall (0 = unlimited)")
+		connBurst    = flag.Int("conn-burst", 100, "Burst for -conn-rate")
+		connRateIP   = flag.Float64("conn-rate-per-ip", 0, "New connections per second per source IP (0 = unlimited)")
+		connBurstIP  = flag.Int("conn-burst-per-ip", 20, "Burst for -conn-rate-per-ip")
+		proxyFrom    = flag.String("proxy-protocol-from", "", "Comma-separated CIDRs allowed to send PROXY v1/v2 headers; empty to disable")
+		proxyTimeout = flag.Duration("proxy-header-timeout", 5*time.Second, "Deadline for reading a PROXY header")
+		proxyOut     = flag.String("proxy-protocol-out", "off", "Send a PROXY header to the target: off, v1 or v2 (v2 passes on the TLVs, e.g. TLS version, SNI and client CN, of a trusted inbound v2 header)")
+		adminAddr    = flag.String("admin-addr", "", "Admin API listen address (mTLS: dashboard, health, metrics, pprof, connections, log level); empty to disable")
+		adminCert    = flag.String("admin-cert", "certs/server.crt", "Admin API server certificate (PEM)")
+		adminKey     = flag.String("admin-key", "certs/server.key", "Admin API server key (PEM)")
+		adminCA      = flag.String("admin-ca", "certs/ca.crt", "CA that issues admin client certificates (PEM)")
+		adminClients = flag.String("admin-clients", "", "Comma-separated client certificate identities with full admin access; empty (with -admin-readers) allows any cert from -admin-ca")
+		adminReaders = flag.String("admin-readers", "", "Comma-separated client certificate identities allowed read-only admin access (GET, no pprof)")
+		adminSocket  = flag.String("admin-socket", "", "Also serve the admin API on this Unix socket (owner-only, no TLS); empty to disable")
+		drainTimeout = flag.Duration("drain-timeout", 15*time.Second, "On shutdown, wait this long for active tunnels before closing them")
+		accessLog    = flag.String("access-log", "", "Append one JSON line per tunnel (client, target, bytes, close reason) to this file; empty to disable")
+		accessSize   = flag.Int64("access-log-max-size", 100<<20, "Rotate the access log at this many bytes (0 = no size limit)")
+		accessAge    = flag.Duration("access-log-max-age", 24*time.Hour, "Rotate the access log after this long (0 = no age limit)")
+		logFormat    = flag.String("log-format", "text", "Log output: text or json")
+		logLevel     = flag.String("log-level", "info", "Log level: debug, info, warn or error (changeable at runtime via the admin API)")
 	)
 	flag.Parse()
 
-	if *pprofAddr != "" {
-		go func() {
-			log.Printf("pprof listening on http://%s/debug/pprof/", *pprofAddr)
-			_ = http.ListenAndServe(*pprofAddr, nil)
-		}()
+	logLevelVar, err := logging.Setup(*logFormat, *logLevel)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+
+	dane, err := tlsutil.ParseDANEMode(*daneMode)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	trustedProxies, err := proxyproto.ParseCIDRs(*proxyFrom)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+	proxyVersion, err := proxyproto.ParseVersion(*proxyOut)
+	if err != nil {
+		logging.Fatal(err.Error())
 	}
 
 	var tlsCfg *tls.Config
-	var err error
 	if *targetTLS {
 		opts := tlsutil.ClientTLSOptions{
 			CAFile:      *caFile,
@@ -46,57 +93,251 @@ func main() {
 			ServerName:  *serverName,
 			MinVersion:  tls.VersionTLS12,
 			EnableTLS13: true,
+
+			DANE:          dane,
+			DANEResolver:  *daneResolver,
+			DANETarget:    tlsutil.DANETarget(*targetAddr, *serverName),
+			DANERequireAD: *daneAD,
 		}
 		tlsCfg, err = tlsutil.NewClientTLSConfig(opts)
 		if err != nil {
-			log.Fatalf("failed to build client TLS config: %v", err)
+			logging.Fatal("failed to build client TLS config", "err", err)
+		}
+	}
+
+	t := &tunnel{
+		target:      *targetAddr,
+		targetTLS:   *targetTLS,
+		tlsCfg:      tlsCfg,
+		dialTimeout: *dialTimeout,
+		rt:          *readTimeout,
+		wt:          *writeTimeout,
+		upstreamGate: limit.NewHandshakeGate("tunnel_upstream", limit.HandshakeLimits{
+			Timeout:       *hsTimeout,
+			MaxConcurrent: *maxHS,
+			QueueTimeout:  *hsQueue,
+		}),
+		proxyOut: proxyVersion,
+		registry: connreg.New("tunnel-server"),
+	}
+
+	ready := health.New()
+	var probe []byte
+	if proxyVersion != proxyproto.Off {
+		// A LOCAL header tells the target the probe is not a client.
+		if probe, err = (&proxyproto.Header{Version: proxyVersion, Local: true}).Format(); err != nil {
+			logging.Fatal("PROXY header for readiness probe", "err", err)
 		}
 	}
+	ready.Add("upstream", health.Cached(health.Dial(*targetAddr, *dialTimeout, probe), 5*time.Second))
+	if tlsCfg != nil && *clientCert != "" {
+		ready.Add("upstream_cert", health.Cert(tlsCfg))
+		defer tlsutil.MonitorExpiry("upstream_client", tlsutil.LeafOf(tlsCfg), 30*24*time.Hour, time.Hour)()
+	}
 
-	ln, err := net.Listen("tcp", *listenAddr)
+	tcpLn, err := net.Listen("tcp", *listenAddr)
 	if err != nil {
-		log.Fatalf("listen error: %v", err)
+		logging.Fatal("listen error", "err", err)
+	}
+	ready.Set("listener", nil)
+	var ln net.Listener = limit.NewListener(tcpLn, "tunnel", limit.ConnLimits{
+		MaxConns:   *maxConns,
+		MaxPerIP:   *maxPerIP,
+		Rate:       *connRate,
+		Burst:      *connBurst,
+		PerIPRate:  *connRateIP,
+		PerIPBurst: *connBurstIP,
+	})
+	if len(trustedProxies) > 0 {
+		ln = proxyproto.NewListener(ln, trustedProxies, *proxyTimeout)
 	}
 	defer ln.Close()
-	log.Printf("Tunnel listening on %s -> %s (TLS to target=%v)", *listenAddr, *targetAddr, *targetTLS)
+	slog.Info("Tunnel listening", "addr", *listenAddr, "target", *targetAddr, "target_tls", *targetTLS)
+
+	ctx, stop := lifecycle.SignalContext()
+	defer stop()
+	t.ctx = ctx
+	ready.DrainOnDone(ctx)
+	if *accessLog != "" {
+		t.access, err = accesslog.Open(*accessLog, *accessSize, *accessAge)
+		if err != nil {
+			logging.Fatal(err.Error())
+		}
+		defer t.access.Close()
+	}
+
+	lifecycle.CloseOnDone(ctx, ln)
+	if *adminAddr != "" || *adminSocket != "" {
+		adm := admin.New()
+		adm.HandleReadiness(ready)
+		adm.HandleConnections(t.registry)
+		adm.HandleLogLevel(logLevelVar)
+		adm.HandleDashboard("tunnel-server", admin.Route{Listen: *listenAddr, Target: *targetAddr, TLS: *targetTLS, Check: "upstream"})
+		stopAdmin, err := adm.Start(admin.Options{
+			Addr:     *adminAddr,
+			CertFile: *adminCert,
+			KeyFile:  *adminKey,
+			CAFile:   *adminCA,
+			Clients:  checkcmd.SplitList(*adminClients),
+			Readers:  checkcmd.SplitList(*adminReaders),
+			Socket:   *adminSocket,
+		})
+		if err != nil {
+			logging.Fatal(err.Error())
+		}
+		defer stopAdmin()
+	}
 
+	tunnels := lifecycle.NewGroup()
 	for {
 		clientConn, err := ln.Accept()
 		if err != nil {
-			log.Printf("accept error: %v", err)
+			if lifecycle.IsClosed(err) {
+				break
+			}
+			slog.Error("accept error", "err", err)
 			continue
 		}
-		go handle(clientConn, *targetAddr, *targetTLS, tlsCfg, *readTimeout, *writeTimeout)
+		done := tunnels.Track(clientConn)
+		go func() {
+			defer done()
+			t.handle(clientConn)
+		}()
 	}
+	slog.Info("shutting down: draining tunnels", "active", tunnels.Active(), "timeout", *drainTimeout)
+	slog.Info("shutdown complete", "summary", tunnels.Drain(*drainTimeout).String())
+}
+
+type tunnel struct {
+	target       string
+	targetTLS    bool
+	tlsCfg       *tls.Config
+	dialTimeout  time.Duration
+	rt, wt       time.Duration
+	upstreamGate *limit.HandshakeGate
+	proxyOut     proxyproto.Version
+	registry     *connreg.Registry
+	access       *accesslog.Log
+	ctx          context.Context // cancelled on shutdown
 }
 
-func handle(clientConn net.Conn, target string, targetTLS bool, tlsCfg *tls.Config, rt, wt time.Duration) {
+func (t *tunnel) handle(clientConn net.Conn) {
 	defer clientConn.Close()
+	connID := t.registry.NextID()
+	lg := slog.With("conn", connID, "remote", clientConn.RemoteAddr().String())
+	acc := accesslog.NewEntry("tunnel-server", "tcp", connID, proxyproto.PeerAddr(clientConn), clientConn.LocalAddr())
+	acc.Target = t.target
+	if proxyproto.HeaderOf(clientConn) != nil {
+		acc.Client = clientConn.RemoteAddr().String()
+	}
+	var entry *connreg.Entry
+	reason := "client closed"
+	defer func() { t.finish(lg, acc, entry, reason) }()
 
-	backendConn, err := net.Dial("tcp", target)
+	backendConn, err := net.DialTimeout("tcp", t.target, t.dialTimeout)
 	if err != nil {
-		log.Printf("connect to target error: %v", err)
+		lg.Error("connect to target error", "target", t.target, "err", err)
+		reason = "connect to target failed: " + err.Error()
 		return
 	}
 	defer backendConn.Close()
 
+	if t.proxyOut != proxyproto.Off {
+		if err := t.writeProxyHeader(backendConn, clientConn); err != nil {
+			lg.Error("send PROXY header to target", "err", err)
+			reason = "send PROXY header failed: " + err.Error()
+			return
+		}
+	}
+
 	var upstream net.Conn = backendConn
-	if targetTLS {
-		tconn := tls.Client(backendConn, tlsCfg)
-		if err := tconn.Handshake(); err != nil {
-			log.Printf("upstream TLS handshake failed: %v", err)
+	// The client leg is plain TCP, so the registry shows the upstream TLS.
+	var upState *tls.ConnectionState
+	if t.targetTLS {
+		tconn := tls.Client(backendConn, t.tlsCfg)
+		if err := t.upstreamGate.Handshake(tconn, ""); err != nil {
+			r := limit.FailureReason(err)
+			lg.Error("upstream TLS handshake failed", "target", t.target, "reason", r, "hint", tlsutil.HandshakeReason(r).Hint(), "err", err)
+			reason = "upstream TLS handshake failed: " + err.Error()
 			return
 		}
 		upstream = tconn
+		cs := tconn.ConnectionState()
+		upState = &cs
+		lg = lg.With("upstream", logging.TLS(upState))
 	}
-	log.Printf("Tunnel connected %s -> %s", clientConn.RemoteAddr(), target)
+	lg.Info("tunnel connected", "target", t.target)
 
-	// Bi-directional copy with deadlines
-	errc := make(chan error, 2)
-	go proxyWithDeadline(upstream, clientConn, rt, wt, errc) // upstream -> client
-	go proxyWithDeadline(clientConn, upstream, rt, wt, errc) // client -> upstream
+	entry = t.registry.Register(connID, "tcp", t.target, clientConn.RemoteAddr(), clientConn.LocalAddr(), upState, clientConn)
+	defer entry.Unregister()
+	client := &connreg.Conn{Conn: clientConn, Entry: entry}
 
-	<-errc
+	// Bi-directional copy with deadlines; whichever side stops reading first
+	// ends the tunnel.
+	fromClient := make(chan error, 1)
+	fromTarget := make(chan error, 1)
+	go proxyWithDeadline(upstream, client, t.rt, t.wt, fromClient) // client -> upstream
+	go proxyWithDeadline(client, upstream, t.rt, t.wt, fromTarget) // upstream -> client
+
+	select {
+	case err := <-fromClient:
+		reason = t.closeReason(err, "client closed")
+	case err := <-fromTarget:
+		reason = t.closeReason(err, "target closed")
+	}
+}
+
+// closeReason describes the copy error that ended a tunnel; eof is the
+// reason when the side being read closed normally.
+func (t *tunnel) closeReason(err error, eof string) string {
+	switch {
+	case err == nil:
+		return eof
+	case errors.Is(err, net.ErrClosed) && t.ctx.Err() != nil:
+		return "server shutdown"
+	}
+	return accesslog.Reason(err)
+}
+
+// finish reports a finished tunnel at debug level and writes its access log
+// entry. entry is nil if the tunnel ended before registration.
+func (t *tunnel) finish(lg *slog.Logger, acc *accesslog.Entry, entry *connreg.Entry, reason string) {
+	if entry != nil {
+		info := entry.Info()
+		acc.BytesIn, acc.BytesOut = info.BytesIn, info.BytesOut
+		if entry.Killed() {
+			reason = "killed via admin API"
+		}
+	}
+	acc.CloseReason = reason
+	if err := t.access.Write(acc); err != nil {
+		lg.Error("access log write failed", "err", err)
+	}
+	lg.Debug("tunnel closed", "reason", reason, "bytes_in", acc.BytesIn, "bytes_out", acc.BytesOut, "duration", time.Since(acc.Connect).Round(time.Millisecond))
+}
+
+// writeProxyHeader tells the target who the client is. The client address
+// is the real one when the tunnel itself sits behind a trusted proxy.
+func (t *tunnel) writeProxyHeader(backend, client net.Conn) error {
+	h := &proxyproto.Header{Version: t.proxyOut}
+	src, ok1 := client.RemoteAddr().(*net.TCPAddr)
+	dst, ok2 := client.LocalAddr().(*net.TCPAddr)
+	if ok1 && ok2 {
+		h.Source, h.Dest = src, dst
+	} else {
+		h.Local = true
+	}
+	if in := proxyproto.HeaderOf(client); in != nil && t.proxyOut == proxyproto.V2 {
+		// Pass on the TLS details from a TLS-terminating proxy in front.
+		h.TLVs = in.TLVs
+	}
+	b, err := h.Format()
+	if err != nil {
+		return err
+	}
+	_ = backend.SetWriteDeadline(time.Now().Add(t.wt))
+	_, err = backend.Write(b)
+	return err
 }
 
 func proxyWithDeadline(dst net.Conn, src net.Conn, rt, wt time.Duration, errc chan<- error) {
@@ -129,5 +370,3 @@ func (d deadlineWriter) Write(p []byte) (int, error) {
 	_ = d.w.SetWriteDeadline(time.Now().Add(d.wt))
 	return d.w.Write(p)
 }
-
-
@@ -1,15 +1,18 @@
 module tls-lab
 
-go 1.24.0
+go 1.25.0
 
 require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/quic-go/quic-go v0.59.1
+	golang.org/x/net v0.43.0
 	google.golang.org/grpc v1.76.0
 	google.golang.org/protobuf v1.36.6
 )
 
 require (
-	golang.org/x/net v0.42.0 // indirect
-	golang.org/x/sys v0.34.0 // indirect
-	golang.org/x/text v0.27.0 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250804133106-a7a43d27e69b // indirect
 )
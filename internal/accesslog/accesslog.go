@@ -0,0 +1,202 @@
+// Package accesslog writes the per-connection access log: one JSON line per
+// connection with its TLS parameters, client identity, traffic and close
+// reason, appended to a file that rotates by size and age.
+package accesslog
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one connection. Connections that never complete the handshake
+// are logged too, with empty TLS fields and the failure as close reason.
+type Entry struct {
+	Server     string    `json:"server"`
+	Transport  string    `json:"transport"` // tcp, quic, grpc
+	Conn       uint64    `json:"conn"`
+	Connect    time.Time `json:"connect"`
+	Close      time.Time `json:"close"`
+	DurationMS int64     `json:"duration_ms"`
+	// Remote is the TCP (or UDP) peer; Client is the address from a PROXY
+	// header when the peer is a trusted proxy.
+	Remote string `json:"remote"`
+	Client string `json:"client,omitempty"`
+	Local  string `json:"local"`
+	Target string `json:"target,omitempty"`
+
+	SNI               string `json:"sni,omitempty"`
+	ALPN              string `json:"alpn,omitempty"`
+	TLSVersion        string `json:"tls_version,omitempty"`
+	Cipher            string `json:"cipher,omitempty"`
+	Resumed           bool   `json:"resumed"`
+	ClientSubject     string `json:"client_subject,omitempty"`
+	ClientSerial      string `json:"client_serial,omitempty"`
+	ClientFingerprint string `json:"client_sha256,omitempty"`
+	JA3               string `json:"ja3,omitempty"` // ClientHello fingerprints
+	JA4               string `json:"ja4,omitempty"`
+
+	BytesIn     int64  `json:"bytes_in"`
+	BytesOut    int64  `json:"bytes_out"`
+	CloseReason string `json:"close_reason"`
+}
+
+// NewEntry starts an entry for a connection accepted now.
+func NewEntry(server, transport string, conn uint64, remote, local net.Addr) *Entry {
+	return &Entry{
+		Server:    server,
+		Transport: transport,
+		Conn:      conn,
+		Connect:   time.Now(),
+		Remote:    remote.String(),
+		Local:     local.String(),
+	}
+}
+
+// SetTLS records the negotiated parameters and the client certificate.
+func (e *Entry) SetTLS(cs *tls.ConnectionState) {
+	e.SNI = cs.ServerName
+	e.ALPN = cs.NegotiatedProtocol
+	e.TLSVersion = tls.VersionName(cs.Version)
+	e.Cipher = tls.CipherSuiteName(cs.CipherSuite)
+	e.Resumed = cs.DidResume
+	if len(cs.PeerCertificates) > 0 {
+		leaf := cs.PeerCertificates[0]
+		sum := sha256.Sum256(leaf.Raw)
+		e.ClientSubject = leaf.Subject.String()
+		e.ClientSerial = leaf.SerialNumber.Text(16)
+		e.ClientFingerprint = hex.EncodeToString(sum[:])
+	}
+}
+
+// Reason describes how a connection's I/O ended: nil and io.EOF mean the
+// client closed it.
+func Reason(err error) string {
+	var ne net.Error
+	switch {
+	case err == nil || errors.Is(err, io.EOF):
+		return "client closed"
+	case errors.As(err, &ne) && ne.Timeout():
+		return "idle timeout"
+	case errors.Is(err, net.ErrClosed):
+		return "closed by server"
+	}
+	return err.Error()
+}
+
+// Log is an append-only JSON-lines file. It is renamed to
+// <path>.<timestamp> and reopened once it reaches maxSize bytes or maxAge
+// since this process opened it; zero disables either limit. A nil *Log
+// discards entries.
+type Log struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// Open opens (or creates) the log at path.
+func Open(path string, maxSize int64, maxAge time.Duration) (*Log, error) {
+	l := &Log{path: path, maxSize: maxSize, maxAge: maxAge}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("open access log: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("open access log: %w", err)
+	}
+	l.f, l.size, l.opened = f, st.Size(), time.Now()
+	return nil
+}
+
+// Write finishes e (close time and duration) and appends it.
+func (l *Log) Write(e *Entry) error {
+	if l == nil {
+		return nil
+	}
+	e.Close = time.Now()
+	e.DurationMS = e.Close.Sub(e.Connect).Milliseconds()
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var rotateErr error
+	if l.due(int64(len(b))) {
+		// On failure the entry still goes to the current file.
+		rotateErr = l.rotate()
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return errors.Join(rotateErr, err)
+}
+
+func (l *Log) due(next int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.maxSize > 0 && l.size+next > l.maxSize {
+		return true
+	}
+	return l.maxAge > 0 && time.Since(l.opened) >= l.maxAge
+}
+
+// rotate renames the file and opens a new one at path. The old file is
+// only closed once the new one is open; if either step fails, l keeps the
+// old file under its original name.
+func (l *Log) rotate() error {
+	base := l.path + "." + time.Now().Format("20060102-150405")
+	name := base
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		name = fmt.Sprintf("%s.%d", base, i)
+	}
+	if err := os.Rename(l.path, name); err != nil {
+		return fmt.Errorf("rotate access log: %w", err)
+	}
+	old := l.f
+	if err := l.open(); err != nil {
+		_ = os.Rename(name, l.path)
+		return fmt.Errorf("rotate access log: %w", err)
+	}
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("rotate access log: close %s: %w", name, err)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,201 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var (
+	testRemote = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+	testLocal  = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8443}
+)
+
+func writeEntry(t *testing.T, l *Log, conn uint64) error {
+	t.Helper()
+	e := NewEntry("test", "tcp", conn, testRemote, testLocal)
+	e.CloseReason = "client closed"
+	return l.Write(e)
+}
+
+// readConns returns the conn IDs logged in file.
+func readConns(t *testing.T, file string) []uint64 {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ids []uint64
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		ids = append(ids, e.Conn)
+	}
+	return ids
+}
+
+// rotated returns the rotated files of path, oldest first: timestamps sort
+// by name, and a .N suffix after its unsuffixed name.
+func rotated(t *testing.T, path string) []string {
+	t.Helper()
+	files, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRotateSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	// Every entry is larger than maxSize, so each one after the first
+	// starts a new file, usually within the same second: the second
+	// rotation gets a .1 suffix.
+	l, err := Open(path, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for id := range uint64(3) {
+		if err := writeEntry(t, l, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := rotated(t, path)
+	if len(files) != 2 {
+		t.Fatalf("rotated files = %v, want 2", files)
+	}
+	for i, file := range append(files, path) {
+		if got := readConns(t, file); !slices.Equal(got, []uint64{uint64(i)}) {
+			t.Errorf("%s holds conns %v, want [%d]", filepath.Base(file), got, i)
+		}
+	}
+}
+
+func TestRotateAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := Open(path, 0, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for id := range uint64(2) {
+		if err := writeEntry(t, l, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := rotated(t, path); len(files) != 0 {
+		t.Fatalf("rotated before maxAge: %v", files)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := writeEntry(t, l, 2); err != nil {
+		t.Fatal(err)
+	}
+	files := rotated(t, path)
+	if len(files) != 1 {
+		t.Fatalf("rotated files = %v, want 1", files)
+	}
+	if got := readConns(t, files[0]); !slices.Equal(got, []uint64{0, 1}) {
+		t.Errorf("rotated file holds conns %v, want [0 1]", got)
+	}
+	if got := readConns(t, path); !slices.Equal(got, []uint64{2}) {
+		t.Errorf("new file holds conns %v, want [2]", got)
+	}
+}
+
+func TestRotateCollision(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := Open(path, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := writeEntry(t, l, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Rotated files from an earlier run, for this second and the next.
+	var existing []string
+	for _, ts := range []time.Time{time.Now(), time.Now().Add(time.Second)} {
+		base := path + "." + ts.Format("20060102-150405")
+		for _, name := range []string{base, base + ".1"} {
+			if err := os.WriteFile(name, []byte("old\n"), 0o640); err != nil {
+				t.Fatal(err)
+			}
+			existing = append(existing, name)
+		}
+	}
+	if err := writeEntry(t, l, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range existing {
+		if b, err := os.ReadFile(name); err != nil || string(b) != "old\n" {
+			t.Errorf("%s was overwritten: %q, %v", filepath.Base(name), b, err)
+		}
+	}
+	files := rotated(t, path)
+	if len(files) != len(existing)+1 {
+		t.Fatalf("rotated files = %v", files)
+	}
+	var found bool
+	for _, file := range files {
+		if !slices.Contains(existing, file) {
+			found = true
+			if got := readConns(t, file); !slices.Equal(got, []uint64{0}) {
+				t.Errorf("%s holds conns %v, want [0]", filepath.Base(file), got)
+			}
+		}
+	}
+	if !found {
+		t.Error("no new rotated file")
+	}
+}
+
+func TestRotateFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	l, err := Open(path, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := writeEntry(t, l, 0); err != nil {
+		t.Fatal(err)
+	}
+	// With the file gone the rename fails; entries still go to the open
+	// file instead of a closed one.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	size := l.size
+	for id := range uint64(2) {
+		err := writeEntry(t, l, id+1)
+		if err == nil {
+			t.Fatal("rotation without the file succeeded")
+		}
+		if errors.Is(err, os.ErrClosed) {
+			t.Fatalf("write after a failed rotation: %v", err)
+		}
+	}
+	if l.size <= size {
+		t.Error("entries after the failed rotation were not written")
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+	if err := writeEntry(t, l, 0); err != nil {
+		t.Errorf("Write = %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+// Package admin is the admin HTTP server of the commands: liveness,
+// readiness, metrics and pprof, plus live connections from a
+// connreg.Registry and the log level where the command has them. It has its own mux and is served over
+// mTLS, and optionally on a Unix socket for local tools.
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"tls-lab/internal/connreg"
+	"tls-lab/internal/health"
+	"tls-lab/internal/metrics"
+	"tls-lab/internal/tlsutil"
+)
+
+// Options configures the admin listeners; at least one of Addr and Socket
+// is required. On Addr a verified client certificate from CAFile is always
+// required.
+type Options struct {
+	Addr     string
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	// Clients are the certificate identities (see tlsutil.PeerIdentity)
+	// with full access, Readers those limited to GET routes other than
+	// pprof. When both are empty any certificate from CAFile has full
+	// access.
+	Clients []string
+	Readers []string
+	// Socket is a Unix socket path served without TLS. It is created
+	// owner-only (0600) and its users have full access.
+	Socket string
+}
+
+// Server is the admin API.
+type Server struct {
+	mux   *http.ServeMux
+	start time.Time
+	done  chan struct{} // closed by stop, ends event streams
+
+	// Shown on the dashboard when set.
+	reg   *connreg.Registry
+	ready *health.Checker
+}
+
+// New returns a Server with the routes every command has:
+//
+//	GET /livez          liveness and uptime (also /healthz)
+//	GET /metrics        Prometheus/OpenMetrics metrics
+//	GET /debug/vars     expvar counters
+//	GET /debug/pprof/   runtime profiles (net/http/pprof)
+func New() *Server {
+	s := &Server{mux: http.NewServeMux(), start: time.Now(), done: make(chan struct{})}
+	live := func(w http.ResponseWriter, _ *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]string{
+			"status": "ok",
+			"uptime": time.Since(s.start).Round(time.Second).String(),
+		})
+	}
+	s.mux.HandleFunc("GET /livez", live)
+	s.mux.HandleFunc("GET /healthz", live)
+	s.mux.Handle("GET /metrics", metrics.Handler())
+	s.mux.Handle("GET /debug/vars", expvar.Handler())
+	s.mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	return s
+}
+
+// HandleConnections adds the routes for reg:
+//
+//	GET    /connections       list live connections
+//	GET    /connections/{id}  one connection
+//	DELETE /connections/{id}  close it
+func (s *Server) HandleConnections(reg *connreg.Registry) {
+	s.reg = reg
+	s.mux.HandleFunc("GET /connections", func(w http.ResponseWriter, _ *http.Request) {
+		WriteJSON(w, http.StatusOK, reg.List())
+	})
+	s.mux.HandleFunc("GET /connections/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid connection id", http.StatusBadRequest)
+			return
+		}
+		info, ok := reg.Get(id)
+		if !ok {
+			http.Error(w, "no such connection", http.StatusNotFound)
+			return
+		}
+		WriteJSON(w, http.StatusOK, info)
+	})
+	s.mux.HandleFunc("DELETE /connections/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid connection id", http.StatusBadRequest)
+			return
+		}
+		if !reg.Kill(id) {
+			http.Error(w, "no such connection", http.StatusNotFound)
+			return
+		}
+		slog.Info("admin: connection killed", "conn", id, "by", identity(r))
+		WriteJSON(w, http.StatusOK, map[string]uint64{"killed": id})
+	})
+}
+
+// HandleReadiness adds GET /readyz, which runs the checks of c and answers
+// 200 when the server is ready and 503 otherwise, with the report as body.
+func (s *Server) HandleReadiness(c *health.Checker) {
+	s.ready = c
+	s.mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		rep := c.Check(r.Context())
+		status := http.StatusOK
+		if !rep.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		WriteJSON(w, status, rep)
+	})
+}
+
+// Handle registers an additional admin route.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// HandleLogLevel adds routes reading and setting lv:
+//
+//	GET /log-level             current level
+//	PUT /log-level?level=debug set it (debug, info, warn or error)
+func (s *Server) HandleLogLevel(lv *slog.LevelVar) {
+	s.mux.HandleFunc("GET /log-level", func(w http.ResponseWriter, _ *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]string{"level": lv.Level().String()})
+	})
+	s.mux.HandleFunc("PUT /log-level", func(w http.ResponseWriter, r *http.Request) {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(r.URL.Query().Get("level"))); err != nil {
+			http.Error(w, "level must be debug, info, warn or error", http.StatusBadRequest)
+			return
+		}
+		old := lv.Level()
+		lv.Set(level)
+		slog.Info("admin: log level changed", "from", old, "to", level, "by", identity(r))
+		WriteJSON(w, http.StatusOK, map[string]string{"level": level.String()})
+	})
+}
+
+// WriteJSON writes v as an indented JSON response.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// Start serves the API on the listeners in opts until stop is called. It
+// outlives the shutdown signal so /readyz can report the drain.
+func (s *Server) Start(opts Options) (stop func(), err error) {
+	if opts.Addr == "" && opts.Socket == "" {
+		return nil, errors.New("admin: no listen address or socket")
+	}
+	srv := &http.Server{
+		Handler:           authorize(opts.Clients, opts.Readers, s.mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	var tcpLn, unixLn net.Listener
+	if opts.Addr != "" {
+		cfg, err := tlsutil.NewServerTLSConfig(tlsutil.ServerTLSOptions{
+			CertFile:          opts.CertFile,
+			KeyFile:           opts.KeyFile,
+			CAFile:            opts.CAFile,
+			RequireClientCert: true,
+			MinVersion:        tls.VersionTLS12,
+			EnableTLS13:       true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("admin TLS config: %w", err)
+		}
+		srv.TLSConfig = cfg
+		if tcpLn, err = net.Listen("tcp", opts.Addr); err != nil {
+			return nil, fmt.Errorf("admin listen: %w", err)
+		}
+	}
+	if opts.Socket != "" {
+		var err error
+		if unixLn, err = listenUnix(opts.Socket); err != nil {
+			if tcpLn != nil {
+				_ = tcpLn.Close()
+			}
+			return nil, err
+		}
+	}
+	if tcpLn != nil {
+		go func() {
+			slog.Info("admin API listening (mTLS)", "url", "https://"+opts.Addr+"/")
+			if err := srv.ServeTLS(tcpLn, "", ""); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("admin API error", "err", err)
+			}
+		}()
+	}
+	if unixLn != nil {
+		go func() {
+			slog.Info("admin API listening (unix socket)", "path", opts.Socket)
+			if err := srv.Serve(unixLn); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("admin API error", "err", err)
+			}
+		}()
+	}
+	return func() {
+		close(s.done)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}, nil
+}
+
+// listenUnix listens on path, replacing a stale socket from an earlier run.
+// The socket is bound in a new 0700 directory next to path and restricted
+// to the owner before it is moved into place, so no other local user can
+// connect in between.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".admin-")
+	if err != nil {
+		return nil, fmt.Errorf("admin socket: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, fmt.Errorf("admin listen: %w", err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("admin socket: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("admin socket: %w", err)
+	}
+	return socketListener{ln, path}, nil
+}
+
+// socketListener removes the socket at its final path on Close.
+type socketListener struct {
+	net.Listener
+	path string
+}
+
+func (l socketListener) Close() error {
+	err := l.Listener.Close()
+	_ = os.Remove(l.path)
+	return err
+}
+
+// identity names the caller of r for logs and authorization.
+func identity(r *http.Request) string {
+	if r.TLS == nil {
+		return "unix-socket"
+	}
+	return tlsutil.PeerIdentity(*r.TLS)
+}
+
+// readOnly reports whether r may be served to a reader.
+func readOnly(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		!strings.HasPrefix(r.URL.Path, "/debug/pprof/")
+}
+
+func authorize(clients, readers []string, next http.Handler) http.Handler {
+	full, read := set(clients), set(readers)
+	open := len(full) == 0 && len(read) == 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := identity(r)
+		switch {
+		case r.TLS == nil || open || full[id]:
+		case read[id] && readOnly(r):
+		case read[id]:
+			slog.Warn("admin: read-only client denied", "identity", id, "method", r.Method, "path", r.URL.Path)
+			http.Error(w, "client certificate is read-only", http.StatusForbidden)
+			return
+		default:
+			slog.Warn("admin: client not allowed", "identity", id, "remote", r.RemoteAddr)
+			http.Error(w, "client certificate not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func set(list []string) map[string]bool {
+	m := make(map[string]bool, len(list))
+	for _, s := range list {
+		m[s] = true
+	}
+	return m
+}
@@ -0,0 +1,111 @@
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	tests := []struct {
+		name             string
+		clients, readers []string
+		identity         string // "" for the Unix socket
+		method, path     string
+		want             int
+	}{
+		{"open mode", nil, nil, "anyone", "DELETE", "/connections/1", http.StatusOK},
+		{"open mode pprof", nil, nil, "anyone", "GET", "/debug/pprof/heap", http.StatusOK},
+		{"full identity", []string{"alice"}, []string{"bob"}, "alice", "PUT", "/log-level", http.StatusOK},
+		{"full identity pprof", []string{"alice"}, nil, "alice", "GET", "/debug/pprof/", http.StatusOK},
+		{"reader get", []string{"alice"}, []string{"bob"}, "bob", "GET", "/connections", http.StatusOK},
+		{"reader head", nil, []string{"bob"}, "bob", "HEAD", "/metrics", http.StatusOK},
+		{"reader delete", []string{"alice"}, []string{"bob"}, "bob", "DELETE", "/connections/1", http.StatusForbidden},
+		{"reader put", nil, []string{"bob"}, "bob", "PUT", "/log-level", http.StatusForbidden},
+		{"reader pprof", []string{"alice"}, []string{"bob"}, "bob", "GET", "/debug/pprof/profile", http.StatusForbidden},
+		{"unknown identity", []string{"alice"}, []string{"bob"}, "mallory", "GET", "/livez", http.StatusForbidden},
+		{"unknown with readers only", nil, []string{"bob"}, "mallory", "GET", "/livez", http.StatusForbidden},
+		{"unix socket", []string{"alice"}, []string{"bob"}, "", "DELETE", "/connections/1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.identity != "" {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: tt.identity}}}}
+			}
+			rec := httptest.NewRecorder()
+			authorize(tt.clients, tt.readers, ok).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("%s %s as %q = %d, want %d", tt.method, tt.path, tt.identity, rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "admin.sock")
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	get := func() (int, string) {
+		t.Helper()
+		resp, err := client.Get("http://admin/livez")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// A stale socket from an earlier run is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	for run := range 2 {
+		stop, err := New().Start(Options{Socket: path, Clients: []string{"alice"}})
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && fi.Mode().Perm() != 0o600 {
+			t.Errorf("socket mode = %v, want 0600", fi.Mode().Perm())
+		}
+		// Socket users have full access whatever the client lists say.
+		if code, body := get(); code != http.StatusOK {
+			t.Errorf("GET /livez = %d %s", code, body)
+		}
+		stop()
+		client.CloseIdleConnections()
+	}
+
+	// Only the socket was created next to it, and it is gone after stop.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("left behind: %s", e.Name())
+	}
+}
@@ -0,0 +1,273 @@
+package admin
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"tls-lab/internal/connreg"
+	"tls-lab/internal/health"
+	"tls-lab/internal/metrics"
+	"tls-lab/internal/tlsutil"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// dashboardInterval is how often the dashboard receives a snapshot.
+const dashboardInterval = 2 * time.Second
+
+// maxDashboardConns caps the connections sent per snapshot; the total is
+// always sent.
+const maxDashboardConns = 200
+
+// Route is a tunnel route shown on the dashboard. Check names the readiness
+// check that probes its upstream (see HandleReadiness).
+type Route struct {
+	Listen string
+	Target string
+	TLS    bool
+	Check  string
+}
+
+// HandleDashboard adds a live HTML dashboard for the server named server:
+//
+//	GET /dashboard         the page (self-contained, no external assets)
+//	GET /dashboard/events  snapshots as Server-Sent Events
+//
+// It shows connections and readiness when HandleConnections and
+// HandleReadiness were called, and routes for a tunnel.
+func (s *Server) HandleDashboard(server string, routes ...Route) {
+	s.mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/dashboard", http.StatusFound)
+	})
+	s.mux.HandleFunc("GET /dashboard", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+		_, _ = w.Write(dashboardHTML)
+	})
+	s.mux.HandleFunc("GET /dashboard/events", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		d := &dashboard{server: server, routes: routes, s: s, prev: map[string]float64{}}
+		t := time.NewTicker(dashboardInterval)
+		defer t.Stop()
+		for {
+			b, err := json.Marshal(d.snapshot(r.Context()))
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+			flusher.Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-s.done:
+				return
+			case <-t.C:
+			}
+		}
+	})
+}
+
+// dashboard builds the snapshots of one event stream. prev holds the
+// counters of the previous snapshot, keyed by name, to turn them into rates.
+type dashboard struct {
+	server string
+	routes []Route
+	s      *Server
+	prev   map[string]float64
+	last   time.Time
+}
+
+type snapshot struct {
+	Server      string             `json:"server"`
+	Uptime      float64            `json:"uptime_seconds"`
+	Active      map[string]float64 `json:"active"` // by transport
+	Total       int                `json:"connections_total"`
+	Connections []connreg.Info     `json:"connections"`
+	Handshakes  []gateStats        `json:"handshakes"`
+	Versions    map[string]float64 `json:"versions"`
+	Ciphers     map[string]float64 `json:"ciphers"`
+	Certs       []certStats        `json:"certs"`
+	Routes      []routeStats       `json:"routes"`
+	Readiness   *health.Report     `json:"readiness,omitempty"`
+	RPCs        []rpcStats         `json:"rpcs"`
+}
+
+type gateStats struct {
+	Gate     string             `json:"gate"`
+	OK       float64            `json:"ok"`
+	Failed   float64            `json:"failed"`
+	OKRate   float64            `json:"ok_rate"`
+	FailRate float64            `json:"fail_rate"`
+	P50      float64            `json:"p50_ms"`
+	P99      float64            `json:"p99_ms"`
+	Reasons  map[string]float64 `json:"reasons"`
+}
+
+type certStats struct {
+	Label    string    `json:"label"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+type routeStats struct {
+	Listen  string `json:"listen"`
+	Target  string `json:"target"`
+	TLS     bool   `json:"tls"`
+	Active  int    `json:"active"`
+	Healthy *bool  `json:"healthy,omitempty"` // nil when not probed
+	Error   string `json:"error,omitempty"`
+}
+
+type rpcStats struct {
+	Method string  `json:"method"`
+	Count  uint64  `json:"count"`
+	Rate   float64 `json:"rate"`
+	Errors float64 `json:"errors"`
+	Avg    float64 `json:"avg_ms"`
+	P50    float64 `json:"p50_ms"`
+	P90    float64 `json:"p90_ms"`
+	P99    float64 `json:"p99_ms"`
+}
+
+func (d *dashboard) snapshot(ctx context.Context) *snapshot {
+	now := time.Now()
+	elapsed := now.Sub(d.last).Seconds()
+	if d.last.IsZero() {
+		elapsed = 0
+	}
+	d.last = now
+	// rate turns the counter v into a per-second rate since the last
+	// snapshot; the first snapshot has no rates.
+	rate := func(key string, v float64) float64 {
+		old, seen := d.prev[key]
+		d.prev[key] = v
+		if !seen || elapsed == 0 {
+			return 0
+		}
+		return (v - old) / elapsed
+	}
+
+	snap := &snapshot{
+		Server:   d.server,
+		Uptime:   time.Since(d.s.start).Seconds(),
+		Active:   map[string]float64{},
+		Versions: map[string]float64{},
+		Ciphers:  map[string]float64{},
+	}
+	if f := metrics.Gather("tls_lab_connections_active"); f != nil {
+		for _, s := range f.Series {
+			snap.Active[f.Label(s, "transport")] += s.Value
+		}
+	}
+	var conns []connreg.Info
+	if d.s.reg != nil {
+		conns = d.s.reg.List()
+	}
+	snap.Total = len(conns)
+	snap.Connections = conns[max(0, len(conns)-maxDashboardConns):]
+
+	gates := map[string]*gateStats{}
+	gate := func(name string) *gateStats {
+		g, ok := gates[name]
+		if !ok {
+			g = &gateStats{Gate: name, Reasons: map[string]float64{}}
+			gates[name] = g
+		}
+		return g
+	}
+	if f := metrics.Gather("tls_lab_handshake_duration_seconds"); f != nil {
+		for _, s := range f.Series {
+			g := gate(f.Label(s, "gate"))
+			g.OK = float64(s.Count)
+			g.P50 = f.Quantile(s, .5) * 1000
+			g.P99 = f.Quantile(s, .99) * 1000
+		}
+	}
+	if f := metrics.Gather("tls_lab_handshake_failures_total"); f != nil {
+		for _, s := range f.Series {
+			g := gate(f.Label(s, "gate"))
+			g.Failed += s.Value
+			g.Reasons[f.Label(s, "reason")] += s.Value
+		}
+	}
+	for _, g := range gates {
+		g.OKRate = rate("hs_ok/"+g.Gate, g.OK)
+		g.FailRate = rate("hs_fail/"+g.Gate, g.Failed)
+		snap.Handshakes = append(snap.Handshakes, *g)
+	}
+	sort.Slice(snap.Handshakes, func(i, j int) bool { return snap.Handshakes[i].Gate < snap.Handshakes[j].Gate })
+
+	if f := metrics.Gather("tls_lab_tls_sessions_total"); f != nil {
+		for _, s := range f.Series {
+			snap.Versions[f.Label(s, "version")] += s.Value
+			snap.Ciphers[f.Label(s, "cipher")] += s.Value
+		}
+	}
+	for _, c := range tlsutil.MonitoredCerts() {
+		snap.Certs = append(snap.Certs, certStats(c))
+	}
+
+	if d.s.ready != nil {
+		rep := d.s.ready.Check(ctx)
+		snap.Readiness = &rep
+	}
+	for _, rt := range d.routes {
+		rs := routeStats{Listen: rt.Listen, Target: rt.Target, TLS: rt.TLS}
+		for _, c := range conns {
+			if c.Target == rt.Target {
+				rs.Active++
+			}
+		}
+		if snap.Readiness != nil {
+			for _, res := range snap.Readiness.Checks {
+				if res.Name == rt.Check {
+					ok := res.OK
+					rs.Healthy, rs.Error = &ok, res.Error
+				}
+			}
+		}
+		snap.Routes = append(snap.Routes, rs)
+	}
+
+	errs := map[string]float64{}
+	if f := metrics.Gather("tls_lab_grpc_rpcs_total"); f != nil {
+		for _, s := range f.Series {
+			if f.Label(s, "code") != "OK" {
+				errs[f.Label(s, "method")] += s.Value
+			}
+		}
+	}
+	if f := metrics.Gather("tls_lab_grpc_rpc_duration_seconds"); f != nil {
+		for _, s := range f.Series {
+			m := f.Label(s, "method")
+			r := rpcStats{
+				Method: m,
+				Count:  s.Count,
+				Rate:   rate("rpc/"+m, float64(s.Count)),
+				Errors: errs[m],
+				P50:    f.Quantile(s, .5) * 1000,
+				P90:    f.Quantile(s, .9) * 1000,
+				P99:    f.Quantile(s, .99) * 1000,
+			}
+			if s.Count > 0 {
+				r.Avg = s.Value / float64(s.Count) * 1000
+			}
+			snap.RPCs = append(snap.RPCs, r)
+		}
+	}
+	return snap
+}
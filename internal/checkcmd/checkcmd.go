@@ -0,0 +1,90 @@
+// Package checkcmd implements the "check" subcommand shared by the servers:
+// it runs tlsutil.CheckCertificate on the configured key material and exits.
+package checkcmd
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"tls-lab/internal/tlsutil"
+)
+
+// Run parses args (everything after "check") and returns the process exit code:
+// 0 when no problems were found, 1 on warnings only, 2 on errors.
+func Run(prog string, args []string) int {
+	fs := flag.NewFlagSet(prog+" check", flag.ExitOnError)
+	var (
+		certFile   = fs.String("cert", "certs/server.crt", "Certificate (PEM)")
+		keyFile    = fs.String("key", "certs/server.key", "Private key (PEM)")
+		caFile     = fs.String("ca", "certs/ca.crt", "CA expected to issue the certificate (PEM); empty for system roots")
+		inter      = fs.String("intermediates", "", "Intermediate CA bundle (PEM), if not appended to -cert")
+		fetchAIA   = fs.Bool("fetch-aia", false, "Fetch missing issuers from the certificate AIA URLs")
+		names      = fs.String("names", "localhost", "Comma-separated names the certificate must cover")
+		usage      = fs.String("usage", "server", "Intended usage: server or client")
+		expiryWarn = fs.Duration("expiry-warn", 30*24*time.Hour, "Warn when less validity than this is left")
+		asJSON     = fs.Bool("json", false, "Print the report as JSON")
+	)
+	_ = fs.Parse(args)
+
+	eku := x509.ExtKeyUsageServerAuth
+	switch *usage {
+	case "server":
+	case "client":
+		eku = x509.ExtKeyUsageClientAuth
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -usage %q\n", *usage)
+		return 2
+	}
+
+	r, err := tlsutil.CheckCertificate(tlsutil.CertCheckOptions{
+		CertFile:          *certFile,
+		KeyFile:           *keyFile,
+		CAFile:            *caFile,
+		IntermediatesFile: *inter,
+		FetchAIA:          *fetchAIA,
+		Names:             SplitList(*names),
+		Usage:             eku,
+		ExpiryWarning:     *expiryWarn,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check: %v\n", err)
+		return 2
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(r)
+	} else {
+		fmt.Printf("%s\n  subject:  %s\n  issuer:   %s\n  validity: %s .. %s\n",
+			r.CertFile, r.Subject, r.Issuer,
+			r.NotBefore.Format(time.RFC3339), r.NotAfter.Format(time.RFC3339))
+		for _, f := range r.Findings {
+			fmt.Printf("  %-5s %-8s %s\n", f.Severity, f.Check, f.Message)
+		}
+	}
+
+	switch {
+	case r.HasErrors():
+		return 2
+	case len(r.Problems()) > 0:
+		return 1
+	}
+	return 0
+}
+
+// SplitList splits a comma-separated flag value, dropping empty entries.
+func SplitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,212 @@
+// Package connreg is an in-process registry of live connections, listed and
+// killed through the admin API.
+package connreg
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tls-lab/internal/metrics"
+	"tls-lab/internal/tlsutil"
+)
+
+// Registry holds the live connections of one server. It also feeds the
+// connection, traffic and TLS session metrics.
+type Registry struct {
+	server string
+
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*Entry
+}
+
+// New returns an empty Registry; server labels its metrics.
+func New(server string) *Registry {
+	return &Registry{server: server, conns: map[uint64]*Entry{}}
+}
+
+// Entry is one registered connection. Counters are updated by the
+// connection's I/O; the other fields are fixed at registration.
+type Entry struct {
+	ID        uint64
+	Transport string // tcp, quic
+	Remote    string
+	Local     string
+	Target    string // upstream, for tunnels
+	Version   string // TLS fields are empty for plaintext connections; for tunnels they describe the upstream leg
+	Cipher    string
+	SNI       string
+	Identity  string
+	Start     time.Time
+
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	lastSeen atomic.Int64 // unix nanos
+	killed   atomic.Bool
+	metrics  *metrics.Conn
+	closer   io.Closer
+	reg      *Registry
+}
+
+// Info is the JSON form of an Entry.
+type Info struct {
+	ID           uint64    `json:"id"`
+	Transport    string    `json:"transport"`
+	Remote       string    `json:"remote"`
+	Local        string    `json:"local"`
+	Target       string    `json:"target,omitempty"`
+	TLSVersion   string    `json:"tls_version"`
+	Cipher       string    `json:"cipher"`
+	SNI          string    `json:"sni"`
+	Identity     string    `json:"identity"`
+	BytesIn      int64     `json:"bytes_in"`
+	BytesOut     int64     `json:"bytes_out"`
+	Start        time.Time `json:"start"`
+	LastActivity time.Time `json:"last_activity"`
+}
+
+// NextID reserves a connection ID, so a connection can be logged under the
+// same ID before it is registered.
+func (r *Registry) NextID() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	return r.nextID
+}
+
+// Register adds a connection under an ID from NextID. cs is nil for plaintext connections and must
+// otherwise describe a completed handshake; target names the upstream of a
+// tunnel. closer is what Kill closes.
+func (r *Registry) Register(id uint64, transport, target string, remote, local net.Addr, cs *tls.ConnectionState, closer io.Closer) *Entry {
+	now := time.Now()
+	e := &Entry{
+		ID:        id,
+		Transport: transport,
+		Remote:    remote.String(),
+		Local:     local.String(),
+		Target:    target,
+		Start:     now,
+		metrics:   metrics.ConnOpened(r.server, transport, cs),
+		closer:    closer,
+		reg:       r,
+	}
+	if cs != nil {
+		e.Version = tls.VersionName(cs.Version)
+		e.Cipher = tls.CipherSuiteName(cs.CipherSuite)
+		e.SNI = cs.ServerName
+		e.Identity = tlsutil.PeerIdentity(*cs)
+	}
+	e.lastSeen.Store(now.UnixNano())
+	r.mu.Lock()
+	r.conns[e.ID] = e
+	r.mu.Unlock()
+	return e
+}
+
+// Unregister removes e; call it when the connection's handler returns.
+func (e *Entry) Unregister() {
+	e.reg.mu.Lock()
+	delete(e.reg.conns, e.ID)
+	e.reg.mu.Unlock()
+	e.metrics.Closed()
+}
+
+// AddIn counts n bytes received from the peer.
+func (e *Entry) AddIn(n int) {
+	if n > 0 {
+		e.bytesIn.Add(int64(n))
+		e.metrics.AddIn(n)
+		e.lastSeen.Store(time.Now().UnixNano())
+	}
+}
+
+// AddOut counts n bytes sent to the peer.
+func (e *Entry) AddOut(n int) {
+	if n > 0 {
+		e.bytesOut.Add(int64(n))
+		e.metrics.AddOut(n)
+		e.lastSeen.Store(time.Now().UnixNano())
+	}
+}
+
+// Killed reports whether the connection was closed through Kill.
+func (e *Entry) Killed() bool { return e.killed.Load() }
+
+// Info snapshots e.
+func (e *Entry) Info() Info {
+	return Info{
+		ID:           e.ID,
+		Transport:    e.Transport,
+		Remote:       e.Remote,
+		Local:        e.Local,
+		Target:       e.Target,
+		TLSVersion:   e.Version,
+		Cipher:       e.Cipher,
+		SNI:          e.SNI,
+		Identity:     e.Identity,
+		BytesIn:      e.bytesIn.Load(),
+		BytesOut:     e.bytesOut.Load(),
+		Start:        e.Start,
+		LastActivity: time.Unix(0, e.lastSeen.Load()),
+	}
+}
+
+// List returns all live connections ordered by ID.
+func (r *Registry) List() []Info {
+	r.mu.Lock()
+	out := make([]Info, 0, len(r.conns))
+	for _, e := range r.conns {
+		out = append(out, e.Info())
+	}
+	r.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Get returns the connection with the given ID.
+func (r *Registry) Get(id uint64) (Info, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.conns[id]
+	if !ok {
+		return Info{}, false
+	}
+	return e.Info(), true
+}
+
+// Kill closes the connection with the given ID. It stays listed until its
+// handler notices and unregisters it.
+func (r *Registry) Kill(id uint64) bool {
+	r.mu.Lock()
+	e, ok := r.conns[id]
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+	e.killed.Store(true)
+	_ = e.closer.Close()
+	return true
+}
+
+// Conn counts the bytes of a net.Conn into an Entry.
+type Conn struct {
+	net.Conn
+	Entry *Entry
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.Entry.AddIn(n)
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.Entry.AddOut(n)
+	return n, err
+}
@@ -0,0 +1,133 @@
+// Package echoproto is the line-command protocol spoken by echo-server in
+// -commands mode and used by echo-client's health-check helpers.
+//
+// Each request is one frame holding a command word. Replies are one frame;
+// structured replies (INFO, WHOAMI) are space-separated key=value fields.
+// Lines that are not commands are echoed back unchanged.
+package echoproto
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Commands understood by the server.
+const (
+	Ping   = "PING"
+	Time   = "TIME"
+	Info   = "INFO"
+	WhoAmI = "WHOAMI"
+	Quit   = "QUIT"
+)
+
+// Fixed replies.
+const (
+	Pong = "PONG"
+	Bye  = "BYE"
+)
+
+// Command returns the upper-cased command in line, or "" when line is not
+// a command and should be echoed.
+func Command(line string) string {
+	switch c := strings.ToUpper(strings.TrimSpace(line)); c {
+	case Ping, Time, Info, WhoAmI, Quit:
+		return c
+	}
+	return ""
+}
+
+// Field is one key=value pair of a structured reply.
+type Field struct {
+	Key, Value string
+}
+
+// Fields is an ordered structured reply.
+type Fields []Field
+
+// Add appends a field.
+func (f *Fields) Add(key, value string) {
+	*f = append(*f, Field{key, value})
+}
+
+// Get returns the value for key.
+func (f Fields) Get(key string) (string, bool) {
+	for _, kv := range f {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return "", false
+}
+
+// String formats the reply. Empty values are sent as "-" and spaces in
+// values are replaced by '_' so the line stays parseable.
+func (f Fields) String() string {
+	parts := make([]string, len(f))
+	for i, kv := range f {
+		v := kv.Value
+		if v == "" {
+			v = "-"
+		}
+		parts[i] = kv.Key + "=" + strings.ReplaceAll(v, " ", "_")
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseFields parses a structured reply.
+func ParseFields(line string) (Fields, error) {
+	var f Fields
+	for _, part := range strings.Fields(line) {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("malformed field %q in reply %q", part, line)
+		}
+		if v == "-" {
+			v = ""
+		}
+		f.Add(k, v)
+	}
+	return f, nil
+}
+
+// Expectation is a key=value assertion on a structured reply.
+type Expectation struct {
+	Key, Value string
+}
+
+// ParseExpectations parses "key=value,key=value".
+func ParseExpectations(s string) ([]Expectation, error) {
+	var out []Expectation
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid expectation %q (want key=value)", part)
+		}
+		out = append(out, Expectation{k, v})
+	}
+	return out, nil
+}
+
+// Check returns one message per expectation that f does not meet. Values
+// compare case-insensitively and "-" matches an empty field.
+func Check(f Fields, exp []Expectation) []string {
+	var failed []string
+	for _, e := range exp {
+		got, ok := f.Get(e.Key)
+		if !ok {
+			failed = append(failed, fmt.Sprintf("%s: missing from reply", e.Key))
+			continue
+		}
+		want := e.Value
+		if want == "-" {
+			want = ""
+		}
+		if !strings.EqualFold(got, strings.ReplaceAll(want, " ", "_")) {
+			failed = append(failed, fmt.Sprintf("%s: got %q, want %q", e.Key, got, e.Value))
+		}
+	}
+	return failed
+}
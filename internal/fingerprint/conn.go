@@ -0,0 +1,107 @@
+package fingerprint
+
+import (
+	"net"
+	"sync"
+)
+
+// maxRecord bounds how much of a connection is kept for parsing: a hello
+// split over a few full-size records, which real clients never need.
+const maxRecord = 64 << 10
+
+// Conn records what is read from the connection until its ClientHello has
+// been fingerprinted. Wrap the connection before handing it to tls.Server
+// and install a Policy in the tls.Config.
+type Conn struct {
+	net.Conn
+
+	mu   sync.Mutex
+	buf  []byte
+	done bool // stopped recording
+	fp   *Fingerprint
+}
+
+// Wrap starts recording c; the next byte read must be the first byte of
+// the ClientHello.
+func Wrap(c net.Conn) *Conn {
+	return &Conn{Conn: c}
+}
+
+// Read reads from the connection, recording the data until the hello has
+// been parsed.
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		if !c.done {
+			c.buf = append(c.buf, p[:n]...)
+			if len(c.buf) >= maxRecord {
+				c.done, c.buf = true, nil
+			}
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// NetConn returns the wrapped connection.
+func (c *Conn) NetConn() net.Conn { return c.Conn }
+
+// Fingerprint parses the recorded ClientHello, and stops recording once
+// that succeeds. It returns nil until a complete hello has been read, and
+// for peers that do not send one.
+func (c *Conn) Fingerprint() *Fingerprint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fp == nil && !c.done {
+		if fp, err := Parse(c.buf); err == nil {
+			c.fp = fp
+			c.done, c.buf = true, nil
+		}
+	}
+	return c.fp
+}
+
+// Listener wraps every accepted connection with Wrap.
+type Listener struct {
+	net.Listener
+}
+
+// NewListener wraps inner.
+func NewListener(inner net.Listener) *Listener {
+	return &Listener{Listener: inner}
+}
+
+// Accept wraps the next connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(c), nil
+}
+
+// Of returns the fingerprint of c, looking under wrappers with a NetConn
+// method such as *tls.Conn. It is nil before the handshake has read the
+// hello, and for connections that were not wrapped.
+func Of(c net.Conn) *Fingerprint {
+	if fc := find(c); fc != nil {
+		return fc.Fingerprint()
+	}
+	return nil
+}
+
+// find returns the *Conn at or under c, or nil.
+func find(c net.Conn) *Conn {
+	for c != nil {
+		if fc, ok := c.(*Conn); ok {
+			return fc
+		}
+		u, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		c = u.NetConn()
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+// Package fingerprint computes JA3 and JA4 fingerprints of TLS ClientHellos
+// and filters listeners by them. crypto/tls does not expose the raw hello,
+// so connections are wrapped (Wrap, NewListener) to record their first
+// bytes, and a Policy installed in the tls.Config parses them once the hello
+// has been read, before the server answers it.
+package fingerprint
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Fingerprint identifies the TLS stack that sent a ClientHello.
+type Fingerprint struct {
+	JA3       string // MD5 of JA3String, the usual form in lists
+	JA3String string
+	JA4       string
+}
+
+// LogValue logs f as a group of ja3 and ja4; a nil f logs nothing.
+func (f *Fingerprint) LogValue() slog.Value {
+	if f == nil {
+		return slog.GroupValue()
+	}
+	return slog.GroupValue(slog.String("ja3", f.JA3), slog.String("ja4", f.JA4))
+}
+
+// ErrNotClientHello is returned by Parse for data that does not start with
+// a complete TLS ClientHello.
+var ErrNotClientHello = errors.New("fingerprint: not a TLS ClientHello")
+
+// TLS extension types that JA3/JA4 look into.
+const (
+	extServerName        = 0x0000
+	extSupportedGroups   = 0x000a
+	extPointFormats      = 0x000b
+	extSignatureAlgs     = 0x000d
+	extALPN              = 0x0010
+	extSupportedVersions = 0x002b
+)
+
+// hello holds the ClientHello fields used by JA3 and JA4, in wire order
+// and with GREASE values removed.
+type hello struct {
+	version    uint16 // legacy_version
+	ciphers    []uint16
+	extensions []uint16
+	groups     []uint16
+	points     []byte
+	sigAlgs    []uint16
+	versions   []uint16 // supported_versions
+	alpn       string   // first protocol
+	sni        bool
+}
+
+// Parse computes the fingerprint of the ClientHello at the start of data,
+// which holds raw TLS records as read from the connection.
+func Parse(data []byte) (*Fingerprint, error) {
+	msg, err := handshakeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	h, err := parseHello(msg)
+	if err != nil {
+		return nil, err
+	}
+	s := h.ja3String()
+	sum := md5.Sum([]byte(s))
+	return &Fingerprint{JA3: hex.EncodeToString(sum[:]), JA3String: s, JA4: h.ja4()}, nil
+}
+
+// handshakeMessage reassembles the first handshake message from the
+// handshake records at the start of data.
+func handshakeMessage(data []byte) ([]byte, error) {
+	var msg []byte
+	for {
+		if len(msg) >= 4 {
+			n := 4 + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]))
+			if len(msg) >= n {
+				return msg[:n], nil
+			}
+		}
+		if len(data) < 5 || data[0] != 22 {
+			return nil, ErrNotClientHello
+		}
+		n := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+n {
+			return nil, ErrNotClientHello
+		}
+		msg = append(msg, data[5:5+n]...)
+		data = data[5+n:]
+	}
+}
+
+// reader consumes a byte slice; any short read sets ok to false.
+type reader struct {
+	b  []byte
+	ok bool
+}
+
+func (r *reader) next(n int) []byte {
+	if !r.ok || len(r.b) < n {
+		r.ok = false
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) u8() int {
+	if v := r.next(1); v != nil {
+		return int(v[0])
+	}
+	return 0
+}
+
+func (r *reader) u16() int {
+	if v := r.next(2); v != nil {
+		return int(binary.BigEndian.Uint16(v))
+	}
+	return 0
+}
+
+func (r *reader) u24() int {
+	if v := r.next(3); v != nil {
+		return int(v[0])<<16 | int(v[1])<<8 | int(v[2])
+	}
+	return 0
+}
+
+// vec8 and vec16 read a vector with a 1- or 2-byte length prefix.
+func (r *reader) vec8() *reader  { return &reader{b: r.next(r.u8()), ok: r.ok} }
+func (r *reader) vec16() *reader { return &reader{b: r.next(r.u16()), ok: r.ok} }
+
+func (r *reader) u16s() []uint16 {
+	var out []uint16
+	for r.ok && len(r.b) > 0 {
+		if v := uint16(r.u16()); r.ok && !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// isGREASE reports whether v is a GREASE value (RFC 8701): 0x0a0a,
+// 0x1a1a, ... 0xfafa.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func parseHello(msg []byte) (*hello, error) {
+	r := &reader{b: msg, ok: true}
+	if r.u8() != 1 { // client_hello
+		return nil, ErrNotClientHello
+	}
+	r = &reader{b: r.next(r.u24()), ok: r.ok}
+	h := &hello{version: uint16(r.u16())}
+	r.next(32) // random
+	r.vec8()   // legacy_session_id
+	h.ciphers = r.vec16().u16s()
+	r.vec8() // legacy_compression_methods
+	if !r.ok {
+		return nil, ErrNotClientHello
+	}
+	if len(r.b) == 0 {
+		return h, nil // no extensions (SSL 3.0 style)
+	}
+	exts := r.vec16()
+	for exts.ok && len(exts.b) > 0 {
+		typ := uint16(exts.u16())
+		data := exts.vec16()
+		if !exts.ok {
+			break
+		}
+		if isGREASE(typ) {
+			continue
+		}
+		h.extensions = append(h.extensions, typ)
+		switch typ {
+		case extServerName:
+			h.sni = true
+		case extSupportedGroups:
+			h.groups = data.vec16().u16s()
+		case extPointFormats:
+			h.points = data.vec8().b
+		case extSignatureAlgs:
+			h.sigAlgs = data.vec16().u16s()
+		case extALPN:
+			list := data.vec16()
+			h.alpn = string(list.vec8().b)
+		case extSupportedVersions:
+			h.versions = data.vec8().u16s()
+		}
+	}
+	if !exts.ok {
+		return nil, fmt.Errorf("%w: truncated extensions", ErrNotClientHello)
+	}
+	return h, nil
+}
+
+// ja3String is SSLVersion,Ciphers,Extensions,EllipticCurves,
+// EllipticCurvePointFormats with decimal values joined by "-".
+func (h *hello) ja3String() string {
+	points := make([]uint16, len(h.points))
+	for i, p := range h.points {
+		points[i] = uint16(p)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		joinDec(h.ciphers),
+		joinDec(h.extensions),
+		joinDec(h.groups),
+		joinDec(points),
+	}, ",")
+}
+
+func joinDec(vs []uint16) string {
+	s := make([]string, len(vs))
+	for i, v := range vs {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, "-")
+}
+
+// ja4 is the JA4 fingerprint for TLS over TCP: a_b_c, where a describes the
+// hello (protocol, version, SNI, counts, ALPN), b hashes the sorted cipher
+// suites and c the sorted extensions (without SNI and ALPN) followed by the
+// signature algorithms in wire order.
+func (h *hello) ja4() string {
+	v := h.version
+	if len(h.versions) > 0 {
+		v = slices.Max(h.versions)
+	}
+	sni := "i"
+	if h.sni {
+		sni = "d"
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(v), sni, min(len(h.ciphers), 99), min(len(h.extensions), 99), ja4ALPN(h.alpn))
+
+	b := "000000000000"
+	if len(h.ciphers) > 0 {
+		b = hash12(joinHex(sorted(h.ciphers)))
+	}
+
+	var exts []uint16
+	for _, e := range h.extensions {
+		if e != extServerName && e != extALPN {
+			exts = append(exts, e)
+		}
+	}
+	c := "000000000000"
+	if len(exts) > 0 {
+		s := joinHex(sorted(exts))
+		if len(h.sigAlgs) > 0 {
+			s += "_" + joinHex(h.sigAlgs)
+		}
+		c = hash12(s)
+	}
+	return a + "_" + b + "_" + c
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	}
+	return "00"
+}
+
+// ja4ALPN is the first and last character of the first ALPN protocol, or
+// of its hex form when either is not alphanumeric; "00" without ALPN.
+func ja4ALPN(p string) string {
+	if p == "" {
+		return "00"
+	}
+	first, last := p[0], p[len(p)-1]
+	if isAlnum(first) && isAlnum(last) {
+		return string([]byte{first, last})
+	}
+	x := hex.EncodeToString([]byte(p))
+	return string([]byte{x[0], x[len(x)-1]})
+}
+
+func isAlnum(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func sorted(vs []uint16) []uint16 {
+	out := slices.Clone(vs)
+	slices.Sort(out)
+	return out
+}
+
+func joinHex(vs []uint16) string {
+	s := make([]string, len(vs))
+	for i, v := range vs {
+		s[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(s, ",")
+}
+
+func hash12(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
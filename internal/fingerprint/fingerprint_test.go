@@ -0,0 +1,329 @@
+package fingerprint
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testHello builds a ClientHello handshake message.
+type testHello struct {
+	version uint16
+	ciphers []uint16
+	exts    []testExt
+}
+
+type testExt struct {
+	typ  uint16
+	data []byte
+}
+
+func u16(v int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+
+func vec8(b []byte) []byte  { return append([]byte{byte(len(b))}, b...) }
+func vec16(b []byte) []byte { return append(u16(len(b)), b...) }
+
+func list16(vs ...uint16) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+func sniExt(name string) testExt {
+	entry := append([]byte{0}, vec16([]byte(name))...)
+	return testExt{extServerName, vec16(entry)}
+}
+
+func alpnExt(protos ...string) testExt {
+	var l []byte
+	for _, p := range protos {
+		l = append(l, vec8([]byte(p))...)
+	}
+	return testExt{extALPN, vec16(l)}
+}
+
+func (h testHello) message() []byte {
+	body := u16(int(h.version))
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0)                   // session id
+	body = append(body, vec16(list16(h.ciphers...))...)
+	body = append(body, 1, 0) // null compression
+	var exts []byte
+	for _, e := range h.exts {
+		exts = append(exts, u16(int(e.typ))...)
+		exts = append(exts, vec16(e.data)...)
+	}
+	body = append(body, vec16(exts)...)
+	return append([]byte{1, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+// records splits msg into handshake records of at most size bytes.
+func records(msg []byte, size int) []byte {
+	var out []byte
+	for len(msg) > 0 {
+		n := min(size, len(msg))
+		out = append(out, 22, 3, 1)
+		out = append(out, vec16(msg[:n])...)
+		msg = msg[n:]
+	}
+	return out
+}
+
+// chrome is the ClientHello behind the example in the JA4 specification,
+// with GREASE values in the cipher, extension and version lists.
+var chrome = testHello{
+	version: 0x0303,
+	ciphers: []uint16{0x0a0a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+	exts: []testExt{
+		{0x1a1a, nil},
+		sniExt("example.com"),
+		{0x0017, nil},
+		{0xff01, []byte{0}},
+		{extSupportedGroups, vec16(list16(0x2a2a, 0x001d, 0x0017, 0x0018))},
+		{extPointFormats, vec8([]byte{0})},
+		{0x0023, nil},
+		alpnExt("h2", "http/1.1"),
+		{0x0005, []byte{1, 0, 0, 0, 0}},
+		{extSignatureAlgs, vec16(list16(0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601))},
+		{0x0012, nil},
+		{0x0033, vec16(nil)},
+		{0x002d, vec8([]byte{1})},
+		{extSupportedVersions, vec8(list16(0x3a3a, 0x0304, 0x0303))},
+		{0x001b, []byte{2, 0, 2}},
+		{0x4469, vec16(vec8([]byte("h2")))},
+		{0x0015, make([]byte, 16)},
+		{0x4a4a, []byte{0}},
+	},
+}
+
+// ja3Example is the ClientHello of the example in the JA3 README.
+var ja3Example = testHello{
+	version: 0x0301,
+	ciphers: []uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+	exts: []testExt{
+		sniExt("example.com"),
+		{extSupportedGroups, vec16(list16(23, 24, 25))},
+		{extPointFormats, vec8([]byte{0})},
+	},
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		ja3, ja4  string
+		ja3String string
+	}{
+		{
+			name: "ja4 specification example",
+			data: records(chrome.message(), 1<<14),
+			ja4:  "t13d1516h2_8daaf6152771_e5627efa2ab1",
+			// GREASE values are left out.
+			ja3String: "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53," +
+				"0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0",
+		},
+		{
+			name:      "ja3 readme example",
+			data:      records(ja3Example.message(), 1<<14),
+			ja3:       "ada70206e40642a3e4461f35503241d5",
+			ja3String: "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			ja4:       "t10d120300_",
+		},
+		{
+			name: "split over records",
+			data: records(chrome.message(), 7),
+			ja4:  "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+		{
+			name: "trailing data",
+			data: append(records(chrome.message(), 1<<14), 23, 3, 3, 0, 1, 0),
+			ja4:  "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp, err := Parse(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ja3 != "" && fp.JA3 != tt.ja3 {
+				t.Errorf("JA3 = %s, want %s", fp.JA3, tt.ja3)
+			}
+			if tt.ja3String != "" && fp.JA3String != tt.ja3String {
+				t.Errorf("JA3 string = %s\nwant %s", fp.JA3String, tt.ja3String)
+			}
+			if !strings.HasPrefix(fp.JA4, tt.ja4) {
+				t.Errorf("JA4 = %s, want %s", fp.JA4, tt.ja4)
+			}
+		})
+	}
+}
+
+func TestParseVariants(t *testing.T) {
+	withALPN := func(protos ...string) testHello {
+		h := ja3Example
+		h.exts = append(slices.Clone(h.exts), alpnExt(protos...))
+		return h
+	}
+	noSNI := ja3Example
+	noSNI.exts = ja3Example.exts[1:]
+	tests := []struct {
+		name string
+		h    testHello
+		a    string // first JA4 part
+	}{
+		{"no sni", noSNI, "t10i120200"},
+		{"alpn", withALPN("http/1.1"), "t10d1204h1"},
+		{"alpn not alphanumeric", withALPN("h2-"), "t10d12046d"},
+		{"no ciphers", testHello{version: 0x0303}, "t12i000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp, err := Parse(records(tt.h.message(), 1<<14))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a, _, _ := strings.Cut(fp.JA4, "_"); a != tt.a {
+				t.Errorf("JA4 = %s, want it to start with %s", fp.JA4, tt.a)
+			}
+		})
+	}
+
+	// Without ciphers or extensions the hashes are zeros.
+	fp, err := Parse(records(testHello{version: 0x0303}.message(), 1<<14))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp.JA4 != "t12i000000_000000000000_000000000000" {
+		t.Errorf("empty hello JA4 = %s", fp.JA4)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	msg := chrome.message()
+	full := records(msg, 1<<14)
+	notHello := append([]byte(nil), msg...)
+	notHello[0] = 2 // server_hello
+	truncatedExts := append([]byte(nil), msg...)
+	truncatedExts[len(truncatedExts)-3] = 0xff // last extension runs past the message
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"plaintext", []byte("GET / HTTP/1.1\r\n\r\n")},
+		{"short record", full[:len(full)-1]},
+		{"missing second record", records(msg, 100)[:105]},
+		{"server hello", records(notHello, 1<<14)},
+		{"truncated extensions", records(truncatedExts, 1<<14)},
+		{"alert record", []byte{21, 3, 3, 0, 2, 2, 40}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if fp, err := Parse(tt.data); !errors.Is(err, ErrNotClientHello) {
+				t.Errorf("Parse = %v, %v; want ErrNotClientHello", fp, err)
+			}
+		})
+	}
+}
+
+func TestIsGREASE(t *testing.T) {
+	for v := 0; v <= 0xffff; v++ {
+		want := v&0xff == v>>8 && v&0x0f == 0x0a
+		if got := isGREASE(uint16(v)); got != want {
+			t.Fatalf("isGREASE(%#04x) = %v", v, got)
+		}
+	}
+}
+
+// readConn is a net.Conn that only reads, from r.
+type readConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// checked reads data through a fingerprinting Conn, under a NetConn
+// wrapper as in echo-server's -http mode, and runs the policy on it.
+func checked(t *testing.T, p *Policy, data []byte) error {
+	t.Helper()
+	fc := Wrap(readConn{r: bytes.NewReader(data)})
+	if _, err := io.Copy(io.Discard, fc); err != nil {
+		t.Fatal(err)
+	}
+	return p.check(wrapped{fc})
+}
+
+type wrapped struct{ net.Conn }
+
+func (w wrapped) NetConn() net.Conn { return w.Conn }
+
+func TestPolicy(t *testing.T) {
+	hello := records(chrome.message(), 1<<14)
+	fp, err := Parse(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enough one-byte records to go past maxRecord before the hello is
+	// complete, which crypto/tls still accepts.
+	padded := chrome
+	padded.exts = append(slices.Clone(chrome.exts), testExt{0x0015, make([]byte, maxRecord/6)})
+	tiny := records(padded.message(), 1)
+
+	tests := []struct {
+		name        string
+		allow, deny []string
+		data        []byte
+		denied      bool
+	}{
+		{"no lists", nil, nil, hello, false},
+		{"allowed by ja4", []string{fp.JA4}, nil, hello, false},
+		{"allowed by ja3", []string{fp.JA3}, nil, hello, false},
+		{"not on allow list", []string{"t13d000000_000000000000_000000000000"}, nil, hello, true},
+		{"denied by ja4", nil, []string{fp.JA4}, hello, true},
+		{"deny wins", []string{fp.JA4}, []string{fp.JA3}, hello, true},
+		{"unparsed without allow list", nil, []string{fp.JA4}, tiny, false},
+		{"unparsed with allow list", []string{fp.JA4}, nil, tiny, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checked(t, NewPolicy("test", tt.allow, tt.deny), tt.data)
+			if denied := errors.Is(err, ErrDenied); denied != tt.denied {
+				t.Errorf("check = %v, want denied %v", err, tt.denied)
+			}
+		})
+	}
+
+	// Connections that were never wrapped are not checked.
+	if err := NewPolicy("test", []string{fp.JA4}, nil).check(readConn{}); err != nil {
+		t.Errorf("unwrapped conn: %v", err)
+	}
+}
+
+func TestParseList(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(file, []byte("# known clients\nt13d1516h2_8daaf6152771_e5627efa2ab1\n\nada70206e40642a3e4461f35503241d5 # ja3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseList(" abc , @" + file + ",,def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"abc", "t13d1516h2_8daaf6152771_e5627efa2ab1", "ada70206e40642a3e4461f35503241d5", "def"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseList = %q, want %q", got, want)
+	}
+	if _, err := ParseList("@" + file + ".missing"); err == nil {
+		t.Error("missing file: no error")
+	}
+}
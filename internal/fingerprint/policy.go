@@ -0,0 +1,129 @@
+package fingerprint
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"tls-lab/internal/metrics"
+)
+
+// ErrDenied is wrapped by the handshake error of a client that a Policy
+// rejects.
+var ErrDenied = errors.New("client fingerprint denied")
+
+// Policy fingerprints the ClientHellos of one listener, counts them in the
+// metrics and enforces allow/deny lists of JA3 hashes and JA4 strings.
+// Deny wins; a non-empty allow list rejects everything not on it.
+type Policy struct {
+	name        string
+	allow, deny map[string]bool
+}
+
+// NewPolicy returns a policy whose metrics are labelled with name.
+func NewPolicy(name string, allow, deny []string) *Policy {
+	return &Policy{name: name, allow: set(allow), deny: set(deny)}
+}
+
+func set(list []string) map[string]bool {
+	m := make(map[string]bool, len(list))
+	for _, s := range list {
+		m[s] = true
+	}
+	return m
+}
+
+// ParseList parses a -fingerprint-allow/-deny value: comma-separated JA3
+// hashes or JA4 strings, where "@file" reads one per line from file
+// (blank lines and # comments are skipped).
+func ParseList(s string) ([]string, error) {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.HasPrefix(item, "@") {
+			out = append(out, item)
+			continue
+		}
+		data, err := os.ReadFile(item[1:])
+		if err != nil {
+			return nil, fmt.Errorf("fingerprint list: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			if line = strings.TrimSpace(line); line != "" {
+				out = append(out, line)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Config returns a copy of cfg that checks every ClientHello before the
+// handshake goes on. Only connections wrapped by Wrap or NewListener, or
+// whose NetConn chain leads to one, are fingerprinted; others pass
+// unchecked.
+func (p *Policy) Config(cfg *tls.Config) *tls.Config {
+	cfg = cfg.Clone()
+	next := cfg.GetConfigForClient
+	cfg.GetConfigForClient = func(hi *tls.ClientHelloInfo) (*tls.Config, error) {
+		if err := p.check(hi.Conn); err != nil {
+			return nil, err
+		}
+		if next != nil {
+			return next(hi)
+		}
+		return nil, nil
+	}
+	return cfg
+}
+
+func (p *Policy) check(c net.Conn) error {
+	fc := find(c)
+	if fc == nil {
+		return nil
+	}
+	fp := fc.Fingerprint()
+	if fp == nil {
+		// crypto/tls accepted a hello we could not parse, for example one
+		// spread over enough tiny records to pass maxRecord. With an allow
+		// list that must not let the client through.
+		if len(p.allow) > 0 {
+			p.count("unparsed", "denied", nil)
+			return fmt.Errorf("%w: hello could not be fingerprinted", ErrDenied)
+		}
+		p.count("unparsed", "allowed", nil)
+		return nil
+	}
+	var err error
+	switch {
+	case p.deny[fp.JA3] || p.deny[fp.JA4]:
+		err = fmt.Errorf("%w: on deny list", ErrDenied)
+	case len(p.allow) > 0 && !p.allow[fp.JA3] && !p.allow[fp.JA4]:
+		err = fmt.Errorf("%w: not on allow list", ErrDenied)
+	}
+	action := "allowed"
+	if err != nil {
+		action = "denied"
+	}
+	class := "other"
+	if p.allow[fp.JA3] || p.allow[fp.JA4] || p.deny[fp.JA3] || p.deny[fp.JA4] {
+		class = "listed"
+	}
+	p.count(class, action, fp)
+	return err
+}
+
+// count records a checked hello. Fingerprints are client-chosen, so the
+// metric only tells listed ones from the rest and the raw values go to the
+// debug log.
+func (p *Policy) count(class, action string, fp *Fingerprint) {
+	metrics.ClientHello(p.name, class, action)
+	slog.Debug("client hello", "listener", p.name, "fingerprint", fp, "class", class, "action", action)
+}
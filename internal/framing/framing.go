@@ -0,0 +1,199 @@
+// Package framing is the message framing shared by echo-server and
+// echo-client: a raw byte stream, newline-delimited lines, or
+// length-prefixed binary frames, each with a maximum message size.
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Mode selects the framing.
+type Mode string
+
+const (
+	Raw    Mode = "raw"    // no framing; reads return whatever is available
+	Line   Mode = "line"   // messages end with '\n' (a trailing '\r' is kept)
+	Length Mode = "length" // 4-byte big-endian length, then the payload
+)
+
+// DefaultMaxFrame is used when no maximum is given.
+const DefaultMaxFrame = 64 * 1024
+
+// Length-prefixed frames with this bit set in the header carry a protocol
+// error message from the peer instead of data.
+const errorFlag = 1 << 31
+
+// ParseMode parses a -frame flag value.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(s)); m {
+	case Raw, Line, Length:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown framing %q (want raw, line or length)", s)
+}
+
+// ErrFrameTooLarge is wrapped by errors for frames over the maximum size.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// ProtocolError is an error reported by the peer in-band.
+type ProtocolError struct {
+	Message string
+}
+
+func (e *ProtocolError) Error() string { return "peer protocol error: " + e.Message }
+
+// Reader reads frames from a stream.
+type Reader struct {
+	mode Mode
+	max  int
+	br   *bufio.Reader
+	raw  []byte
+}
+
+// NewReader returns a Reader; max <= 0 means DefaultMaxFrame.
+func NewReader(r io.Reader, mode Mode, max int) *Reader {
+	if max <= 0 {
+		max = DefaultMaxFrame
+	}
+	size := max + 1
+	if size > DefaultMaxFrame {
+		size = DefaultMaxFrame
+	}
+	if size < 16 {
+		size = 16
+	}
+	return &Reader{mode: mode, max: max, br: bufio.NewReaderSize(r, size)}
+}
+
+// Buffered returns the number of bytes read from the stream but not yet
+// returned as frames.
+func (r *Reader) Buffered() int { return r.br.Buffered() }
+
+// ReadFrame returns the next message. In line mode the '\n' is stripped.
+// The returned slice is only valid until the next call.
+func (r *Reader) ReadFrame() ([]byte, error) {
+	switch r.mode {
+	case Line:
+		return r.readLine()
+	case Length:
+		return r.readLength()
+	default:
+		if r.raw == nil {
+			r.raw = make([]byte, r.max)
+		}
+		n, err := r.br.Read(r.raw)
+		if n > 0 {
+			return r.raw[:n], nil
+		}
+		return nil, err
+	}
+}
+
+func (r *Reader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.br.ReadSlice('\n')
+		if len(line)+len(chunk) > r.max+1 {
+			return nil, fmt.Errorf("%w: line exceeds %d bytes", ErrFrameTooLarge, r.max)
+		}
+		line = append(line, chunk...)
+		switch {
+		case err == nil:
+			return line[:len(line)-1], nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(line) > 0:
+			return nil, io.ErrUnexpectedEOF
+		default:
+			return nil, err
+		}
+	}
+}
+
+func (r *Reader) readLength() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r.br, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	isErr := n&errorFlag != 0
+	n &^= errorFlag
+	if int64(n) > int64(r.max) {
+		return nil, fmt.Errorf("%w: %d bytes announced, max %d", ErrFrameTooLarge, n, r.max)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r.br, buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if isErr {
+		return nil, &ProtocolError{Message: string(buf)}
+	}
+	return buf, nil
+}
+
+// Writer writes frames to a stream.
+type Writer struct {
+	mode Mode
+	max  int
+	w    io.Writer
+}
+
+// NewWriter returns a Writer; max <= 0 means DefaultMaxFrame.
+func NewWriter(w io.Writer, mode Mode, max int) *Writer {
+	if max <= 0 {
+		max = DefaultMaxFrame
+	}
+	return &Writer{mode: mode, max: max, w: w}
+}
+
+// WriteFrame writes one message. Messages over the maximum are refused
+// rather than truncated.
+func (w *Writer) WriteFrame(p []byte) error {
+	if len(p) > w.max {
+		return fmt.Errorf("%w: %d bytes, max %d", ErrFrameTooLarge, len(p), w.max)
+	}
+	switch w.mode {
+	case Line:
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			return fmt.Errorf("line frame contains a newline at offset %d", i)
+		}
+		buf := make([]byte, 0, len(p)+1)
+		buf = append(append(buf, p...), '\n')
+		_, err := w.w.Write(buf)
+		return err
+	case Length:
+		return w.writeLength(p, 0)
+	default:
+		_, err := w.w.Write(p)
+		return err
+	}
+}
+
+// WriteError reports a protocol error to the peer: an "ERR <msg>" line in
+// line mode, a flagged frame in length mode, and plain text in raw mode.
+func (w *Writer) WriteError(msg string) error {
+	switch w.mode {
+	case Length:
+		return w.writeLength([]byte(msg), errorFlag)
+	default:
+		_, err := io.WriteString(w.w, "ERR "+msg+"\n")
+		return err
+	}
+}
+
+func (w *Writer) writeLength(p []byte, flags uint32) error {
+	buf := make([]byte, 4+len(p))
+	binary.BigEndian.PutUint32(buf, uint32(len(p))|flags)
+	copy(buf[4:], p)
+	_, err := w.w.Write(buf)
+	return err
+}
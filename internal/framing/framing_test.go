@@ -0,0 +1,187 @@
+package framing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 3*DefaultMaxFrame) // spans several reader buffers
+	for _, mode := range []Mode{Line, Length} {
+		t.Run(string(mode), func(t *testing.T) {
+			msgs := []string{"hello", "", "with\rcarriage return", "tab\tand spaces  ", long}
+			var buf bytes.Buffer
+			w := NewWriter(&buf, mode, len(long))
+			for _, m := range msgs {
+				if err := w.WriteFrame([]byte(m)); err != nil {
+					t.Fatalf("WriteFrame(%.20q): %v", m, err)
+				}
+			}
+			r := NewReader(&buf, mode, len(long))
+			for _, want := range msgs {
+				got, err := r.ReadFrame()
+				if err != nil {
+					t.Fatalf("ReadFrame: %v", err)
+				}
+				if string(got) != want {
+					t.Fatalf("ReadFrame = %.20q (%d bytes), want %.20q (%d bytes)", got, len(got), want, len(want))
+				}
+			}
+			if _, err := r.ReadFrame(); err != io.EOF {
+				t.Fatalf("ReadFrame at end = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestRaw(t *testing.T) {
+	r := NewReader(strings.NewReader("abcdefghij"), Raw, 4)
+	var got []string
+	for {
+		b, err := r.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(b))
+	}
+	if strings.Join(got, "") != "abcdefghij" {
+		t.Fatalf("frames = %q", got)
+	}
+	for _, f := range got {
+		if len(f) > 4 {
+			t.Errorf("frame %q longer than max", f)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := NewWriter(&buf, Raw, 4).WriteFrame([]byte("a\nb")); err != nil || buf.String() != "a\nb" {
+		t.Fatalf("raw WriteFrame: %q, %v", buf.String(), err)
+	}
+}
+
+func TestLineLimits(t *testing.T) {
+	const max = 8
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		err   error // after the frames in want
+	}{
+		{"exactly max", "12345678\n", []string{"12345678"}, io.EOF},
+		{"one over", "123456789\n", nil, ErrFrameTooLarge},
+		{"over without newline", "1234567890", nil, ErrFrameTooLarge},
+		{"crlf kept", "ab\r\n", []string{"ab\r"}, io.EOF},
+		{"truncated", "ab\ncd", []string{"ab"}, io.ErrUnexpectedEOF},
+		{"empty lines", "\n\n", []string{"", ""}, io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.input), Line, max)
+			for _, want := range tt.want {
+				got, err := r.ReadFrame()
+				if err != nil || string(got) != want {
+					t.Fatalf("ReadFrame = %q, %v; want %q", got, err, want)
+				}
+			}
+			if _, err := r.ReadFrame(); !errors.Is(err, tt.err) {
+				t.Fatalf("ReadFrame error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLengthLimits(t *testing.T) {
+	frame := func(n uint32, payload string) string {
+		var hdr [4]byte
+		binary.BigEndian.PutUint32(hdr[:], n)
+		return string(hdr[:]) + payload
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		err   error
+	}{
+		{"exactly max", frame(8, "12345678"), "12345678", nil},
+		{"announced over max", frame(9, "123456789"), "", ErrFrameTooLarge},
+		{"huge announcement", frame(errorFlag-1, ""), "", ErrFrameTooLarge},
+		{"short header", "\x00\x00", "", io.ErrUnexpectedEOF},
+		{"short payload", frame(4, "ab"), "", io.ErrUnexpectedEOF},
+		{"eof", "", "", io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewReader(strings.NewReader(tt.input), Length, 8).ReadFrame()
+			if !errors.Is(err, tt.err) || string(got) != tt.want {
+				t.Fatalf("ReadFrame = %q, %v; want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestWriteLimits(t *testing.T) {
+	for _, mode := range []Mode{Raw, Line, Length} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, mode, 4)
+		if err := w.WriteFrame([]byte("12345")); !errors.Is(err, ErrFrameTooLarge) {
+			t.Errorf("%s: WriteFrame over max = %v, want ErrFrameTooLarge", mode, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s: %d bytes written for a refused frame", mode, buf.Len())
+		}
+	}
+	var buf bytes.Buffer
+	if err := NewWriter(&buf, Line, 0).WriteFrame([]byte("a\nb")); err == nil {
+		t.Error("line frame with a newline: no error")
+	}
+	if err := NewWriter(&buf, Length, 0).WriteFrame(make([]byte, DefaultMaxFrame+1)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("default max: %v, want ErrFrameTooLarge", err)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Length, 0)
+	if err := w.WriteFrame([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteError("frame too large"); err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(&buf, Length, 0)
+	if got, err := r.ReadFrame(); err != nil || string(got) != "data" {
+		t.Fatalf("ReadFrame = %q, %v", got, err)
+	}
+	_, err := r.ReadFrame()
+	var pe *ProtocolError
+	if !errors.As(err, &pe) || pe.Message != "frame too large" {
+		t.Fatalf("ReadFrame = %v, want ProtocolError", err)
+	}
+
+	for _, mode := range []Mode{Line, Raw} {
+		buf.Reset()
+		if err := NewWriter(&buf, mode, 0).WriteError("bad"); err != nil || buf.String() != "ERR bad\n" {
+			t.Errorf("%s: WriteError wrote %q, %v", mode, buf.String(), err)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for in, want := range map[string]Mode{"raw": Raw, "LINE": Line, "Length": Length} {
+		if got, err := ParseMode(in); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %q, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "lines", "len"} {
+		if _, err := ParseMode(in); err == nil {
+			t.Errorf("ParseMode(%q): no error", in)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"tls-lab/internal/tlsutil"
+)
+
+// Cert fails when cfg has no certificate, or its leaf is not yet valid or
+// has expired.
+func Cert(cfg *tls.Config) Check {
+	leaf := tlsutil.LeafOf(cfg)
+	return func(context.Context) error {
+		if leaf == nil {
+			return errors.New("no certificate loaded")
+		}
+		now := time.Now()
+		switch {
+		case now.Before(leaf.NotBefore):
+			return fmt.Errorf("certificate not valid before %s", leaf.NotBefore.Format(time.RFC3339))
+		case now.After(leaf.NotAfter):
+			return fmt.Errorf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
+
+// Dial fails when a TCP connection to addr cannot be opened within timeout.
+// hello, if not empty, is written before closing, for targets that expect a
+// preamble such as a PROXY LOCAL header.
+func Dial(addr string, timeout time.Duration, hello []byte) Check {
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		var d net.Dialer
+		c, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		if len(hello) > 0 {
+			_ = c.SetWriteDeadline(time.Now().Add(timeout))
+			if _, err := c.Write(hello); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Cached runs check at most once per ttl and otherwise returns its last
+// result, for checks that cost the target something, such as Dial.
+func Cached(check Check, ttl time.Duration) Check {
+	var (
+		mu   sync.Mutex
+		last time.Time
+		err  error
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(last) >= ttl {
+			err, last = check(ctx), time.Now()
+		}
+		return err
+	}
+}
@@ -0,0 +1,52 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// RegisterGRPC registers the grpc.health.v1 service on srv and keeps it in
+// step with c: the server as a whole ("") and every service registered on
+// srv so far are SERVING while c is ready and NOT_SERVING otherwise, polled
+// every interval. A service with an entry in checks must in addition pass
+// that check, so a dependency only one service uses takes only that service
+// out; checks may be nil. When ctx is cancelled all of them turn
+// NOT_SERVING for good, so clients and load balancers move away before the
+// drain.
+func (c *Checker) RegisterGRPC(ctx context.Context, srv *grpc.Server, interval time.Duration, checks map[string]Check) {
+	hs := grpchealth.NewServer()
+	services := []string{""}
+	for name := range srv.GetServiceInfo() {
+		services = append(services, name)
+	}
+	healthpb.RegisterHealthServer(srv, hs)
+
+	update := func() {
+		ready := c.Check(ctx).Ready
+		for _, name := range services {
+			status := healthpb.HealthCheckResponse_SERVING
+			if check, ok := checks[name]; !ready || ok && check(ctx) != nil {
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+			}
+			hs.SetServingStatus(name, status)
+		}
+	}
+	update()
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				hs.Shutdown()
+				return
+			case <-t.C:
+				update()
+			}
+		}
+	}()
+}
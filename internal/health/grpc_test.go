@@ -0,0 +1,92 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRegisterGRPC(t *testing.T) {
+	srv := grpc.NewServer()
+	for _, name := range []string{"test.Plain", "test.Backed"} {
+		srv.RegisterService(&grpc.ServiceDesc{ServiceName: name, HandlerType: (*any)(nil)}, struct{}{})
+	}
+	var backend atomic.Pointer[error]
+	down := errors.New("backend down")
+	backend.Store(&down)
+
+	c := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.RegisterGRPC(ctx, srv, 10*time.Millisecond, map[string]Check{
+		"test.Backed": func(context.Context) error { return *backend.Load() },
+	})
+
+	ln := bufconn.Listen(1 << 16)
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	// expect polls until every service reports its wanted status.
+	expect := func(want map[string]healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			got := map[string]healthpb.HealthCheckResponse_ServingStatus{}
+			for name := range want {
+				resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: name})
+				if err != nil {
+					t.Fatalf("Check(%q): %v", name, err)
+				}
+				got[name] = resp.GetStatus()
+			}
+			same := true
+			for name, s := range want {
+				same = same && got[name] == s
+			}
+			if same {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("statuses = %v, want %v", got, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	const (
+		serving    = healthpb.HealthCheckResponse_SERVING
+		notServing = healthpb.HealthCheckResponse_NOT_SERVING
+	)
+
+	// Only the service whose own check fails is out.
+	expect(map[string]healthpb.HealthCheckResponse_ServingStatus{"": serving, "test.Plain": serving, "test.Backed": notServing})
+
+	var ok error
+	backend.Store(&ok)
+	expect(map[string]healthpb.HealthCheckResponse_ServingStatus{"": serving, "test.Plain": serving, "test.Backed": serving})
+
+	// The server's own readiness takes every service out.
+	c.Set("listener", errors.New("not bound"))
+	expect(map[string]healthpb.HealthCheckResponse_ServingStatus{"": notServing, "test.Plain": notServing, "test.Backed": notServing})
+	c.Set("listener", nil)
+	expect(map[string]healthpb.HealthCheckResponse_ServingStatus{"": serving, "test.Plain": serving, "test.Backed": serving})
+
+	// Shutdown is final.
+	cancel()
+	expect(map[string]healthpb.HealthCheckResponse_ServingStatus{"": notServing, "test.Plain": notServing, "test.Backed": notServing})
+}
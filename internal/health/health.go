@@ -0,0 +1,115 @@
+// Package health reports whether a server is ready for traffic. A Checker
+// combines named checks, run on every probe, with a draining flag set on
+// shutdown. The admin API serves it at /readyz and the gRPC servers mirror
+// it into the standard grpc.health.v1 service.
+package health
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// Check returns nil when the thing it checks is fine.
+type Check func(ctx context.Context) error
+
+// Checker is the readiness of one server.
+type Checker struct {
+	mu       sync.Mutex
+	names    []string // in registration order
+	checks   map[string]Check
+	draining atomic.Bool
+	ready    atomic.Bool // outcome of the last Check, for logging changes
+}
+
+// New returns a Checker without checks, which is ready.
+func New() *Checker {
+	c := &Checker{checks: make(map[string]Check)}
+	c.ready.Store(true)
+	return c
+}
+
+// Add registers check under name, replacing an earlier one of that name.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Set records a fixed state for name, e.g. Set("listener", nil) once the
+// listener is bound.
+func (c *Checker) Set(name string, err error) {
+	c.Add(name, func(context.Context) error { return err })
+}
+
+// DrainOnDone marks c as draining, and so not ready, when ctx is cancelled.
+func (c *Checker) DrainOnDone(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		c.draining.Store(true)
+	}()
+}
+
+// Draining reports whether the server is shutting down.
+func (c *Checker) Draining() bool { return c.draining.Load() }
+
+// Result is the outcome of one check.
+type Result struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the outcome of all checks.
+type Report struct {
+	Ready    bool     `json:"ready"`
+	Draining bool     `json:"draining,omitempty"`
+	Checks   []Result `json:"checks"`
+}
+
+// Check runs all checks concurrently and reports the server ready when none
+// failed and it is not draining. Changes of readiness are logged.
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	names := append([]string(nil), c.names...)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = c.checks[name]
+	}
+	c.mu.Unlock()
+
+	rep := Report{Draining: c.Draining(), Checks: make([]Result, len(names))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rep.Checks[i] = Result{Name: names[i], OK: true}
+			if err := check(ctx); err != nil {
+				rep.Checks[i] = Result{Name: names[i], Error: err.Error()}
+			}
+		}()
+	}
+	wg.Wait()
+
+	rep.Ready = !rep.Draining
+	var failed []string
+	for _, r := range rep.Checks {
+		if !r.OK {
+			rep.Ready = false
+			failed = append(failed, r.Name+": "+r.Error)
+		}
+	}
+	if c.ready.Swap(rep.Ready) != rep.Ready {
+		if rep.Ready {
+			slog.Info("server ready")
+		} else {
+			slog.Warn("server not ready", "draining", rep.Draining, "failed", failed)
+		}
+	}
+	return rep
+}
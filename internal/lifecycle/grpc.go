@@ -0,0 +1,57 @@
+package lifecycle
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// RPCTracker counts in-flight RPCs so a gRPC drain can be summarised.
+type RPCTracker struct {
+	active atomic.Int64
+}
+
+// UnaryInterceptor tracks unary RPCs.
+func (t *RPCTracker) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		t.active.Add(1)
+		defer t.active.Add(-1)
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor tracks streaming RPCs.
+func (t *RPCTracker) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		t.active.Add(1)
+		defer t.active.Add(-1)
+		return handler(srv, ss)
+	}
+}
+
+// Active returns the number of RPCs in flight.
+func (t *RPCTracker) Active() int { return int(t.active.Load()) }
+
+// StopGRPC calls GracefulStop and falls back to Stop after timeout.
+func StopGRPC(srv *grpc.Server, t *RPCTracker, timeout time.Duration) Summary {
+	start := time.Now()
+	s := Summary{Active: t.Active()}
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		s.Killed = t.Active()
+		srv.Stop()
+		<-stopped
+	}
+	s.Drained = s.Active - s.Killed
+	s.Elapsed = time.Since(start)
+	return s
+}
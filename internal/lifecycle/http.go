@@ -0,0 +1,44 @@
+package lifecycle
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPTracker counts open HTTP connections; install ConnState as the
+// server's ConnState hook.
+type HTTPTracker struct {
+	active atomic.Int64
+}
+
+// ConnState is an http.Server ConnState hook.
+func (t *HTTPTracker) ConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		t.active.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		t.active.Add(-1)
+	}
+}
+
+// Active returns the number of open connections.
+func (t *HTTPTracker) Active() int { return int(t.active.Load()) }
+
+// StopHTTP calls Shutdown and falls back to Close after timeout.
+func StopHTTP(srv *http.Server, t *HTTPTracker, timeout time.Duration) Summary {
+	start := time.Now()
+	s := Summary{Active: t.Active()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		s.Killed = t.Active()
+		_ = srv.Close()
+	}
+	s.Drained = s.Active - s.Killed
+	s.Elapsed = time.Since(start)
+	return s
+}
@@ -0,0 +1,112 @@
+// Package lifecycle provides shutdown handling shared by the servers: stop
+// accepting on SIGINT/SIGTERM, let active connections finish within a drain
+// deadline, then force-close whatever is left.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SignalContext returns a context cancelled on SIGINT or SIGTERM.
+func SignalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// CloseOnDone closes ln when ctx is cancelled, which ends the accept loop.
+func CloseOnDone(ctx context.Context, ln net.Listener) {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+}
+
+// IsClosed reports whether an Accept error means the listener was closed.
+func IsClosed(err error) bool {
+	return errors.Is(err, net.ErrClosed)
+}
+
+// Summary describes the outcome of a drain.
+type Summary struct {
+	Active  int // connections (or RPCs) open when draining started
+	Drained int // finished on their own before the deadline
+	Killed  int // force-closed at the deadline
+	Elapsed time.Duration
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("active=%d drained=%d killed=%d in %s", s.Active, s.Drained, s.Killed, s.Elapsed.Truncate(time.Millisecond))
+}
+
+// Group tracks the live connections of a listener.
+type Group struct {
+	mu    sync.Mutex
+	conns map[io.Closer]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewGroup returns an empty Group.
+func NewGroup() *Group {
+	return &Group{conns: map[io.Closer]struct{}{}}
+}
+
+// Track registers c (a net.Conn, or anything else closed to force it off);
+// the returned func must be called when its handler returns.
+func (g *Group) Track(c io.Closer) (done func()) {
+	g.mu.Lock()
+	g.conns[c] = struct{}{}
+	g.mu.Unlock()
+	g.wg.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			delete(g.conns, c)
+			g.mu.Unlock()
+			g.wg.Done()
+		})
+	}
+}
+
+// Active returns the number of tracked connections.
+func (g *Group) Active() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.conns)
+}
+
+// Drain waits up to timeout for tracked connections to finish, then closes
+// the remaining ones and waits for their handlers to return.
+func (g *Group) Drain(timeout time.Duration) Summary {
+	start := time.Now()
+	s := Summary{Active: g.Active()}
+
+	finished := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+		g.mu.Lock()
+		s.Killed = len(g.conns)
+		for c := range g.conns {
+			_ = c.Close()
+		}
+		g.mu.Unlock()
+		<-finished
+	}
+	s.Drained = s.Active - s.Killed
+	s.Elapsed = time.Since(start)
+	return s
+}
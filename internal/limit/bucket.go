@@ -0,0 +1,109 @@
+// Package limit holds the admission controls used by the listeners:
+// token buckets, per-key rate limiters and the TLS handshake gate.
+package limit
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// TokenBucket allows rate events per second with bursts of up to burst.
+// A zero rate means unlimited.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full bucket.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Allow takes a token if one is available.
+func (b *TokenBucket) Allow() bool {
+	if b == nil || b.rate <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *TokenBucket) idleSince() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last
+}
+
+// KeyedLimiter keeps one TokenBucket per key (e.g. source IP). Buckets idle
+// for longer than a minute are dropped.
+type KeyedLimiter struct {
+	rate  float64
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*TokenBucket
+	lastSweep time.Time
+}
+
+const sweepEvery = time.Minute
+
+// NewKeyedLimiter returns a limiter; a zero rate disables it.
+func NewKeyedLimiter(rate float64, burst int) *KeyedLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &KeyedLimiter{rate: rate, burst: burst, buckets: map[string]*TokenBucket{}, lastSweep: time.Now()}
+}
+
+// Allow takes a token from key's bucket.
+func (l *KeyedLimiter) Allow(key string) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) > sweepEvery {
+		for k, b := range l.buckets {
+			if now.Sub(b.idleSince()) > sweepEvery {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewTokenBucket(l.rate, l.burst)
+		l.buckets[key] = b
+	}
+	l.mu.Unlock()
+	return b.Allow()
+}
+
+// HostOf returns the IP part of a net.Addr, or its string form.
+func HostOf(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(a.String()); err == nil {
+		return host
+	}
+	return a.String()
+}
@@ -0,0 +1,81 @@
+package limit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(20, 2)
+	for i := range 2 {
+		if !b.Allow() {
+			t.Fatalf("burst token %d refused", i)
+		}
+	}
+	if b.Allow() {
+		t.Fatal("allowed past the burst")
+	}
+	// 20/s refills a token every 50ms.
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("no token after refill")
+	}
+	if b.Allow() {
+		t.Fatal("refilled more than one token")
+	}
+	// Refill stops at the burst.
+	time.Sleep(250 * time.Millisecond)
+	allowed := 0
+	for b.Allow() {
+		allowed++
+	}
+	if allowed != 2 {
+		t.Errorf("allowed %d after a long idle, want the burst of 2", allowed)
+	}
+
+	for _, b := range []*TokenBucket{nil, NewTokenBucket(0, 1)} {
+		for range 100 {
+			if !b.Allow() {
+				t.Fatalf("unlimited bucket %v refused", b)
+			}
+		}
+	}
+	if b := NewTokenBucket(1, 0); !b.Allow() || b.Allow() {
+		t.Error("burst 0 does not mean a burst of 1")
+	}
+}
+
+func TestKeyedLimiter(t *testing.T) {
+	l := NewKeyedLimiter(0.001, 1)
+	if !l.Allow("a") || l.Allow("a") {
+		t.Fatal("key a: want one token")
+	}
+	if !l.Allow("b") {
+		t.Fatal("key b shares key a's bucket")
+	}
+	if NewKeyedLimiter(0, 1) != nil {
+		t.Error("zero rate: limiter is not nil")
+	}
+	var off *KeyedLimiter
+	if !off.Allow("a") {
+		t.Error("nil limiter refused")
+	}
+}
+
+func TestHostOf(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		want string
+	}{
+		{nil, ""},
+		{&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443}, "192.0.2.1"},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}, "2001:db8::1"},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, "/tmp/sock"},
+	}
+	for _, tt := range tests {
+		if got := HostOf(tt.addr); got != tt.want {
+			t.Errorf("HostOf(%v) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package limit
+
+import (
+	"expvar"
+	"net"
+	"sync"
+)
+
+// ConnLimits configures a Listener. Zero values disable each limit.
+type ConnLimits struct {
+	MaxConns   int     // concurrent connections overall
+	MaxPerIP   int     // concurrent connections per source IP
+	Rate       float64 // new connections per second overall
+	Burst      int
+	PerIPRate  float64 // new connections per second per source IP
+	PerIPBurst int
+}
+
+// connStats counts "<listener>.<outcome>": accepted, max_conns, max_per_ip,
+// rate, rate_per_ip and identity (see IdentityQuota).
+var connStats = expvar.NewMap("conn_limits")
+
+// Listener enforces ConnLimits on accepted connections. Rejected connections
+// are closed straight away, before any TLS handshake, and never returned
+// from Accept. Wrap it with tls.NewListener, not the other way round.
+type Listener struct {
+	net.Listener
+	name   string
+	limits ConnLimits
+	rate   *TokenBucket
+	perIP  *KeyedLimiter
+
+	mu     sync.Mutex
+	active int
+	byIP   map[string]int
+}
+
+// NewListener wraps inner; counters are published under name.
+func NewListener(inner net.Listener, name string, limits ConnLimits) *Listener {
+	l := &Listener{
+		Listener: inner,
+		name:     name,
+		limits:   limits,
+		perIP:    NewKeyedLimiter(limits.PerIPRate, limits.PerIPBurst),
+		byIP:     map[string]int{},
+	}
+	if limits.Rate > 0 {
+		l.rate = NewTokenBucket(limits.Rate, limits.Burst)
+	}
+	return l
+}
+
+// Accept returns the next connection within limits.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := HostOf(c.RemoteAddr())
+		if !l.count(ip) {
+			_ = c.Close()
+			continue
+		}
+		return &limitedConn{Conn: c, l: l, ip: ip}, nil
+	}
+}
+
+// Admit applies the same limits, and counts against the same totals, for a
+// connection from addr that did not come through Accept, such as a QUIC
+// connection. When ok is false the caller closes the connection; otherwise
+// it calls release once the connection ends.
+func (l *Listener) Admit(addr net.Addr) (release func(), ok bool) {
+	ip := HostOf(addr)
+	if !l.count(ip) {
+		return nil, false
+	}
+	var once sync.Once
+	return func() { once.Do(func() { l.release(ip) }) }, true
+}
+
+func (l *Listener) count(ip string) bool {
+	if reason := l.admit(ip); reason != "" {
+		connStats.Add(l.name+"."+reason, 1)
+		return false
+	}
+	connStats.Add(l.name+".accepted", 1)
+	return true
+}
+
+// Active returns the number of open connections.
+func (l *Listener) Active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active
+}
+
+// admit checks the caps before the rates, so a connection refused for a
+// cap does not use up a rate token.
+func (l *Listener) admit(ip string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limits.MaxConns > 0 && l.active >= l.limits.MaxConns {
+		return "max_conns"
+	}
+	if l.limits.MaxPerIP > 0 && l.byIP[ip] >= l.limits.MaxPerIP {
+		return "max_per_ip"
+	}
+	if !l.rate.Allow() {
+		return "rate"
+	}
+	if !l.perIP.Allow(ip) {
+		return "rate_per_ip"
+	}
+	l.active++
+	l.byIP[ip]++
+	return ""
+}
+
+func (l *Listener) release(ip string) {
+	l.mu.Lock()
+	l.active--
+	if l.byIP[ip]--; l.byIP[ip] <= 0 {
+		delete(l.byIP, ip)
+	}
+	l.mu.Unlock()
+}
+
+type limitedConn struct {
+	net.Conn
+	l    *Listener
+	ip   string
+	once sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.l.release(c.ip) })
+	return err
+}
+
+// IdentityQuota caps concurrent connections per client-certificate identity.
+// Identities are only known after the handshake, so this runs in the handler.
+type IdentityQuota struct {
+	name string
+	max  int
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// NewIdentityQuota returns a quota of max connections per identity; max <= 0
+// disables it.
+func NewIdentityQuota(name string, max int) *IdentityQuota {
+	return &IdentityQuota{name: name, max: max, active: map[string]int{}}
+}
+
+// Acquire reserves a slot for id. When ok is false the connection should be
+// closed; otherwise release must be called when it ends.
+func (q *IdentityQuota) Acquire(id string) (release func(), ok bool) {
+	if q == nil || q.max <= 0 || id == "" {
+		return func() {}, true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.active[id] >= q.max {
+		connStats.Add(q.name+".identity", 1)
+		return nil, false
+	}
+	q.active[id]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			if q.active[id]--; q.active[id] <= 0 {
+				delete(q.active, id)
+			}
+			q.mu.Unlock()
+		})
+	}, true
+}
@@ -0,0 +1,195 @@
+package limit
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// testConn is a connection from a given address that records Close.
+type testConn struct {
+	net.Conn
+	remote net.Addr
+	closed atomic.Bool
+}
+
+func (c *testConn) RemoteAddr() net.Addr { return c.remote }
+func (c *testConn) Close() error         { c.closed.Store(true); return nil }
+
+func newTestConn(ip string) *testConn {
+	return &testConn{remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}
+}
+
+// testListener returns queued connections, then net.ErrClosed.
+type testListener struct {
+	net.Listener
+	conns chan net.Conn
+}
+
+func newTestListener(conns ...*testConn) *testListener {
+	l := &testListener{conns: make(chan net.Conn, len(conns))}
+	for _, c := range conns {
+		l.conns <- c
+	}
+	close(l.conns)
+	return l
+}
+
+func (l *testListener) Accept() (net.Conn, error) {
+	if c, ok := <-l.conns; ok {
+		return c, nil
+	}
+	return nil, net.ErrClosed
+}
+
+func TestListenerPerIP(t *testing.T) {
+	a1, a2, b, a3 := newTestConn("192.0.2.1"), newTestConn("192.0.2.1"), newTestConn("192.0.2.2"), newTestConn("192.0.2.1")
+	l := NewListener(newTestListener(a1, a2, b, a3), "test_per_ip", ConnLimits{MaxPerIP: 1})
+
+	c, err := l.Accept()
+	if err != nil || c.RemoteAddr() != a1.remote {
+		t.Fatalf("Accept = %v, %v; want the first connection", c, err)
+	}
+	// The second connection from the same IP is closed and skipped.
+	if c2, err := l.Accept(); err != nil || c2.RemoteAddr() != b.remote {
+		t.Fatalf("Accept = %v, %v; want the other IP's connection", c2, err)
+	}
+	if !a2.closed.Load() {
+		t.Error("connection over the per-IP cap was not closed")
+	}
+	if l.Active() != 2 {
+		t.Errorf("Active = %d, want 2", l.Active())
+	}
+
+	// Closing releases the IP's slot, once.
+	_ = c.Close()
+	_ = c.Close()
+	if l.Active() != 1 {
+		t.Errorf("Active after Close = %d, want 1", l.Active())
+	}
+	if c3, err := l.Accept(); err != nil || c3.RemoteAddr() != a3.remote {
+		t.Fatalf("Accept = %v, %v; want the IP's next connection", c3, err)
+	}
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Accept after the queue = %v", err)
+	}
+}
+
+func TestListenerMaxConns(t *testing.T) {
+	l := NewListener(newTestListener(), "test_max", ConnLimits{MaxConns: 2})
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		if r := l.admit(ip); r != "" {
+			t.Fatalf("admit(%s) = %q", ip, r)
+		}
+	}
+	if r := l.admit("192.0.2.3"); r != "max_conns" {
+		t.Errorf("admit past MaxConns = %q, want max_conns", r)
+	}
+	l.release("192.0.2.1")
+	if r := l.admit("192.0.2.3"); r != "" {
+		t.Errorf("admit after release = %q", r)
+	}
+}
+
+func TestListenerRate(t *testing.T) {
+	l := NewListener(newTestListener(), "test_rate", ConnLimits{Rate: 0.001, Burst: 1})
+	if r := l.admit("192.0.2.1"); r != "" {
+		t.Fatalf("admit = %q", r)
+	}
+	if r := l.admit("192.0.2.2"); r != "rate" {
+		t.Errorf("admit past the burst = %q, want rate", r)
+	}
+}
+
+func TestListenerCapsBeforeRate(t *testing.T) {
+	const ip = "192.0.2.1"
+	l := NewListener(newTestListener(), "test_order", ConnLimits{MaxPerIP: 1, PerIPRate: 0.001, PerIPBurst: 2})
+	if r := l.admit(ip); r != "" {
+		t.Fatalf("admit = %q", r)
+	}
+	// Refused for the cap, without using the IP's second token.
+	if r := l.admit(ip); r != "max_per_ip" {
+		t.Fatalf("admit past MaxPerIP = %q, want max_per_ip", r)
+	}
+	l.release(ip)
+	if r := l.admit(ip); r != "" {
+		t.Fatalf("admit after a cap refusal = %q", r)
+	}
+	l.release(ip)
+	if r := l.admit(ip); r != "rate_per_ip" {
+		t.Errorf("admit past the per-IP burst = %q, want rate_per_ip", r)
+	}
+}
+
+func TestListenerAdmit(t *testing.T) {
+	l := NewListener(newTestListener(newTestConn("192.0.2.1")), "test_admit", ConnLimits{MaxConns: 2})
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	quic := &net.UDPAddr{IP: net.ParseIP("192.0.2.3"), Port: 443}
+	release, ok := l.Admit(quic)
+	if !ok {
+		t.Fatal("Admit refused below the cap")
+	}
+	// Accepted and admitted connections count against the same cap.
+	if _, ok := l.Admit(quic); ok {
+		t.Fatal("Admit allowed past MaxConns")
+	}
+	release()
+	release() // a second release is a no-op
+	if l.Active() != 1 {
+		t.Fatalf("Active = %d, want 1", l.Active())
+	}
+	r2, ok := l.Admit(quic)
+	if !ok {
+		t.Fatal("Admit refused after release")
+	}
+	defer r2()
+	if _, ok := l.Admit(quic); ok {
+		t.Error("double release freed two slots")
+	}
+}
+
+func TestIdentityQuota(t *testing.T) {
+	q := NewIdentityQuota("test", 1)
+	release, ok := q.Acquire("alice")
+	if !ok {
+		t.Fatal("first connection refused")
+	}
+	if _, ok := q.Acquire("alice"); ok {
+		t.Fatal("allowed past the quota")
+	}
+	if r, ok := q.Acquire("bob"); !ok {
+		t.Error("other identity refused")
+	} else {
+		r()
+	}
+	for range 3 {
+		if r, ok := q.Acquire(""); !ok {
+			t.Fatal("connection without identity refused")
+		} else {
+			defer r()
+		}
+	}
+	release()
+	release() // a second release is a no-op
+	r, ok := q.Acquire("alice")
+	if !ok {
+		t.Fatal("refused after release")
+	}
+	defer r()
+	if _, ok := q.Acquire("alice"); ok {
+		t.Error("double release freed two slots")
+	}
+
+	for _, q := range []*IdentityQuota{nil, NewIdentityQuota("test", 0)} {
+		for range 3 {
+			if _, ok := q.Acquire("alice"); !ok {
+				t.Fatalf("disabled quota %v refused", q)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package limit
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"fmt"
+	"time"
+
+	"tls-lab/internal/fingerprint"
+	"tls-lab/internal/metrics"
+	"tls-lab/internal/tlsutil"
+)
+
+// Handshake admission errors.
+var (
+	ErrHandshakeRateLimited = errors.New("handshake rate limit exceeded for source")
+	ErrHandshakeOverloaded  = errors.New("too many concurrent handshakes")
+	ErrHandshakeTimeout     = errors.New("handshake timed out")
+)
+
+// HandshakeLimits configures a HandshakeGate. Zero values disable each limit.
+type HandshakeLimits struct {
+	Timeout       time.Duration // per-handshake deadline
+	MaxConcurrent int           // handshakes running at once
+	QueueTimeout  time.Duration // how long to wait for a slot; 0 sheds immediately
+	PerIPRate     float64       // new handshakes per second per source IP
+	PerIPBurst    int
+}
+
+// FailureReason returns the reason code for an error from Handshake: the
+// admission outcome (rate_limited, overloaded, timeout) when the gate cut
+// the handshake short, fingerprint_denied when a fingerprint.Policy
+// rejected the client, otherwise tlsutil.ClassifyHandshake.
+func FailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrHandshakeRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrHandshakeOverloaded):
+		return "overloaded"
+	case errors.Is(err, ErrHandshakeTimeout):
+		return "timeout"
+	case errors.Is(err, fingerprint.ErrDenied):
+		return "fingerprint_denied"
+	}
+	return string(tlsutil.ClassifyHandshake(err))
+}
+
+// handshakeStats counts outcomes as "<gate>.<outcome>" (ok, failed,
+// timeout, rate_limited, overloaded).
+var handshakeStats = expvar.NewMap("tls_handshakes")
+
+// HandshakeGate bounds TLS handshake concurrency and per-source rate, and
+// puts a deadline on every handshake.
+type HandshakeGate struct {
+	name   string
+	limits HandshakeLimits
+	sem    chan struct{}
+	perIP  *KeyedLimiter
+}
+
+// NewHandshakeGate returns a gate whose counters and metrics are published
+// under name.
+func NewHandshakeGate(name string, limits HandshakeLimits) *HandshakeGate {
+	g := &HandshakeGate{
+		name:   name,
+		limits: limits,
+		perIP:  NewKeyedLimiter(limits.PerIPRate, limits.PerIPBurst),
+	}
+	if limits.MaxConcurrent > 0 {
+		g.sem = make(chan struct{}, limits.MaxConcurrent)
+	}
+	return g
+}
+
+func (g *HandshakeGate) count(outcome string) {
+	handshakeStats.Add(g.name+"."+outcome, 1)
+}
+
+// refuse counts a handshake that failed before or instead of running.
+func (g *HandshakeGate) refuse(outcome string) {
+	g.count(outcome)
+	metrics.HandshakeFailed(g.name, outcome)
+}
+
+// Handshake admits and runs the handshake of c. The source key is the remote
+// IP for server-side conns; pass "" to skip per-source limits (e.g. upstream).
+func (g *HandshakeGate) Handshake(c *tls.Conn, source string) error {
+	if source != "" && !g.perIP.Allow(source) {
+		g.refuse("rate_limited")
+		return ErrHandshakeRateLimited
+	}
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		default:
+			if g.limits.QueueTimeout <= 0 {
+				g.refuse("overloaded")
+				return ErrHandshakeOverloaded
+			}
+			t := time.NewTimer(g.limits.QueueTimeout)
+			select {
+			case g.sem <- struct{}{}:
+				t.Stop()
+			case <-t.C:
+				g.refuse("overloaded")
+				return ErrHandshakeOverloaded
+			}
+		}
+		defer func() { <-g.sem }()
+	}
+
+	ctx := context.Background()
+	if g.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.limits.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	if err := c.HandshakeContext(ctx); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			g.refuse("timeout")
+			return fmt.Errorf("%w after %s", ErrHandshakeTimeout, g.limits.Timeout)
+		}
+		g.count("failed")
+		metrics.HandshakeFailed(g.name, FailureReason(err))
+		return err
+	}
+	g.count("ok")
+	metrics.HandshakeOK(g.name, time.Since(start))
+	return nil
+}
@@ -0,0 +1,103 @@
+package limit
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// silentHandshake returns a server-side TLS conn whose client never sends
+// a ClientHello, and a func that hangs the client up.
+func silentHandshake(t *testing.T) (*tls.Conn, func()) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { _ = server.Close(); _ = client.Close() })
+	return tls.Server(server, &tls.Config{}), func() { _ = client.Close() }
+}
+
+func TestHandshakeGateShed(t *testing.T) {
+	g := NewHandshakeGate("test_shed", HandshakeLimits{Timeout: 5 * time.Second, MaxConcurrent: 1})
+	busy, hangUp := silentHandshake(t)
+	done := make(chan error, 1)
+	go func() { done <- g.Handshake(busy, "") }()
+	waitFull(t, g)
+
+	next, _ := silentHandshake(t)
+	if err := g.Handshake(next, ""); !errors.Is(err, ErrHandshakeOverloaded) {
+		t.Errorf("Handshake with no queue = %v, want ErrHandshakeOverloaded", err)
+	}
+	hangUp()
+	if err := <-done; err == nil || errors.Is(err, ErrHandshakeOverloaded) {
+		t.Errorf("first handshake = %v, want a handshake error", err)
+	}
+}
+
+func TestHandshakeGateQueue(t *testing.T) {
+	g := NewHandshakeGate("test_queue", HandshakeLimits{Timeout: 5 * time.Second, MaxConcurrent: 1, QueueTimeout: 50 * time.Millisecond})
+	busy, hangUp := silentHandshake(t)
+	done := make(chan error, 1)
+	go func() { done <- g.Handshake(busy, "") }()
+	waitFull(t, g)
+
+	// Nothing frees the slot within the queue timeout.
+	next, hangUpNext := silentHandshake(t)
+	start := time.Now()
+	if err := g.Handshake(next, ""); !errors.Is(err, ErrHandshakeOverloaded) {
+		t.Errorf("Handshake = %v, want ErrHandshakeOverloaded", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("shed after %s, before the queue timeout", waited)
+	}
+
+	// A slot freed while queued is taken.
+	queued := make(chan error, 1)
+	go func() { queued <- g.Handshake(next, "") }()
+	time.Sleep(10 * time.Millisecond)
+	hangUp()
+	<-done
+	hangUpNext()
+	if err := <-queued; errors.Is(err, ErrHandshakeOverloaded) {
+		t.Error("queued handshake was shed after the slot was freed")
+	}
+}
+
+// waitFull waits until every handshake slot of g is taken.
+func waitFull(t *testing.T, g *HandshakeGate) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); len(g.sem) < cap(g.sem); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("handshake slots not taken")
+		}
+	}
+}
+
+func TestHandshakeGateTimeout(t *testing.T) {
+	g := NewHandshakeGate("test_timeout", HandshakeLimits{Timeout: 50 * time.Millisecond})
+	c, _ := silentHandshake(t)
+	if err := g.Handshake(c, ""); !errors.Is(err, ErrHandshakeTimeout) {
+		t.Errorf("Handshake = %v, want ErrHandshakeTimeout", err)
+	}
+}
+
+func TestHandshakeGateRate(t *testing.T) {
+	g := NewHandshakeGate("test_rate", HandshakeLimits{PerIPRate: 0.001, PerIPBurst: 1})
+	failFast := func() *tls.Conn {
+		c, hangUp := silentHandshake(t)
+		hangUp()
+		return c
+	}
+	if err := g.Handshake(failFast(), "192.0.2.1"); errors.Is(err, ErrHandshakeRateLimited) {
+		t.Fatal("first handshake rate limited")
+	}
+	if err := g.Handshake(failFast(), "192.0.2.1"); !errors.Is(err, ErrHandshakeRateLimited) {
+		t.Fatalf("Handshake = %v, want ErrHandshakeRateLimited", err)
+	}
+	// Other sources, and callers without one, have their own budget.
+	for _, source := range []string{"192.0.2.2", ""} {
+		if err := g.Handshake(failFast(), source); errors.Is(err, ErrHandshakeRateLimited) {
+			t.Errorf("source %q rate limited", source)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package logging
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+
+	"tls-lab/internal/accesslog"
+	"tls-lab/internal/metrics"
+	"tls-lab/internal/tlsutil"
+)
+
+var (
+	rpcDuration = metrics.NewHistogram("tls_lab_grpc_rpc_duration_seconds",
+		"gRPC server RPC latency.", metrics.DurationBuckets, "server", "method")
+	rpcs = metrics.NewCounter("tls_lab_grpc_rpcs_total",
+		"gRPC server RPCs by status code.", "server", "method", "code")
+)
+
+// GRPCConns is a grpc stats.Handler that gives each connection an ID and a
+// logger (see FromContext). Connections are logged at info level, RPCs at
+// debug level with the TLS parameters of their connection, and both feed
+// the metrics. With Access set, every connection also gets an access log
+// entry. Install Creds so handshakes are measured and connections without
+// RPCs still have their TLS parameters.
+type GRPCConns struct {
+	Server string
+	Access *accesslog.Log
+
+	next       atomic.Uint64
+	handshakes sync.Map // remote address -> tls.ConnectionState
+}
+
+// grpcConn is the per-connection state carried in the context.
+type grpcConn struct {
+	acc     *accesslog.Entry
+	metrics *metrics.Conn
+	in, out atomic.Int64
+}
+
+type connKey struct{}
+
+// Creds wraps the server's transport credentials to record each handshake,
+// and to log failed ones to the access log.
+func (g *GRPCConns) Creds(c credentials.TransportCredentials) credentials.TransportCredentials {
+	return &auditCreds{TransportCredentials: c, g: g}
+}
+
+type auditCreds struct {
+	credentials.TransportCredentials
+	g *GRPCConns
+}
+
+func (c *auditCreds) ServerHandshake(raw net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	start := time.Now()
+	conn, info, err := c.TransportCredentials.ServerHandshake(raw)
+	if err != nil {
+		reason := tlsutil.ClassifyHandshake(err)
+		metrics.HandshakeFailed("grpc", string(reason))
+		id := c.g.next.Add(1)
+		slog.Warn("TLS handshake failed", "conn", id, "remote", raw.RemoteAddr().String(), "reason", reason, "err", err)
+		acc := accesslog.NewEntry(c.g.Server, "grpc", id, raw.RemoteAddr(), raw.LocalAddr())
+		acc.CloseReason = "TLS handshake failed: " + err.Error()
+		if werr := c.g.Access.Write(acc); werr != nil {
+			slog.Error("access log write failed", "err", werr)
+		}
+		return conn, info, err
+	}
+	metrics.HandshakeOK("grpc", time.Since(start))
+	if ti, ok := info.(credentials.TLSInfo); ok {
+		c.g.handshakes.Store(raw.RemoteAddr().String(), ti.State)
+	}
+	return conn, info, err
+}
+
+func (c *auditCreds) Clone() credentials.TransportCredentials {
+	return &auditCreds{TransportCredentials: c.TransportCredentials.Clone(), g: c.g}
+}
+
+func (g *GRPCConns) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	id := g.next.Add(1)
+	gc := &grpcConn{acc: accesslog.NewEntry(g.Server, "grpc", id, info.RemoteAddr, info.LocalAddr)}
+	var cs *tls.ConnectionState
+	if v, ok := g.handshakes.LoadAndDelete(info.RemoteAddr.String()); ok {
+		state := v.(tls.ConnectionState)
+		cs = &state
+		gc.acc.SetTLS(cs)
+	}
+	gc.metrics = metrics.ConnOpened(g.Server, "grpc", cs)
+	ctx = context.WithValue(ctx, connKey{}, gc)
+	return NewContext(ctx, slog.With("conn", id, "remote", info.RemoteAddr.String()))
+}
+
+func (g *GRPCConns) HandleConn(ctx context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		FromContext(ctx).Info("new gRPC connection")
+	case *stats.ConnEnd:
+		gc, _ := ctx.Value(connKey{}).(*grpcConn)
+		if gc == nil {
+			return
+		}
+		gc.metrics.Closed()
+		gc.acc.BytesIn, gc.acc.BytesOut = gc.in.Load(), gc.out.Load()
+		gc.acc.CloseReason = "connection closed"
+		if err := g.Access.Write(gc.acc); err != nil {
+			FromContext(ctx).Error("access log write failed", "err", err)
+		}
+		FromContext(ctx).Debug("connection closed", "bytes_in", gc.acc.BytesIn, "bytes_out", gc.acc.BytesOut)
+	}
+}
+
+type rpcKey struct{}
+
+type rpcInfo struct {
+	method string
+	start  time.Time
+}
+
+func (g *GRPCConns) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	ctx = NewContext(ctx, FromContext(ctx).With("method", info.FullMethodName))
+	return context.WithValue(ctx, rpcKey{}, rpcInfo{info.FullMethodName, time.Now()})
+}
+
+func (g *GRPCConns) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	gc, _ := ctx.Value(connKey{}).(*grpcConn)
+	switch s := s.(type) {
+	case *stats.InPayload:
+		if gc != nil {
+			gc.in.Add(int64(s.WireLength))
+			gc.metrics.AddIn(s.WireLength)
+		}
+	case *stats.OutPayload:
+		if gc != nil {
+			gc.out.Add(int64(s.WireLength))
+			gc.metrics.AddOut(s.WireLength)
+		}
+	case *stats.End:
+		lg := FromContext(ctx)
+		if p, ok := peer.FromContext(ctx); ok {
+			if ti, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				lg = lg.With(TLS(&ti.State))
+			}
+		}
+		ri, _ := ctx.Value(rpcKey{}).(rpcInfo)
+		d := time.Since(ri.start)
+		code := status.Code(s.Error).String()
+		rpcDuration.With(g.Server, ri.method).ObserveDuration(d)
+		rpcs.With(g.Server, ri.method, code).Inc()
+		lg.Debug("rpc", "duration", d, "code", code, "err", s.Error)
+	}
+}
@@ -0,0 +1,67 @@
+// Package logging sets up log/slog for the commands: text or JSON output,
+// a level that can be changed while running, per-connection loggers and
+// readable TLS attributes.
+package logging
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup installs the default logger on stderr. format is text or json and
+// level is debug, info, warn or error. The returned LevelVar changes the
+// level at runtime (see admin.Server.HandleLogLevel).
+func Setup(format, level string) (*slog.LevelVar, error) {
+	lv := new(slog.LevelVar)
+	if err := lv.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", level)
+	}
+	opts := &slog.HandlerOptions{Level: lv}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return lv, nil
+}
+
+// Fatal logs msg at error level and exits with status 1.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// TLS returns the negotiated parameters of cs as a "tls" group, with
+// version and cipher suite by name.
+func TLS(cs *tls.ConnectionState) slog.Attr {
+	return slog.Group("tls",
+		"version", tls.VersionName(cs.Version),
+		"cipher", tls.CipherSuiteName(cs.CipherSuite),
+		"alpn", cs.NegotiatedProtocol,
+		"resumed", cs.DidResume,
+	)
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the logger stored by NewContext, or the default.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
@@ -0,0 +1,355 @@
+// Package metrics is a small Prometheus-compatible metrics library:
+// counters, gauges and histograms with labels, registered in one process
+// registry and exposed in the Prometheus text or OpenMetrics format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type kind string
+
+const (
+	counterKind   kind = "counter"
+	gaugeKind     kind = "gauge"
+	histogramKind kind = "histogram"
+)
+
+// family is one metric name with its labelled series.
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64 // histograms only
+
+	mu     sync.Mutex
+	series map[string]*series // by joined label values
+}
+
+type series struct {
+	values []string
+	val    atomic.Uint64 // float64 bits: counter or gauge value, histogram sum
+
+	// histograms only
+	counts []atomic.Uint64 // per bucket, not cumulative
+	count  atomic.Uint64
+}
+
+var (
+	regMu    sync.Mutex
+	families = map[string]*family{}
+)
+
+func register(name, help string, k kind, buckets []float64, labels []string) *family {
+	regMu.Lock()
+	defer regMu.Unlock()
+	if _, dup := families[name]; dup {
+		panic("metrics: duplicate metric " + name)
+	}
+	f := &family{name: name, help: help, kind: k, labels: labels, buckets: buckets, series: map[string]*series{}}
+	families[name] = f
+	return f
+}
+
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == histogramKind {
+			s.counts = make([]atomic.Uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (s *series) add(v float64) {
+	for {
+		old := s.val.Load()
+		if s.val.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (s *series) load() float64 { return math.Float64frombits(s.val.Load()) }
+
+// CounterVec is a family of counters. Counter names should end in _total.
+type CounterVec struct{ f *family }
+
+// NewCounter registers a counter family with the given label names.
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{register(name, help, counterKind, nil, labels)}
+}
+
+// With returns the counter for the label values, in label order.
+func (c *CounterVec) With(values ...string) Counter { return Counter{c.f.with(values)} }
+
+// Counter only goes up.
+type Counter struct{ s *series }
+
+func (c Counter) Inc()          { c.s.add(1) }
+func (c Counter) Add(v float64) { c.s.add(v) }
+
+// GaugeVec is a family of gauges.
+type GaugeVec struct{ f *family }
+
+// NewGauge registers a gauge family with the given label names.
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{register(name, help, gaugeKind, nil, labels)}
+}
+
+// With returns the gauge for the label values, in label order.
+func (g *GaugeVec) With(values ...string) Gauge { return Gauge{g.f.with(values)} }
+
+// Gauge goes up and down.
+type Gauge struct{ s *series }
+
+func (g Gauge) Set(v float64) { g.s.val.Store(math.Float64bits(v)) }
+func (g Gauge) Add(v float64) { g.s.add(v) }
+func (g Gauge) Inc()          { g.s.add(1) }
+func (g Gauge) Dec()          { g.s.add(-1) }
+
+// HistogramVec is a family of histograms.
+type HistogramVec struct{ f *family }
+
+// DurationBuckets suit latencies from sub-millisecond to seconds.
+var DurationBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogram registers a histogram family. buckets are upper bounds in
+// increasing order; +Inf is implied.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{register(name, help, histogramKind, buckets, labels)}
+}
+
+// With returns the histogram for the label values, in label order.
+func (h *HistogramVec) With(values ...string) Histogram {
+	return Histogram{h.f.with(values), h.f.buckets}
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+func (h Histogram) Observe(v float64) {
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.s.counts[i].Add(1)
+	}
+	h.s.count.Add(1)
+	h.s.add(v)
+}
+
+// ObserveDuration observes d in seconds.
+func (h Histogram) ObserveDuration(d time.Duration) { h.Observe(d.Seconds()) }
+
+const (
+	textType        = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// Handler serves all registered metrics, in OpenMetrics format when the
+// client asks for it and in the Prometheus text format otherwise.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		om := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+		if om {
+			w.Header().Set("Content-Type", openMetricsType)
+		} else {
+			w.Header().Set("Content-Type", textType)
+		}
+		bw := bufio.NewWriter(w)
+		write(bw, om)
+		_ = bw.Flush()
+	})
+}
+
+// Family is a snapshot of one metric family, as returned by Gather.
+type Family struct {
+	Name    string
+	Kind    string // counter, gauge or histogram
+	Labels  []string
+	Buckets []float64 // histograms only
+	Series  []Series
+}
+
+// Series is a snapshot of one labelled series.
+type Series struct {
+	Values []string // label values, in Family.Labels order
+	Value  float64  // counter or gauge value, histogram sum
+	// Histograms only: observations, and cumulative counts per bucket.
+	Count      uint64
+	Cumulative []uint64
+}
+
+// Label returns the value of label name, or "" if the family has none.
+func (f *Family) Label(s Series, name string) string {
+	for i, l := range f.Labels {
+		if l == name {
+			return s.Values[i]
+		}
+	}
+	return ""
+}
+
+// Gather returns a snapshot of the family called name, for in-process
+// readers such as the admin dashboard; nil if there is no such family.
+func Gather(name string) *Family {
+	regMu.Lock()
+	f, ok := families[name]
+	regMu.Unlock()
+	if !ok {
+		return nil
+	}
+	out := &Family{Name: f.name, Kind: string(f.kind), Labels: f.labels, Buckets: f.buckets}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.series {
+		snap := Series{Values: s.values, Value: s.load()}
+		if f.kind == histogramKind {
+			snap.Count = s.count.Load()
+			snap.Cumulative = make([]uint64, len(f.buckets))
+			var cum uint64
+			for i := range f.buckets {
+				cum += s.counts[i].Load()
+				snap.Cumulative[i] = cum
+			}
+		}
+		out.Series = append(out.Series, snap)
+	}
+	sort.Slice(out.Series, func(i, j int) bool {
+		return strings.Join(out.Series[i].Values, "\xff") < strings.Join(out.Series[j].Values, "\xff")
+	})
+	return out
+}
+
+// Quantile estimates the q-quantile (0 < q < 1) of histogram series s by
+// linear interpolation within buckets, like PromQL histogram_quantile.
+// It returns 0 without observations and the largest finite bound when the
+// quantile falls in the +Inf bucket.
+func (f *Family) Quantile(s Series, q float64) float64 {
+	if s.Count == 0 || len(f.Buckets) == 0 {
+		return 0
+	}
+	rank := q * float64(s.Count)
+	lower, below := 0.0, uint64(0)
+	for i, upper := range f.Buckets {
+		if float64(s.Cumulative[i]) >= rank {
+			in := s.Cumulative[i] - below
+			if in == 0 {
+				return upper
+			}
+			return lower + (upper-lower)*(rank-float64(below))/float64(in)
+		}
+		lower, below = upper, s.Cumulative[i]
+	}
+	return f.Buckets[len(f.Buckets)-1]
+}
+
+func write(w *bufio.Writer, openMetrics bool) {
+	regMu.Lock()
+	fams := make([]*family, 0, len(families))
+	for _, f := range families {
+		fams = append(fams, f)
+	}
+	regMu.Unlock()
+	sort.Slice(fams, func(i, j int) bool { return fams[i].name < fams[j].name })
+
+	for _, f := range fams {
+		f.mu.Lock()
+		ss := make([]*series, 0, len(f.series))
+		for _, s := range f.series {
+			ss = append(ss, s)
+		}
+		f.mu.Unlock()
+		if len(ss) == 0 {
+			continue
+		}
+		sort.Slice(ss, func(i, j int) bool {
+			return strings.Join(ss[i].values, "\xff") < strings.Join(ss[j].values, "\xff")
+		})
+
+		name := f.name
+		if openMetrics && f.kind == counterKind {
+			name = strings.TrimSuffix(name, "_total")
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(f.help), name, f.kind)
+		for _, s := range ss {
+			if f.kind != histogramKind {
+				fmt.Fprintf(w, "%s%s %s\n", f.name, labelSet(f.labels, s.values, "", ""), formatFloat(s.load()))
+				continue
+			}
+			var cum uint64
+			for i, b := range f.buckets {
+				cum += s.counts[i].Load()
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelSet(f.labels, s.values, "le", formatFloat(b)), cum)
+			}
+			count := s.count.Load()
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelSet(f.labels, s.values, "le", "+Inf"), count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelSet(f.labels, s.values, "", ""), formatFloat(s.load()))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelSet(f.labels, s.values, "", ""), count)
+		}
+	}
+	if openMetrics {
+		w.WriteString("# EOF\n")
+	}
+}
+
+func labelSet(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", n, escapeLabel(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The registry is process-wide, so the families are registered once and the
+// golden outputs below hold for every test in this package. Families
+// without series (the shared ones in tls.go, test_unused) are not written.
+var (
+	testRequests = NewCounter("test_requests_total", "Requests \\ handled.\nSecond line.", "code", "path")
+	testTemp     = NewGauge("test_temperature", "Temperature.")
+	testLatency  = NewHistogram("test_latency_seconds", "Latency.", []float64{0.125, 1, 8}, "op")
+	_            = NewCounter("test_unused_total", "Never used.")
+)
+
+func init() {
+	testRequests.With("500", "/").Inc()
+	testRequests.With("200", "a\"b\\c\n").Add(3)
+	testTemp.With().Set(-1.5)
+	h := testLatency.With("read")
+	for _, v := range []float64{0.0625, 0.125, 0.5, 16} {
+		h.Observe(v)
+	}
+}
+
+const goldenBody = `# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{op="read",le="0.125"} 2
+test_latency_seconds_bucket{op="read",le="1"} 3
+test_latency_seconds_bucket{op="read",le="8"} 3
+test_latency_seconds_bucket{op="read",le="+Inf"} 4
+test_latency_seconds_sum{op="read"} 16.6875
+test_latency_seconds_count{op="read"} 4
+# HELP %s Requests \\ handled.\nSecond line.
+# TYPE %s counter
+test_requests_total{code="200",path="a\"b\\c\n"} 3
+test_requests_total{code="500",path="/"} 1
+# HELP test_temperature Temperature.
+# TYPE test_temperature gauge
+test_temperature -1.5
+`
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name        string
+		openMetrics bool
+		want        string
+	}{
+		{"text", false, strings.ReplaceAll(goldenBody, "%s", "test_requests_total")},
+		// OpenMetrics names the counter family without _total, but not its
+		// samples, and ends with # EOF.
+		{"openmetrics", true, strings.ReplaceAll(goldenBody, "%s", "test_requests") + "# EOF\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			w := bufio.NewWriter(&b)
+			write(w, tt.openMetrics)
+			_ = w.Flush()
+			if b.String() != tt.want {
+				t.Errorf("output:\n%s\nwant:\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		accept, contentType, tail string
+	}{
+		{"", textType, "test_temperature -1.5\n"},
+		{"text/plain", textType, "test_temperature -1.5\n"},
+		{"application/openmetrics-text; version=1.0.0,text/plain;q=0.5", openMetricsType, "# EOF\n"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, req)
+		if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("Accept %q: Content-Type = %q, want %q", tt.accept, ct, tt.contentType)
+		}
+		if !strings.HasSuffix(rec.Body.String(), tt.tail) {
+			t.Errorf("Accept %q: body ends with %q, want %q", tt.accept, lastLine(rec.Body.String()), tt.tail)
+		}
+	}
+}
+
+func TestLabelSet(t *testing.T) {
+	tests := []struct {
+		names, values     []string
+		extraName, extraV string
+		want              string
+	}{
+		{nil, nil, "", "", ""},
+		{nil, nil, "le", "+Inf", `{le="+Inf"}`},
+		{[]string{"a"}, []string{"x"}, "", "", `{a="x"}`},
+		{[]string{"a", "b"}, []string{"x", "y"}, "le", "0.5", `{a="x",b="y",le="0.5"}`},
+		{[]string{"a"}, []string{"back\\slash \"quote\"\nnewline"}, "", "", `{a="back\\slash \"quote\"\nnewline"}`},
+		{[]string{"a"}, []string{""}, "", "", `{a=""}`},
+	}
+	for _, tt := range tests {
+		if got := labelSet(tt.names, tt.values, tt.extraName, tt.extraV); got != tt.want {
+			t.Errorf("labelSet(%q, %q, %q, %q) = %s, want %s", tt.names, tt.values, tt.extraName, tt.extraV, got, tt.want)
+		}
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{-1.5, "-1.5"},
+		{0.1, "0.1"},
+		{0.0005, "0.0005"},
+		{1e21, "1e+21"},
+		{123456789, "1.23456789e+08"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.v); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestGather(t *testing.T) {
+	f := Gather("test_latency_seconds")
+	if f == nil || len(f.Series) != 1 {
+		t.Fatalf("Gather = %+v", f)
+	}
+	s := f.Series[0]
+	if f.Label(s, "op") != "read" || s.Count != 4 || s.Value != 16.6875 {
+		t.Errorf("series = %+v", s)
+	}
+	if want := []uint64{2, 3, 3}; len(s.Cumulative) != 3 || s.Cumulative[0] != want[0] || s.Cumulative[1] != want[1] || s.Cumulative[2] != want[2] {
+		t.Errorf("cumulative = %v, want %v", s.Cumulative, want)
+	}
+	// Half the observations are at or below 0.125; the 99th percentile
+	// falls in +Inf and reports the largest finite bound.
+	if q := f.Quantile(s, 0.5); q != 0.125 {
+		t.Errorf("p50 = %v, want 0.125", q)
+	}
+	if q := f.Quantile(s, 0.99); q != 8 {
+		t.Errorf("p99 = %v, want 8", q)
+	}
+	if Gather("test_missing") != nil {
+		t.Error("Gather of an unknown family is not nil")
+	}
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return s[strings.LastIndexByte(s, '\n')+1:]
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"crypto/tls"
+	"strconv"
+	"time"
+)
+
+// Connection and TLS families shared by the servers. server is the binary
+// (echo-server, tunnel-server, ...), transport is tcp, quic or grpc, and
+// gate is a limit.HandshakeGate name or "grpc".
+var (
+	connections = NewCounter("tls_lab_connections_total",
+		"Connections established (after the TLS handshake).", "server", "transport")
+	connectionsActive = NewGauge("tls_lab_connections_active",
+		"Connections currently open.", "server", "transport")
+	connectionDuration = NewHistogram("tls_lab_connection_duration_seconds",
+		"Lifetime of closed connections.", []float64{.01, .1, 1, 10, 60, 300, 1800, 3600}, "server", "transport")
+	bytesTotal = NewCounter("tls_lab_bytes_total",
+		"Application bytes transferred, by direction (in = from the client).", "server", "transport", "direction")
+
+	handshakeDuration = NewHistogram("tls_lab_handshake_duration_seconds",
+		"TLS handshake latency, successful handshakes only.", DurationBuckets, "gate")
+	handshakeFailures = NewCounter("tls_lab_handshake_failures_total",
+		"Failed or refused TLS handshakes by reason.", "gate", "reason")
+	sessions = NewCounter("tls_lab_tls_sessions_total",
+		"Established TLS sessions by version, cipher suite, key exchange group and resumption.",
+		"server", "version", "cipher", "group", "resumed")
+	clientHellos = NewCounter("tls_lab_client_hellos_total",
+		"ClientHellos by fingerprint class (listed, other, unparsed) and fingerprint filter action (allowed, denied).",
+		"listener", "fingerprint", "action")
+)
+
+// Conn tracks one established connection. Its methods are safe for
+// concurrent use.
+type Conn struct {
+	start    time.Time
+	active   Gauge
+	duration Histogram
+	in, out  Counter
+}
+
+// ConnOpened counts a new connection; cs may be nil for plaintext ones.
+// Call Closed on the result when the connection ends.
+func ConnOpened(server, transport string, cs *tls.ConnectionState) *Conn {
+	connections.With(server, transport).Inc()
+	c := &Conn{
+		start:    time.Now(),
+		active:   connectionsActive.With(server, transport),
+		duration: connectionDuration.With(server, transport),
+		in:       bytesTotal.With(server, transport, "in"),
+		out:      bytesTotal.With(server, transport, "out"),
+	}
+	c.active.Inc()
+	if cs != nil {
+		ObserveSession(server, cs)
+	}
+	return c
+}
+
+// AddIn and AddOut count application bytes.
+func (c *Conn) AddIn(n int)  { c.in.Add(float64(n)) }
+func (c *Conn) AddOut(n int) { c.out.Add(float64(n)) }
+
+// Closed records the connection's end.
+func (c *Conn) Closed() {
+	c.active.Dec()
+	c.duration.ObserveDuration(time.Since(c.start))
+}
+
+// ObserveSession counts an established TLS session.
+func ObserveSession(server string, cs *tls.ConnectionState) {
+	sessions.With(server,
+		tls.VersionName(cs.Version),
+		tls.CipherSuiteName(cs.CipherSuite),
+		groupName(cs.CurveID),
+		strconv.FormatBool(cs.DidResume),
+	).Inc()
+}
+
+func groupName(id tls.CurveID) string {
+	if id == 0 {
+		return "none" // resumed TLS 1.2 sessions and RSA key exchange
+	}
+	return id.String()
+}
+
+// HandshakeOK records a successful handshake that took d.
+func HandshakeOK(gate string, d time.Duration) {
+	handshakeDuration.With(gate).ObserveDuration(d)
+}
+
+// HandshakeFailed records a failed or refused handshake; reason is a
+// tlsutil.HandshakeReason or a limit gate outcome.
+func HandshakeFailed(gate, reason string) {
+	handshakeFailures.With(gate, reason).Inc()
+}
+
+// ClientHello counts a fingerprinted ClientHello on listener.
+func ClientHello(listener, class, action string) {
+	clientHellos.With(listener, class, action).Inc()
+}
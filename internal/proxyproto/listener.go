@@ -0,0 +1,170 @@
+package proxyproto
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseCIDRs parses a comma-separated list of CIDRs or bare IPs.
+func ParseCIDRs(s string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", p)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", p, err)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// Listener accepts an optional PROXY header on connections from trusted
+// sources. Headers from other sources are not interpreted, so they reach
+// the application as data and fail its protocol. The header is read on the
+// first Read or RemoteAddr call, never in Accept, so a slow peer cannot
+// stall the accept loop.
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+// NewListener wraps inner. timeout bounds how long reading the header may
+// take; 0 means no limit.
+func NewListener(inner net.Listener, trusted []*net.IPNet, timeout time.Duration) *Listener {
+	return &Listener{Listener: inner, trusted: trusted, timeout: timeout}
+}
+
+// Accept wraps the next connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	return &Conn{Conn: c, timeout: l.timeout}, nil
+}
+
+func (l *Listener) isTrusted(a net.Addr) bool {
+	ta, ok := a.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(ta.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted proxy. RemoteAddr and LocalAddr
+// report the addresses from the header when one was sent.
+type Conn struct {
+	net.Conn
+	timeout time.Duration
+
+	once sync.Once
+	br   *bufio.Reader
+	hdr  *Header
+	err  error
+}
+
+func (c *Conn) readHeader() {
+	if c.timeout > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	c.br = bufio.NewReader(c.Conn)
+	c.hdr, c.err = Read(c.br)
+}
+
+// Header returns the PROXY header, or nil if the peer sent none.
+func (c *Conn) Header() *Header {
+	c.once.Do(c.readHeader)
+	return c.hdr
+}
+
+// Read returns application data following the header.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.br != nil && c.br.Buffered() > 0 {
+		return c.br.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+// RemoteAddr returns the client address from the header, if any.
+func (c *Conn) RemoteAddr() net.Addr {
+	if h := c.Header(); h != nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address from the header, if any.
+func (c *Conn) LocalAddr() net.Addr {
+	if h := c.Header(); h != nil && h.Dest != nil {
+		return h.Dest
+	}
+	return c.Conn.LocalAddr()
+}
+
+// PeerAddr returns the address of c's TCP peer (the proxy, for a
+// connection with a PROXY header), looking under wrappers such as
+// *tls.Conn.
+func PeerAddr(c net.Conn) net.Addr {
+	if pc := unwrap(c); pc != nil {
+		return pc.Conn.RemoteAddr()
+	}
+	return c.RemoteAddr()
+}
+
+// HeaderOf returns the PROXY header of c (or of the connection under
+// wrappers such as *tls.Conn), or nil.
+func HeaderOf(c net.Conn) *Header {
+	if pc := unwrap(c); pc != nil {
+		return pc.Header()
+	}
+	return nil
+}
+
+// unwrap finds the *Conn under c, following NetConn methods (*tls.Conn,
+// fingerprint.Conn).
+func unwrap(c net.Conn) *Conn {
+	for {
+		if pc, ok := c.(*Conn); ok {
+			return pc
+		}
+		u, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		c = u.NetConn()
+	}
+}
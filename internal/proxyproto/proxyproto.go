@@ -0,0 +1,343 @@
+// Package proxyproto reads and writes HAProxy PROXY protocol headers (v1
+// text and v2 binary) so servers behind tunnel-server or an L4 load
+// balancer can log the real client address and downstream TLS details.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	v1Prefix  = []byte("PROXY ")
+	signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// v1MaxLen is the longest valid v1 header, CRLF included.
+const v1MaxLen = 107
+
+// TLV types from the v2 spec.
+const (
+	TypeALPN      = 0x01
+	TypeAuthority = 0x02 // SNI host name
+	TypeSSL       = 0x20
+
+	SubtypeSSLVersion = 0x21
+	SubtypeSSLCN      = 0x22
+	SubtypeSSLCipher  = 0x23
+)
+
+// Client flags in the TypeSSL TLV.
+const (
+	clientSSL      = 0x01
+	clientCertConn = 0x02
+)
+
+// ErrInvalidHeader is wrapped by all header parse errors.
+var ErrInvalidHeader = errors.New("proxy protocol: invalid header")
+
+// Version selects the header format written by Header.Format.
+type Version int
+
+// Header versions; Off means no header is sent.
+const (
+	Off Version = 0
+	V1  Version = 1
+	V2  Version = 2
+)
+
+// ParseVersion parses a -proxy-protocol-out flag value.
+func ParseVersion(s string) (Version, error) {
+	switch strings.ToLower(s) {
+	case "", "off":
+		return Off, nil
+	case "v1", "1":
+		return V1, nil
+	case "v2", "2":
+		return V2, nil
+	}
+	return Off, fmt.Errorf("unknown proxy protocol version %q (want off, v1 or v2)", s)
+}
+
+// TLV is a v2 type-length-value extension.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header is a decoded PROXY header.
+type Header struct {
+	Version Version
+	// Local is set for v2 LOCAL and v1 UNKNOWN headers (e.g. proxy health
+	// checks); Source and Dest are nil then.
+	Local        bool
+	Source, Dest *net.TCPAddr
+	TLVs         []TLV // v2 only
+}
+
+// SSLInfo is the content of a TypeSSL TLV.
+type SSLInfo struct {
+	ClientCert bool // the client presented a certificate
+	Verified   bool // ... and it was verified
+	Version    string
+	CN         string
+	Cipher     string
+}
+
+// TLV returns the value of the first TLV of type t.
+func (h *Header) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Authority returns the SNI host name carried by the header, if any.
+func (h *Header) Authority() string {
+	v, _ := h.TLV(TypeAuthority)
+	return string(v)
+}
+
+// SSL decodes the TypeSSL TLV.
+func (h *Header) SSL() (SSLInfo, bool) {
+	v, ok := h.TLV(TypeSSL)
+	if !ok || len(v) < 5 || v[0]&clientSSL == 0 {
+		return SSLInfo{}, false
+	}
+	info := SSLInfo{
+		ClientCert: v[0]&clientCertConn != 0,
+		Verified:   binary.BigEndian.Uint32(v[1:5]) == 0,
+	}
+	subs, err := parseTLVs(v[5:])
+	if err != nil {
+		return SSLInfo{}, false
+	}
+	for _, s := range subs {
+		switch s.Type {
+		case SubtypeSSLVersion:
+			info.Version = string(s.Value)
+		case SubtypeSSLCN:
+			info.CN = string(s.Value)
+		case SubtypeSSLCipher:
+			info.Cipher = string(s.Value)
+		}
+	}
+	info.Verified = info.Verified && info.ClientCert
+	return info, true
+}
+
+// String summarises the header for logs.
+func (h *Header) String() string {
+	if h.Local {
+		return fmt.Sprintf("PROXY v%d LOCAL", h.Version)
+	}
+	s := fmt.Sprintf("PROXY v%d %s -> %s", h.Version, h.Source, h.Dest)
+	if sni := h.Authority(); sni != "" {
+		s += " sni=" + sni
+	}
+	if ssl, ok := h.SSL(); ok {
+		s += fmt.Sprintf(" tls=%q cipher=%s", ssl.Version, ssl.Cipher)
+		if ssl.CN != "" {
+			s += fmt.Sprintf(" cn=%q verified=%v", ssl.CN, ssl.Verified)
+		}
+	}
+	return s
+}
+
+// Format encodes h as a v1 or v2 header. v1 cannot carry TLVs; they are
+// dropped.
+func (h *Header) Format() ([]byte, error) {
+	switch h.Version {
+	case V1:
+		return h.formatV1()
+	case V2:
+		return h.formatV2()
+	}
+	return nil, fmt.Errorf("proxy protocol: cannot format version %d", h.Version)
+}
+
+func (h *Header) formatV1() ([]byte, error) {
+	if h.Local || h.Source == nil || h.Dest == nil {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+	family := "TCP4"
+	if h.Source.IP.To4() == nil {
+		family = "TCP6"
+	}
+	if (h.Dest.IP.To4() == nil) != (family == "TCP6") {
+		return nil, fmt.Errorf("proxy protocol: mixed address families %s -> %s", h.Source, h.Dest)
+	}
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, h.Source.IP, h.Dest.IP, h.Source.Port, h.Dest.Port), nil
+}
+
+func (h *Header) formatV2() ([]byte, error) {
+	buf := append([]byte{}, signature...)
+	var addrs []byte
+	switch {
+	case h.Local || h.Source == nil || h.Dest == nil:
+		buf = append(buf, 0x20, 0x00)
+	case h.Source.IP.To4() != nil && h.Dest.IP.To4() != nil:
+		buf = append(buf, 0x21, 0x11)
+		addrs = append(addrs, h.Source.IP.To4()...)
+		addrs = append(addrs, h.Dest.IP.To4()...)
+	case h.Source.IP.To4() == nil && h.Dest.IP.To4() == nil:
+		buf = append(buf, 0x21, 0x21)
+		addrs = append(addrs, h.Source.IP.To16()...)
+		addrs = append(addrs, h.Dest.IP.To16()...)
+	default:
+		return nil, fmt.Errorf("proxy protocol: mixed address families %s -> %s", h.Source, h.Dest)
+	}
+	if addrs != nil {
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(h.Source.Port))
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(h.Dest.Port))
+	}
+	for _, tlv := range h.TLVs {
+		addrs = appendTLV(addrs, tlv)
+	}
+	if len(addrs) > 0xffff {
+		return nil, fmt.Errorf("proxy protocol: header too long (%d bytes)", len(addrs))
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(addrs)))
+	return append(buf, addrs...), nil
+}
+
+func appendTLV(b []byte, tlv TLV) []byte {
+	b = append(b, tlv.Type)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(tlv.Value)))
+	return append(b, tlv.Value...)
+}
+
+// Read consumes a PROXY header from r. It returns (nil, nil) when the stream
+// does not start with one; nothing is consumed then. r must be at least 108
+// bytes big.
+func Read(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case v1Prefix[0]:
+		if b, err := r.Peek(len(v1Prefix)); err != nil || !bytes.Equal(b, v1Prefix) {
+			return nil, nil
+		}
+		return readV1(r)
+	case signature[0]:
+		if b, err := r.Peek(len(signature)); err != nil || !bytes.Equal(b, signature) {
+			return nil, nil
+		}
+		return readV2(r)
+	}
+	return nil, nil
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	b, err := r.ReadSlice('\n')
+	if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	if err != nil || len(b) > v1MaxLen || !bytes.HasSuffix(b, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 line not terminated by CRLF within %d bytes", ErrInvalidHeader, v1MaxLen)
+	}
+	line := string(b[:len(b)-2])
+
+	f := strings.Split(line, " ")
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return &Header{Version: V1, Local: true}, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, line)
+	}
+	src, err1 := v1Addr(f[2], f[4], f[1])
+	dst, err2 := v1Addr(f[3], f[5], f[1])
+	if err := errors.Join(err1, err2); err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidHeader, line, err)
+	}
+	return &Header{Version: V1, Source: src, Dest: dst}, nil
+}
+
+func v1Addr(ip, port, family string) (*net.TCPAddr, error) {
+	a := net.ParseIP(ip)
+	if a == nil || (a.To4() != nil) != (family == "TCP4") {
+		return nil, fmt.Errorf("bad %s address %q", family, ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port %q", port)
+	}
+	return &net.TCPAddr{IP: a, Port: int(p)}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	verCmd, fam := fixed[12], fixed[13]
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidHeader, verCmd>>4)
+	}
+	h := &Header{Version: V2}
+	switch verCmd & 0x0f {
+	case 0x0:
+		// LOCAL: the addresses and TLVs are to be ignored.
+		h.Local = true
+		return h, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("%w: command %#x", ErrInvalidHeader, verCmd&0x0f)
+	}
+
+	var alen int
+	switch fam {
+	case 0x11: // TCP over IPv4
+		alen = 12
+	case 0x21: // TCP over IPv6
+		alen = 36
+	case 0x00: // UNSPEC: keep the connection's own addresses
+		h.Local = true
+		return h, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported address family %#x", ErrInvalidHeader, fam)
+	}
+	if len(body) < alen {
+		return nil, fmt.Errorf("%w: %d address bytes, want %d", ErrInvalidHeader, len(body), alen)
+	}
+	n := (alen - 4) / 2 // IP length
+	h.Source = &net.TCPAddr{IP: net.IP(body[:n]), Port: int(binary.BigEndian.Uint16(body[2*n:]))}
+	h.Dest = &net.TCPAddr{IP: net.IP(body[n : 2*n]), Port: int(binary.BigEndian.Uint16(body[2*n+2:]))}
+	tlvs, err := parseTLVs(body[alen:])
+	if err != nil {
+		return nil, err
+	}
+	h.TLVs = tlvs
+	return h, nil
+}
+
+func parseTLVs(b []byte) ([]TLV, error) {
+	var out []TLV
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrInvalidHeader)
+		}
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return nil, fmt.Errorf("%w: TLV %#x overruns header", ErrInvalidHeader, b[0])
+		}
+		out = append(out, TLV{Type: b[0], Value: b[3 : 3+n]})
+		b = b[3+n:]
+	}
+	return out, nil
+}
@@ -0,0 +1,338 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tcpAddr(s string) *net.TCPAddr {
+	a, err := net.ResolveTCPAddr("tcp", s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func TestReadV1(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		src, dst string // empty for a LOCAL header
+		err      bool
+	}{
+		{name: "tcp4", in: "PROXY TCP4 10.1.2.3 10.0.0.5 51514 8080\r\n", src: "10.1.2.3:51514", dst: "10.0.0.5:8080"},
+		{name: "tcp6", in: "PROXY TCP6 2001:db8::1 2001:db8::2 1 65535\r\n", src: "[2001:db8::1]:1", dst: "[2001:db8::2]:65535"},
+		{name: "unknown", in: "PROXY UNKNOWN\r\n"},
+		{name: "unknown with addresses", in: "PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\n"},
+		{name: "longest", in: "PROXY TCP6 ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n", src: "[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535", dst: "[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535"},
+		{name: "lf only", in: "PROXY TCP4 10.1.2.3 10.0.0.5 51514 8080\n", err: true},
+		{name: "no terminator", in: "PROXY TCP4 10.1.2.3 10.0.0.5 51514 8080", err: true},
+		{name: "too long", in: "PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n", err: true},
+		{name: "bad family", in: "PROXY UDP4 10.1.2.3 10.0.0.5 51514 8080\r\n", err: true},
+		{name: "family mismatch", in: "PROXY TCP4 2001:db8::1 10.0.0.5 51514 8080\r\n", err: true},
+		{name: "bad port", in: "PROXY TCP4 10.1.2.3 10.0.0.5 65536 8080\r\n", err: true},
+		{name: "missing field", in: "PROXY TCP4 10.1.2.3 10.0.0.5 51514\r\n", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := Read(bufio.NewReader(strings.NewReader(tt.in + "data")))
+			if tt.err {
+				if !errors.Is(err, ErrInvalidHeader) {
+					t.Fatalf("Read = %v, %v; want ErrInvalidHeader", h, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Version != V1 {
+				t.Errorf("version = %d", h.Version)
+			}
+			if tt.src == "" {
+				if !h.Local || h.Source != nil {
+					t.Errorf("header = %+v, want LOCAL", h)
+				}
+				return
+			}
+			if h.Local || h.Source.String() != tt.src || h.Dest.String() != tt.dst {
+				t.Errorf("header = %v, want %s -> %s", h, tt.src, tt.dst)
+			}
+		})
+	}
+}
+
+func TestReadV2(t *testing.T) {
+	sig := "0d0a0d0a000d0a515549540a"
+	tests := []struct {
+		name     string
+		hex      string
+		src, dst string
+		local    bool
+		tlvs     int
+		err      bool
+	}{
+		{
+			name: "tcp4",
+			hex:  sig + "2111000c" + "0a010203" + "0a000005" + "c93a" + "1f90",
+			src:  "10.1.2.3:51514", dst: "10.0.0.5:8080",
+		},
+		{
+			name: "tcp4 with authority tlv",
+			hex:  sig + "2111001a" + "0a010203" + "0a000005" + "c93a" + "1f90" + "02000b" + hex.EncodeToString([]byte("example.org")),
+			src:  "10.1.2.3:51514", dst: "10.0.0.5:8080", tlvs: 1,
+		},
+		{
+			name: "tcp6",
+			hex:  sig + "21210024" + "20010db8000000000000000000000001" + "20010db8000000000000000000000002" + "0001" + "ffff",
+			src:  "[2001:db8::1]:1", dst: "[2001:db8::2]:65535",
+		},
+		{name: "local", hex: sig + "20000000", local: true},
+		{name: "local ignores body", hex: sig + "20110003" + "aabbcc", local: true},
+		{name: "unspec", hex: sig + "21000000", local: true},
+		{name: "version 1", hex: sig + "11110000", err: true},
+		{name: "bad command", hex: sig + "22110000", err: true},
+		{name: "udp", hex: sig + "2112000c" + "0a010203" + "0a000005" + "c93a" + "1f90", err: true},
+		{name: "short addresses", hex: sig + "21110004" + "0a010203", err: true},
+		{name: "truncated body", hex: sig + "2111000c" + "0a010203", err: true},
+		{name: "truncated tlv", hex: sig + "2111000e" + "0a010203" + "0a000005" + "c93a" + "1f90" + "0200", err: true},
+		{name: "tlv overrun", hex: sig + "2111000f" + "0a010203" + "0a000005" + "c93a" + "1f90" + "020005", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := hex.DecodeString(tt.hex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := bufio.NewReader(bytes.NewReader(append(b, "data"...)))
+			h, err := Read(r)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidHeader) {
+					t.Fatalf("Read = %v, %v; want ErrInvalidHeader", h, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "data" {
+				t.Errorf("data after header = %q", rest)
+			}
+			if h.Version != V2 || h.Local != tt.local {
+				t.Fatalf("header = %+v", h)
+			}
+			if tt.local {
+				return
+			}
+			if h.Source.String() != tt.src || h.Dest.String() != tt.dst || len(h.TLVs) != tt.tlvs {
+				t.Errorf("header = %v with %d TLVs, want %s -> %s with %d", h, len(h.TLVs), tt.src, tt.dst, tt.tlvs)
+			}
+		})
+	}
+}
+
+func TestReadNoHeader(t *testing.T) {
+	for _, in := range []string{"GET / HTTP/1.1\r\n", "PROX", "PROTOCOL\r\n", "\r\n\r\nnot a signature", "\x16\x03\x01"} {
+		r := bufio.NewReader(strings.NewReader(in))
+		h, err := Read(r)
+		if h != nil || err != nil {
+			t.Errorf("Read(%q) = %v, %v; want nil, nil", in, h, err)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != in {
+			t.Errorf("Read(%q) consumed input, %q left", in, rest)
+		}
+	}
+	if _, err := Read(bufio.NewReader(strings.NewReader(""))); err != io.EOF {
+		t.Errorf("Read(empty) = %v, want io.EOF", err)
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		h    Header
+	}{
+		{"v1 tcp4", Header{Version: V1, Source: tcpAddr("10.1.2.3:51514"), Dest: tcpAddr("10.0.0.5:8080")}},
+		{"v1 tcp6", Header{Version: V1, Source: tcpAddr("[2001:db8::1]:1"), Dest: tcpAddr("[2001:db8::2]:2")}},
+		{"v1 local", Header{Version: V1, Local: true}},
+		{"v2 tcp4", Header{Version: V2, Source: tcpAddr("10.1.2.3:51514"), Dest: tcpAddr("10.0.0.5:8080"), TLVs: []TLV{{TypeAuthority, []byte("example.org")}, {TypeALPN, []byte("h2")}}}},
+		{"v2 tcp6", Header{Version: V2, Source: tcpAddr("[2001:db8::1]:1"), Dest: tcpAddr("[2001:db8::2]:2")}},
+		{"v2 local", Header{Version: V2, Local: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.h.Format()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Read(bufio.NewReader(bytes.NewReader(b)))
+			if err != nil {
+				t.Fatalf("Read(%q): %v", b, err)
+			}
+			if got.String() != tt.h.String() || len(got.TLVs) != len(tt.h.TLVs) {
+				t.Fatalf("round trip = %v, want %v", got, &tt.h)
+			}
+			for i, tlv := range tt.h.TLVs {
+				if got.TLVs[i].Type != tlv.Type || !bytes.Equal(got.TLVs[i].Value, tlv.Value) {
+					t.Errorf("TLV %d = %+v, want %+v", i, got.TLVs[i], tlv)
+				}
+			}
+		})
+	}
+
+	if b, _ := (&Header{Version: V1, Source: tcpAddr("10.1.2.3:51514"), Dest: tcpAddr("10.0.0.5:8080")}).Format(); string(b) != "PROXY TCP4 10.1.2.3 10.0.0.5 51514 8080\r\n" {
+		t.Errorf("v1 format = %q", b)
+	}
+	mixed := Header{Source: tcpAddr("10.1.2.3:1"), Dest: tcpAddr("[2001:db8::2]:2")}
+	for _, v := range []Version{V1, V2} {
+		mixed.Version = v
+		if _, err := mixed.Format(); err == nil {
+			t.Errorf("v%d mixed families: no error", v)
+		}
+	}
+	if _, err := (&Header{Version: Off}).Format(); err == nil {
+		t.Error("format version Off: no error")
+	}
+}
+
+// sslTLV builds a TypeSSL TLV as a TLS-terminating proxy sends it.
+func sslTLV(client byte, verify uint32, subs ...TLV) TLV {
+	v := binary.BigEndian.AppendUint32([]byte{client}, verify)
+	for _, sub := range subs {
+		v = appendTLV(v, sub)
+	}
+	return TLV{TypeSSL, v}
+}
+
+func TestSSL(t *testing.T) {
+	version := TLV{SubtypeSSLVersion, []byte("TLSv1.3")}
+	cn := TLV{SubtypeSSLCN, []byte("client")}
+	cipher := TLV{SubtypeSSLCipher, []byte("TLS_AES_128_GCM_SHA256")}
+	tests := []struct {
+		name string
+		tlvs []TLV
+		want SSLInfo
+		ok   bool
+	}{
+		{"verified client cert", []TLV{sslTLV(clientSSL|clientCertConn, 0, version, cn, cipher)},
+			SSLInfo{ClientCert: true, Verified: true, Version: "TLSv1.3", CN: "client", Cipher: "TLS_AES_128_GCM_SHA256"}, true},
+		{"unverified client cert", []TLV{sslTLV(clientSSL|clientCertConn, 1, version, cn)},
+			SSLInfo{ClientCert: true, Version: "TLSv1.3", CN: "client"}, true},
+		{"no client cert", []TLV{sslTLV(clientSSL, 1, version)}, SSLInfo{Version: "TLSv1.3"}, true},
+		{"not over tls", []TLV{sslTLV(0, 1)}, SSLInfo{}, false},
+		{"truncated", []TLV{{TypeSSL, []byte{clientSSL, 0, 0}}}, SSLInfo{}, false},
+		{"no ssl tlv", []TLV{{TypeAuthority, []byte("example.org")}}, SSLInfo{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Through the wire format, as the tunnel forwards them.
+			b, err := (&Header{Version: V2, Source: tcpAddr("10.1.2.3:1"), Dest: tcpAddr("10.0.0.5:2"), TLVs: tt.tlvs}).Format()
+			if err != nil {
+				t.Fatal(err)
+			}
+			h, err := Read(bufio.NewReader(bytes.NewReader(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ssl, ok := h.SSL(); ssl != tt.want || ok != tt.ok {
+				t.Errorf("SSL() = %+v, %v; want %+v, %v", ssl, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inner.Close()
+
+	accept := func(trusted string, send string) (net.Conn, string) {
+		t.Helper()
+		cidrs, err := ParseCIDRs(trusted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln := NewListener(inner, cidrs, time.Second)
+		c, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if _, err := io.WriteString(c, send); err != nil {
+			t.Fatal(err)
+		}
+		sc, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 64)
+		n, _ := sc.Read(b)
+		return sc, string(b[:n])
+	}
+
+	hdr := "PROXY TCP4 10.1.2.3 10.0.0.5 51514 8080\r\n"
+	sc, data := accept("127.0.0.1", hdr+"hello")
+	defer sc.Close()
+	if data != "hello" {
+		t.Errorf("trusted: data = %q", data)
+	}
+	if sc.RemoteAddr().String() != "10.1.2.3:51514" || sc.LocalAddr().String() != "10.0.0.5:8080" {
+		t.Errorf("trusted: addresses %s -> %s", sc.RemoteAddr(), sc.LocalAddr())
+	}
+	if HeaderOf(sc) == nil || !strings.HasPrefix(PeerAddr(sc).String(), "127.0.0.1:") {
+		t.Errorf("trusted: header %v, peer %s", HeaderOf(sc), PeerAddr(sc))
+	}
+
+	sc, data = accept("10.0.0.0/8", hdr+"hello")
+	defer sc.Close()
+	if data != hdr+"hello" || HeaderOf(sc) != nil {
+		t.Errorf("untrusted: header was interpreted, data %q", data)
+	}
+
+	sc, data = accept("127.0.0.0/8", "hello")
+	defer sc.Close()
+	if data != "hello" || HeaderOf(sc) != nil {
+		t.Errorf("trusted without header: data %q", data)
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs(" 10.0.0.0/8, 127.0.0.1 ,::1,, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "127.0.0.1/32", "::1/128", "2001:db8::/32"}
+	if len(nets) != len(want) {
+		t.Fatalf("ParseCIDRs = %v", nets)
+	}
+	for i, n := range nets {
+		if n.String() != want[i] {
+			t.Errorf("net %d = %s, want %s", i, n, want[i])
+		}
+	}
+	for _, bad := range []string{"10.0.0.0/33", "localhost", "10.0.0"} {
+		if _, err := ParseCIDRs(bad); err == nil {
+			t.Errorf("ParseCIDRs(%q): no error", bad)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for in, want := range map[string]Version{"": Off, "off": Off, "v1": V1, "1": V1, "V2": V2, "2": V2} {
+		if got, err := ParseVersion(in); err != nil || got != want {
+			t.Errorf("ParseVersion(%q) = %d, %v", in, got, err)
+		}
+	}
+	if _, err := ParseVersion("v3"); err == nil {
+		t.Error("ParseVersion(v3): no error")
+	}
+}
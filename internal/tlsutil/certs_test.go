@@ -0,0 +1,129 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a generated certificate and its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// certSpec describes a certificate for newCert. Zero times mean valid from an
+// hour ago for a year.
+type certSpec struct {
+	cn        string
+	ca        bool
+	dns       []string
+	ips       []net.IP
+	eku       []x509.ExtKeyUsage
+	notBefore time.Time
+	notAfter  time.Time
+	aia       []string
+}
+
+var serial int64
+
+// newCert issues a certificate for spec, signed by parent or self-signed
+// when parent is nil.
+func newCert(t *testing.T, spec certSpec, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.notBefore.IsZero() {
+		spec.notBefore = time.Now().Add(-time.Hour)
+	}
+	if spec.notAfter.IsZero() {
+		spec.notAfter = time.Now().Add(365 * 24 * time.Hour)
+	}
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: spec.cn},
+		NotBefore:             spec.notBefore,
+		NotAfter:              spec.notAfter,
+		DNSNames:              spec.dns,
+		IPAddresses:           spec.ips,
+		ExtKeyUsage:           spec.eku,
+		IssuingCertificateURL: spec.aia,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  spec.ca,
+	}
+	if spec.ca {
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: c, key: key}
+}
+
+// writePEM writes certs as a PEM bundle in dir and returns its path.
+func writePEM(t *testing.T, dir, name string, certs ...*testCert) string {
+	t.Helper()
+	var b []byte
+	for _, c := range certs {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})...)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeKey writes the key of c in dir and returns its path.
+func writeKey(t *testing.T, dir, name string, c *testCert) string {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// pki is a root, an intermediate and a server leaf issued by it.
+type pki struct {
+	root, inter, leaf *testCert
+}
+
+func newPKI(t *testing.T) pki {
+	t.Helper()
+	root := newCert(t, certSpec{cn: "Test Root", ca: true}, nil)
+	inter := newCert(t, certSpec{cn: "Test Intermediate", ca: true}, root)
+	leaf := newCert(t, certSpec{
+		cn:  "localhost",
+		dns: []string{"localhost"},
+		ips: []net.IP{net.ParseIP("127.0.0.1")},
+		eku: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, inter)
+	return pki{root: root, inter: inter, leaf: leaf}
+}
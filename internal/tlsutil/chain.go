@@ -0,0 +1,210 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const maxChainDepth = 10
+
+// ChainOptions controls how BuildChain completes a leaf's chain.
+type ChainOptions struct {
+	// Intermediates are candidate issuers in addition to the extra certs in the cert file.
+	Intermediates []*x509.Certificate
+	// Roots terminate the chain; roots themselves are never served. Nil means system roots.
+	Roots *x509.CertPool
+	// FetchAIA downloads missing issuers from the certificate's AIA caIssuers URLs.
+	FetchAIA bool
+	// HTTPClient is used for AIA fetches; nil uses a client with a short timeout.
+	HTTPClient *http.Client
+}
+
+// ChainResult is the chain to serve for a leaf, plus anything odd found on the way.
+type ChainResult struct {
+	Chain    []*x509.Certificate // leaf first, root excluded
+	Complete bool                // chain reaches a trusted or self-signed root
+	Fetched  []string            // AIA URLs that supplied missing issuers
+	Warnings []string
+}
+
+// BuildChain orders the issuers of certs[0] (the leaf) from certs[1:] and
+// opts.Intermediates, optionally fetching missing ones via AIA. Extra,
+// duplicate or out-of-order certificates in certs are reported as warnings.
+func BuildChain(certs []*x509.Certificate, opts ChainOptions) (*ChainResult, error) {
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates")
+	}
+	leaf := certs[0]
+	res := &ChainResult{Chain: []*x509.Certificate{leaf}}
+
+	candidates := append(append([]*x509.Certificate{}, certs[1:]...), opts.Intermediates...)
+	roots := opts.Roots
+	if roots == nil {
+		sys, err := x509.SystemCertPool()
+		if err == nil {
+			roots = sys
+		} else {
+			roots = x509.NewCertPool()
+		}
+	}
+
+	cur := leaf
+	for depth := 0; depth < maxChainDepth; depth++ {
+		if isSelfSigned(cur) {
+			if cur != leaf {
+				// A root found among the candidates; clients already have it.
+				res.Chain = res.Chain[:len(res.Chain)-1]
+			}
+			res.Complete = true
+			break
+		}
+		if issuedByRoot(cur, roots) {
+			res.Complete = true
+			break
+		}
+		issuer := findIssuer(cur, candidates)
+		if issuer == nil && opts.FetchAIA {
+			var url string
+			issuer, url = fetchIssuer(cur, opts.HTTPClient)
+			if issuer != nil {
+				res.Fetched = append(res.Fetched, url)
+			}
+		}
+		if issuer == nil {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("issuer %q of %q not found; chain is incomplete", cur.Issuer.CommonName, cur.Subject.CommonName))
+			break
+		}
+		res.Chain = append(res.Chain, issuer)
+		cur = issuer
+	}
+
+	// Compare the served chain with what was in the cert file.
+	used := map[*x509.Certificate]bool{}
+	for _, c := range res.Chain {
+		used[c] = true
+	}
+	for i, c := range certs[1:] {
+		if used[c] {
+			if i+1 >= len(res.Chain) || res.Chain[i+1] != c {
+				res.Warnings = append(res.Warnings, fmt.Sprintf("certificate %q is out of order in the cert file", c.Subject.CommonName))
+			}
+			continue
+		}
+		if isSelfSigned(c) {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("root %q is bundled in the cert file; it is not served", c.Subject.CommonName))
+		} else {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("certificate %q in the cert file is not part of the chain", c.Subject.CommonName))
+		}
+	}
+	return res, nil
+}
+
+// LoadChainedKeyPair loads a key pair like tls.LoadX509KeyPair but serves the
+// chain built by BuildChain instead of the raw contents of certFile.
+func LoadChainedKeyPair(certFile, keyFile string, opts ChainOptions) (tls.Certificate, *ChainResult, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("load key pair: %w", err)
+	}
+	parsed := make([]*x509.Certificate, 0, len(cert.Certificate))
+	for _, der := range cert.Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return tls.Certificate{}, nil, fmt.Errorf("parse certificate: %w", err)
+		}
+		parsed = append(parsed, c)
+	}
+	res, err := BuildChain(parsed, opts)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	cert.Certificate = cert.Certificate[:0:0]
+	for _, c := range res.Chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	cert.Leaf = res.Chain[0]
+	return cert, res, nil
+}
+
+// LoadCertsFile reads all certificates from a PEM bundle.
+func LoadCertsFile(path string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertsPEM(b)
+}
+
+func loadPoolFile(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(b); !ok {
+		return nil, fmt.Errorf("append CA certs failed")
+	}
+	return pool, nil
+}
+
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil
+}
+
+func findIssuer(c *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, cand := range candidates {
+		if cand == c || !bytes.Equal(c.RawIssuer, cand.RawSubject) {
+			continue
+		}
+		if c.CheckSignatureFrom(cand) == nil {
+			return cand
+		}
+	}
+	return nil
+}
+
+func issuedByRoot(c *x509.Certificate, roots *x509.CertPool) bool {
+	_, err := c.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		CurrentTime: c.NotBefore.Add(time.Second),
+	})
+	return err == nil
+}
+
+func fetchIssuer(c *x509.Certificate, client *http.Client) (*x509.Certificate, string) {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	for _, url := range c.IssuingCertificateURL {
+		resp, err := client.Get(url)
+		if err != nil {
+			continue
+		}
+		b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		// caIssuers is usually DER, sometimes PEM.
+		var issuers []*x509.Certificate
+		if cert, err := x509.ParseCertificate(b); err == nil {
+			issuers = []*x509.Certificate{cert}
+		} else if certs, err := parseCertsPEM(b); err == nil {
+			issuers = certs
+		}
+		if issuer := findIssuer(c, issuers); issuer != nil {
+			return issuer, url
+		}
+	}
+	return nil, ""
+}
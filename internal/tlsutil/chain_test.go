@@ -0,0 +1,206 @@
+package tlsutil
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuildChain(t *testing.T) {
+	p := newPKI(t)
+	unrelated := newCert(t, certSpec{cn: "Unrelated"}, newCert(t, certSpec{cn: "Other Root", ca: true}, nil))
+	roots := x509.NewCertPool()
+	roots.AddCert(p.root.cert)
+
+	tests := []struct {
+		name     string
+		certs    []*testCert
+		extra    []*testCert
+		want     []*testCert
+		complete bool
+		warnings []string // substrings, one per expected warning
+	}{
+		{
+			name:     "leaf and intermediate",
+			certs:    []*testCert{p.leaf, p.inter},
+			want:     []*testCert{p.leaf, p.inter},
+			complete: true,
+		},
+		{
+			name:     "out of order",
+			certs:    []*testCert{p.leaf, p.root, p.inter},
+			want:     []*testCert{p.leaf, p.inter},
+			complete: true,
+			warnings: []string{`root "Test Root" is bundled`, `"Test Intermediate" is out of order`},
+		},
+		{
+			name:     "root bundled",
+			certs:    []*testCert{p.leaf, p.inter, p.root},
+			want:     []*testCert{p.leaf, p.inter},
+			complete: true,
+			warnings: []string{`root "Test Root" is bundled`},
+		},
+		{
+			name:     "unrelated certificate",
+			certs:    []*testCert{p.leaf, unrelated, p.inter},
+			want:     []*testCert{p.leaf, p.inter},
+			complete: true,
+			warnings: []string{`"Unrelated" in the cert file is not part of the chain`, `"Test Intermediate" is out of order`},
+		},
+		{
+			name:     "intermediate missing",
+			certs:    []*testCert{p.leaf},
+			want:     []*testCert{p.leaf},
+			warnings: []string{`issuer "Test Intermediate" of "localhost" not found`},
+		},
+		{
+			name:     "intermediate from options",
+			certs:    []*testCert{p.leaf},
+			extra:    []*testCert{unrelated, p.inter},
+			want:     []*testCert{p.leaf, p.inter},
+			complete: true,
+		},
+		{
+			name:     "self-signed leaf",
+			certs:    []*testCert{p.root},
+			want:     []*testCert{p.root},
+			complete: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := BuildChain(certsOf(tt.certs), ChainOptions{Intermediates: certsOf(tt.extra), Roots: roots})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := names(res.Chain), names(certsOf(tt.want)); got != want {
+				t.Errorf("chain = %s, want %s", got, want)
+			}
+			if res.Complete != tt.complete {
+				t.Errorf("complete = %v, want %v", res.Complete, tt.complete)
+			}
+			if len(res.Warnings) != len(tt.warnings) {
+				t.Fatalf("warnings = %q, want %d", res.Warnings, len(tt.warnings))
+			}
+			for i, w := range tt.warnings {
+				if !strings.Contains(res.Warnings[i], w) {
+					t.Errorf("warning %d = %q, want it to contain %q", i, res.Warnings[i], w)
+				}
+			}
+		})
+	}
+
+	if _, err := BuildChain(nil, ChainOptions{}); err == nil {
+		t.Error("BuildChain(nil): no error")
+	}
+}
+
+func TestBuildChainAIA(t *testing.T) {
+	root := newCert(t, certSpec{cn: "Test Root", ca: true}, nil)
+	inter := newCert(t, certSpec{cn: "Test Intermediate", ca: true}, root)
+
+	var hits int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/inter.der", func(w http.ResponseWriter, _ *http.Request) {
+		hits++
+		_, _ = w.Write(inter.cert.Raw)
+	})
+	mux.HandleFunc("/inter.pem", func(w http.ResponseWriter, _ *http.Request) {
+		hits++
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: inter.cert.Raw})
+	})
+	mux.HandleFunc("/wrong.der", func(w http.ResponseWriter, _ *http.Request) {
+		hits++
+		_, _ = w.Write(root.cert.Raw)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	tests := []struct {
+		name    string
+		aia     []string
+		fetched string
+	}{
+		{name: "der", aia: []string{srv.URL + "/inter.der"}, fetched: srv.URL + "/inter.der"},
+		{name: "pem", aia: []string{srv.URL + "/inter.pem"}, fetched: srv.URL + "/inter.pem"},
+		{name: "fallback", aia: []string{srv.URL + "/missing", srv.URL + "/wrong.der", srv.URL + "/inter.der"}, fetched: srv.URL + "/inter.der"},
+		{name: "not found", aia: []string{srv.URL + "/missing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaf := newCert(t, certSpec{cn: "localhost", aia: tt.aia}, inter)
+			res, err := BuildChain([]*x509.Certificate{leaf.cert}, ChainOptions{Roots: roots, FetchAIA: true, HTTPClient: srv.Client()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.fetched == "" {
+				if len(res.Fetched) != 0 || res.Complete || len(res.Chain) != 1 {
+					t.Fatalf("fetched %q, complete %v, chain %s; want nothing", res.Fetched, res.Complete, names(res.Chain))
+				}
+				return
+			}
+			if len(res.Fetched) != 1 || res.Fetched[0] != tt.fetched {
+				t.Errorf("fetched = %q, want %q", res.Fetched, tt.fetched)
+			}
+			if !res.Complete || names(res.Chain) != "localhost,Test Intermediate" {
+				t.Errorf("complete %v, chain %s", res.Complete, names(res.Chain))
+			}
+		})
+	}
+
+	// Without FetchAIA nothing is downloaded.
+	hits = 0
+	leaf := newCert(t, certSpec{cn: "localhost", aia: []string{srv.URL + "/inter.der"}}, inter)
+	res, err := BuildChain([]*x509.Certificate{leaf.cert}, ChainOptions{Roots: roots, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits != 0 || res.Complete {
+		t.Errorf("without FetchAIA: %d fetches, complete %v", hits, res.Complete)
+	}
+}
+
+func TestLoadChainedKeyPair(t *testing.T) {
+	p := newPKI(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(p.root.cert)
+	dir := t.TempDir()
+	certFile := writePEM(t, dir, "cert.pem", p.leaf, p.root, p.inter)
+	keyFile := writeKey(t, dir, "key.pem", p.leaf)
+
+	cert, res, err := LoadChainedKeyPair(certFile, keyFile, ChainOptions{Roots: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 2 || !cert.Leaf.Equal(p.leaf.cert) {
+		t.Fatalf("served %d certificates, leaf %v", len(cert.Certificate), cert.Leaf.Subject)
+	}
+	if string(cert.Certificate[1]) != string(p.inter.cert.Raw) {
+		t.Error("second certificate is not the intermediate")
+	}
+	if len(res.Warnings) != 2 {
+		t.Errorf("warnings = %q, want the bundled root and the misplaced intermediate", res.Warnings)
+	}
+}
+
+func certsOf(tcs []*testCert) []*x509.Certificate {
+	out := make([]*x509.Certificate, len(tcs))
+	for i, c := range tcs {
+		out[i] = c.cert
+	}
+	return out
+}
+
+func names(certs []*x509.Certificate) string {
+	var cn []string
+	for _, c := range certs {
+		cn = append(cn, c.Subject.CommonName)
+	}
+	return strings.Join(cn, ",")
+}
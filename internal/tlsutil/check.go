@@ -0,0 +1,446 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckPolicy controls what happens when the startup certificate check finds problems.
+type CheckPolicy string
+
+const (
+	CheckOff  CheckPolicy = "off"
+	CheckWarn CheckPolicy = "warn"
+	CheckFail CheckPolicy = "fail"
+)
+
+// ParseCheckPolicy parses a -cert-check flag value.
+func ParseCheckPolicy(s string) (CheckPolicy, error) {
+	switch p := CheckPolicy(strings.ToLower(s)); p {
+	case CheckOff, CheckWarn, CheckFail:
+		return p, nil
+	case "":
+		return CheckOff, nil
+	}
+	return "", fmt.Errorf("unknown cert check policy %q (want off, warn or fail)", s)
+}
+
+// Severity of a check finding.
+type Severity string
+
+const (
+	SeverityInfo  Severity = "info"
+	SeverityWarn  Severity = "warn"
+	SeverityError Severity = "error"
+)
+
+// Finding is a single result of a certificate check.
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// CertCheckOptions describes the key material to check and what it must satisfy.
+type CertCheckOptions struct {
+	CertFile string
+	KeyFile  string
+	// CAFile is the CA bundle expected to issue the certificate; empty uses system roots.
+	CAFile string
+	// IntermediatesFile optionally provides intermediates not bundled in CertFile.
+	IntermediatesFile string
+	// FetchAIA lets the chain check download missing issuers, as the server would.
+	FetchAIA  bool
+	AIAClient *http.Client
+	// Names must all be covered by the certificate SANs (host names or IPs).
+	Names []string
+	// Usage is the extended key usage the leaf must allow (ServerAuth or ClientAuth).
+	Usage x509.ExtKeyUsage
+	// ExpiryWarning flags certificates with less validity left than this.
+	ExpiryWarning time.Duration
+}
+
+// CertReport is the outcome of CheckCertificate.
+type CertReport struct {
+	CertFile  string        `json:"cert_file"`
+	Subject   string        `json:"subject,omitempty"`
+	Issuer    string        `json:"issuer,omitempty"`
+	NotBefore time.Time     `json:"not_before,omitempty"`
+	NotAfter  time.Time     `json:"not_after,omitempty"`
+	Remaining time.Duration `json:"remaining,omitempty"`
+	Findings  []Finding     `json:"findings"`
+
+	leaf *x509.Certificate
+}
+
+// Leaf returns the parsed leaf certificate, or nil if it could not be loaded.
+func (r *CertReport) Leaf() *x509.Certificate { return r.leaf }
+
+// Problems returns the findings of warn or error severity.
+func (r *CertReport) Problems() []Finding {
+	var out []Finding
+	for _, f := range r.Findings {
+		if f.Severity != SeverityInfo {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// HasErrors reports whether any finding has error severity.
+func (r *CertReport) HasErrors() bool {
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CertReport) add(check string, sev Severity, format string, args ...any) {
+	r.Findings = append(r.Findings, Finding{Check: check, Severity: sev, Message: fmt.Sprintf(format, args...)})
+}
+
+// CheckCertificate validates a certificate/key pair: that the key matches, the chain
+// verifies to the CA, the EKUs fit the intended usage, the SANs cover the expected
+// names and how much validity is left. Only I/O errors are returned as err; all
+// validation problems are reported as findings.
+func CheckCertificate(opts CertCheckOptions) (*CertReport, error) {
+	r := &CertReport{CertFile: opts.CertFile}
+
+	certPEM, err := os.ReadFile(opts.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("read cert file: %w", err)
+	}
+	chain, err := parseCertsPEM(certPEM)
+	if err != nil {
+		r.add("parse", SeverityError, "%v", err)
+		return r, nil
+	}
+	leaf := chain[0]
+	r.leaf = leaf
+	r.Subject = leaf.Subject.String()
+	r.Issuer = leaf.Issuer.String()
+	r.NotBefore = leaf.NotBefore
+	r.NotAfter = leaf.NotAfter
+	r.Remaining = time.Until(leaf.NotAfter).Truncate(time.Second)
+
+	if opts.KeyFile != "" {
+		keyPEM, err := os.ReadFile(opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read key file: %w", err)
+		}
+		if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+			r.add("key", SeverityError, "private key does not match certificate: %v", err)
+		} else {
+			r.add("key", SeverityInfo, "private key matches certificate")
+		}
+	}
+
+	checkValidity(r, leaf, opts.ExpiryWarning)
+	checkUsage(r, leaf, opts.Usage)
+	checkNames(r, leaf, opts.Names)
+	if err := checkChain(r, chain, opts); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func checkValidity(r *CertReport, leaf *x509.Certificate, warn time.Duration) {
+	now := time.Now()
+	switch {
+	case now.Before(leaf.NotBefore):
+		r.add("validity", SeverityError, "certificate not valid until %s", leaf.NotBefore.Format(time.RFC3339))
+	case now.After(leaf.NotAfter):
+		r.add("validity", SeverityError, "certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	case warn > 0 && leaf.NotAfter.Sub(now) < warn:
+		r.add("validity", SeverityWarn, "certificate expires in %s (at %s)", formatDays(leaf.NotAfter.Sub(now)), leaf.NotAfter.Format(time.RFC3339))
+	default:
+		r.add("validity", SeverityInfo, "certificate valid for another %s", formatDays(leaf.NotAfter.Sub(now)))
+	}
+}
+
+func checkUsage(r *CertReport, leaf *x509.Certificate, usage x509.ExtKeyUsage) {
+	if usage == x509.ExtKeyUsageAny {
+		return
+	}
+	if len(leaf.ExtKeyUsage) == 0 && len(leaf.UnknownExtKeyUsage) == 0 {
+		r.add("eku", SeverityWarn, "certificate has no extended key usage; %s expected", ekuName(usage))
+		return
+	}
+	for _, u := range leaf.ExtKeyUsage {
+		if u == usage || u == x509.ExtKeyUsageAny {
+			r.add("eku", SeverityInfo, "extended key usage allows %s", ekuName(usage))
+			return
+		}
+	}
+	r.add("eku", SeverityError, "extended key usage does not allow %s", ekuName(usage))
+}
+
+func checkNames(r *CertReport, leaf *x509.Certificate, names []string) {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if err := leaf.VerifyHostname(name); err != nil {
+			r.add("san", SeverityError, "certificate does not cover %q (DNS=%v IP=%v)", name, leaf.DNSNames, leaf.IPAddresses)
+		} else {
+			r.add("san", SeverityInfo, "certificate covers %q", name)
+		}
+	}
+}
+
+func checkChain(r *CertReport, chain []*x509.Certificate, opts CertCheckOptions) error {
+	vopts := x509.VerifyOptions{
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	var extra []*x509.Certificate
+	if opts.IntermediatesFile != "" {
+		var err error
+		extra, err = LoadCertsFile(opts.IntermediatesFile)
+		if err != nil {
+			return fmt.Errorf("load intermediates: %w", err)
+		}
+	}
+	for _, c := range append(append([]*x509.Certificate{}, chain[1:]...), extra...) {
+		vopts.Intermediates.AddCert(c)
+	}
+	if opts.CAFile != "" {
+		b, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return fmt.Errorf("read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(b); !ok {
+			r.add("chain", SeverityError, "no CA certificates found in %s", opts.CAFile)
+			return nil
+		}
+		vopts.Roots = pool
+	}
+
+	// How the server will actually serve it (see BuildChain).
+	built, err := BuildChain(chain, ChainOptions{
+		Intermediates: extra,
+		Roots:         vopts.Roots,
+		FetchAIA:      opts.FetchAIA,
+		HTTPClient:    opts.AIAClient,
+	})
+	if err == nil {
+		for _, w := range built.Warnings {
+			r.add("chain", SeverityWarn, "%s", w)
+		}
+		for _, u := range built.Fetched {
+			r.add("chain", SeverityInfo, "missing issuer fetched from %s", u)
+		}
+		for _, c := range built.Chain[1:] {
+			vopts.Intermediates.AddCert(c)
+		}
+	}
+
+	chains, err := chain[0].Verify(vopts)
+	if err != nil {
+		var uae x509.UnknownAuthorityError
+		if errors.As(err, &uae) {
+			r.add("chain", SeverityError, "chain incomplete or not issued by the configured CA: %v", err)
+		} else {
+			r.add("chain", SeverityError, "chain verification failed: %v", err)
+		}
+		return nil
+	}
+	r.add("chain", SeverityInfo, "chain verifies (%d certificates to %s)", len(chains[0]), chains[0][len(chains[0])-1].Subject.CommonName)
+	return nil
+}
+
+func parseCertsPEM(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate: %w", err)
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return certs, nil
+}
+
+func ekuName(u x509.ExtKeyUsage) string {
+	switch u {
+	case x509.ExtKeyUsageServerAuth:
+		return "serverAuth"
+	case x509.ExtKeyUsageClientAuth:
+		return "clientAuth"
+	}
+	return fmt.Sprintf("eku(%d)", u)
+}
+
+func formatDays(d time.Duration) string {
+	days := int(d.Hours() / 24)
+	if days >= 2 {
+		return fmt.Sprintf("%dd", days)
+	}
+	return d.Truncate(time.Minute).String()
+}
+
+// HostNames returns the names a listener should be reachable under, for SAN checks.
+// Wildcard listen hosts (0.0.0.0, ::, empty) are skipped.
+func HostNames(addrs ...string) []string {
+	var out []string
+	for _, a := range addrs {
+		host := a
+		if h, _, err := net.SplitHostPort(a); err == nil {
+			host = h
+		}
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			continue
+		}
+		out = append(out, host)
+	}
+	return out
+}
+
+// applyCheck runs CheckCertificate for a server config according to the policy.
+func applyCheck(opts ServerTLSOptions) error {
+	if opts.CheckPolicy == "" || opts.CheckPolicy == CheckOff {
+		return nil
+	}
+	r, err := CheckCertificate(CertCheckOptions{
+		CertFile:      opts.CertFile,
+		KeyFile:       opts.KeyFile,
+		CAFile:        opts.IssuerCAFile,
+		Names:         opts.CheckNames,
+		Usage:         x509.ExtKeyUsageServerAuth,
+		ExpiryWarning: opts.ExpiryWarning,
+	})
+	if err != nil {
+		return err
+	}
+	problems := r.Problems()
+	for _, f := range problems {
+		level := slog.LevelWarn
+		if f.Severity == SeverityError {
+			level = slog.LevelError
+		}
+		slog.Log(context.Background(), level, "cert check", "check", f.Check, "severity", f.Severity, "detail", f.Message)
+	}
+	if opts.CheckPolicy == CheckFail && len(problems) > 0 {
+		return fmt.Errorf("cert check failed for %s: %d problem(s)", opts.CertFile, len(problems))
+	}
+	if r.HasErrors() {
+		slog.Warn("cert check: continuing despite errors", "policy", opts.CheckPolicy)
+	}
+	return nil
+}
+
+var (
+	expiryVars = expvar.NewMap("tls_cert_expiry_seconds")
+	expiryMu   sync.Mutex
+	expiryLeaf = map[string]*x509.Certificate{}
+)
+
+// CertExpiry is a certificate watched by MonitorExpiry.
+type CertExpiry struct {
+	Label    string
+	Subject  string
+	NotAfter time.Time
+}
+
+// MonitoredCerts returns the certificates passed to MonitorExpiry, by label.
+func MonitoredCerts() []CertExpiry {
+	expiryMu.Lock()
+	defer expiryMu.Unlock()
+	out := make([]CertExpiry, 0, len(expiryLeaf))
+	for label, c := range expiryLeaf {
+		out = append(out, CertExpiry{Label: label, Subject: c.Subject.CommonName, NotAfter: c.NotAfter})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Label < out[j].Label })
+	return out
+}
+
+// MonitorExpiry publishes the remaining validity of leaf as the expvar
+// tls_cert_expiry_seconds{label} and logs a warning every interval once less
+// than warn is left. It returns a function that stops the periodic warnings.
+func MonitorExpiry(label string, leaf *x509.Certificate, warn, interval time.Duration) (stop func()) {
+	if leaf == nil {
+		return func() {}
+	}
+	expiryMu.Lock()
+	_, seen := expiryLeaf[label]
+	expiryLeaf[label] = leaf
+	expiryMu.Unlock()
+	if !seen {
+		expiryVars.Set(label, expvar.Func(func() any {
+			expiryMu.Lock()
+			c := expiryLeaf[label]
+			expiryMu.Unlock()
+			return int64(time.Until(c.NotAfter).Seconds())
+		}))
+	}
+
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			left := time.Until(leaf.NotAfter)
+			if left <= 0 {
+				slog.Error("cert EXPIRED", "cert", label, "not_after", leaf.NotAfter.Format(time.RFC3339))
+			} else if warn > 0 && left < warn {
+				slog.Warn("cert expires soon", "cert", label, "left", formatDays(left), "not_after", leaf.NotAfter.Format(time.RFC3339))
+			}
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// LeafOf returns the parsed leaf of the first certificate in cfg.
+func LeafOf(cfg *tls.Config) *x509.Certificate {
+	if cfg == nil || len(cfg.Certificates) == 0 {
+		return nil
+	}
+	c := cfg.Certificates[0]
+	if c.Leaf != nil {
+		return c.Leaf
+	}
+	if len(c.Certificate) == 0 {
+		return nil
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return leaf
+}
@@ -0,0 +1,181 @@
+package tlsutil
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestCheckCertificate(t *testing.T) {
+	p := newPKI(t)
+	other := newCert(t, certSpec{cn: "Other Root", ca: true}, nil)
+	expired := newCert(t, certSpec{
+		cn:        "localhost",
+		dns:       []string{"localhost"},
+		eku:       []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		notBefore: time.Now().Add(-48 * time.Hour),
+		notAfter:  time.Now().Add(-24 * time.Hour),
+	}, p.inter)
+	future := newCert(t, certSpec{
+		cn:        "localhost",
+		dns:       []string{"localhost"},
+		eku:       []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		notBefore: time.Now().Add(24 * time.Hour),
+	}, p.inter)
+	expiring := newCert(t, certSpec{
+		cn:       "localhost",
+		dns:      []string{"localhost"},
+		eku:      []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		notAfter: time.Now().Add(3 * 24 * time.Hour),
+	}, p.inter)
+	clientOnly := newCert(t, certSpec{
+		cn:  "client",
+		eku: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, p.inter)
+	noEKU := newCert(t, certSpec{cn: "localhost", dns: []string{"localhost"}}, p.inter)
+
+	dir := t.TempDir()
+	caFile := writePEM(t, dir, "ca.crt", p.root)
+	otherCA := writePEM(t, dir, "other.crt", other)
+	interFile := writePEM(t, dir, "inter.crt", p.inter)
+
+	tests := []struct {
+		name  string
+		certs []*testCert
+		key   *testCert
+		opts  CertCheckOptions
+		want  map[string]Severity // check -> severity of its first finding
+	}{
+		{
+			name:  "valid chain",
+			certs: []*testCert{p.leaf, p.inter},
+			key:   p.leaf,
+			opts: CertCheckOptions{
+				CAFile: caFile,
+				Names:  []string{"localhost", "127.0.0.1"},
+				Usage:  x509.ExtKeyUsageServerAuth,
+			},
+			want: map[string]Severity{"key": SeverityInfo, "validity": SeverityInfo, "eku": SeverityInfo, "san": SeverityInfo, "chain": SeverityInfo},
+		},
+		{
+			name:  "key mismatch",
+			certs: []*testCert{p.leaf, p.inter},
+			key:   p.inter,
+			opts:  CertCheckOptions{CAFile: caFile},
+			want:  map[string]Severity{"key": SeverityError},
+		},
+		{
+			name:  "expired",
+			certs: []*testCert{expired, p.inter},
+			opts:  CertCheckOptions{CAFile: caFile},
+			want:  map[string]Severity{"validity": SeverityError},
+		},
+		{
+			name:  "not yet valid",
+			certs: []*testCert{future, p.inter},
+			opts:  CertCheckOptions{CAFile: caFile},
+			want:  map[string]Severity{"validity": SeverityError},
+		},
+		{
+			name:  "expiring soon",
+			certs: []*testCert{expiring, p.inter},
+			opts:  CertCheckOptions{CAFile: caFile, ExpiryWarning: 30 * 24 * time.Hour},
+			want:  map[string]Severity{"validity": SeverityWarn, "chain": SeverityInfo},
+		},
+		{
+			name:  "wrong eku",
+			certs: []*testCert{clientOnly, p.inter},
+			opts:  CertCheckOptions{CAFile: caFile, Usage: x509.ExtKeyUsageServerAuth},
+			want:  map[string]Severity{"eku": SeverityError},
+		},
+		{
+			name:  "missing eku",
+			certs: []*testCert{noEKU, p.inter},
+			opts:  CertCheckOptions{CAFile: caFile, Usage: x509.ExtKeyUsageServerAuth},
+			want:  map[string]Severity{"eku": SeverityWarn},
+		},
+		{
+			name:  "san not covered",
+			certs: []*testCert{p.leaf, p.inter},
+			opts:  CertCheckOptions{CAFile: caFile, Names: []string{"example.com"}},
+			want:  map[string]Severity{"san": SeverityError},
+		},
+		{
+			name:  "intermediate missing",
+			certs: []*testCert{p.leaf},
+			opts:  CertCheckOptions{CAFile: caFile},
+			want:  map[string]Severity{"chain": SeverityWarn},
+		},
+		{
+			name:  "intermediate from separate file",
+			certs: []*testCert{p.leaf},
+			opts:  CertCheckOptions{CAFile: caFile, IntermediatesFile: interFile},
+			want:  map[string]Severity{"chain": SeverityInfo},
+		},
+		{
+			name:  "wrong CA",
+			certs: []*testCert{p.leaf, p.inter},
+			opts:  CertCheckOptions{CAFile: otherCA},
+			want:  map[string]Severity{"chain": SeverityWarn},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.opts.CertFile = writePEM(t, dir, "cert.pem", tt.certs...)
+			if tt.key != nil {
+				tt.opts.KeyFile = writeKey(t, dir, "key.pem", tt.key)
+			}
+			r, err := CheckCertificate(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]Severity{}
+			for _, f := range r.Findings {
+				if _, ok := got[f.Check]; !ok {
+					got[f.Check] = f.Severity
+				}
+			}
+			for check, sev := range tt.want {
+				if got[check] != sev {
+					t.Errorf("%s = %q, want %q; findings: %+v", check, got[check], sev, r.Findings)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckCertificateChainErrors(t *testing.T) {
+	p := newPKI(t)
+	other := newCert(t, certSpec{cn: "Other Root", ca: true}, nil)
+	dir := t.TempDir()
+	opts := CertCheckOptions{
+		CertFile: writePEM(t, dir, "cert.pem", p.leaf, p.inter),
+		CAFile:   writePEM(t, dir, "other.crt", other),
+	}
+	r, err := CheckCertificate(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.HasErrors() {
+		t.Fatalf("chain to an unrelated CA reported no errors: %+v", r.Findings)
+	}
+	if len(r.Problems()) == 0 {
+		t.Fatal("no problems reported")
+	}
+}
+
+func TestCheckCertificateParseError(t *testing.T) {
+	dir := t.TempDir()
+	path := writePEM(t, dir, "empty.pem")
+	r, err := CheckCertificate(CertCheckOptions{CertFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Findings) != 1 || r.Findings[0].Check != "parse" || r.Findings[0].Severity != SeverityError {
+		t.Fatalf("findings = %+v, want one parse error", r.Findings)
+	}
+	if _, err := CheckCertificate(CertCheckOptions{CertFile: dir + "/missing.pem"}); err == nil {
+		t.Fatal("missing cert file: no error")
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"time"
 )
 
 type ServerTLSOptions struct {
@@ -15,6 +18,18 @@ type ServerTLSOptions struct {
 	MinVersion         uint16
 	EnableTLS13        bool
 	PreferServerCipher bool
+
+	// Startup checks of CertFile/KeyFile (see CheckCertificate).
+	CheckPolicy   CheckPolicy
+	CheckNames    []string
+	IssuerCAFile  string // CA expected to have issued CertFile; empty uses system roots
+	ExpiryWarning time.Duration
+
+	// Chain building (see BuildChain): issuers are taken from CertFile,
+	// IntermediatesFile and, if FetchAIA is set, the leaf's AIA URLs.
+	IntermediatesFile string
+	FetchAIA          bool
+	AIAClient         *http.Client
 }
 
 type ClientTLSOptions struct {
@@ -24,13 +39,23 @@ type ClientTLSOptions struct {
 	ServerName  string
 	MinVersion  uint16
 	EnableTLS13 bool
+
+	// DANE authenticates the server with TLSA records looked up for
+	// DANETarget (host:port) through the DNS server at DANEResolver.
+	DANE          DANEMode
+	DANEResolver  string
+	DANETarget    string
+	DANERequireAD bool
 }
 
 // NewServerTLSConfig builds a hardened tls.Config for servers.
 func NewServerTLSConfig(opts ServerTLSOptions) (*tls.Config, error) {
-	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err := applyCheck(opts); err != nil {
+		return nil, err
+	}
+	cert, err := loadServerCert(opts)
 	if err != nil {
-		return nil, fmt.Errorf("load key pair: %w", err)
+		return nil, err
 	}
 
 	var clientCAs *x509.CertPool
@@ -56,36 +81,47 @@ func NewServerTLSConfig(opts ServerTLSOptions) (*tls.Config, error) {
 		ClientCAs:                clientCAs,
 		PreferServerCipherSuites: opts.PreferServerCipher,
 		MinVersion:               opts.MinVersion,
-		CurvePreferences: []tls.CurveID{
-			tls.X25519,
-			tls.CurveP256,
-			tls.CurveP384,
-		},
+		CurvePreferences:         append([]tls.CurveID(nil), defaultCurves...),
 	}
 	if !opts.EnableTLS13 {
 		cfg.MaxVersion = tls.VersionTLS12
 	}
 	// Strong cipher suites (TLS 1.2); TLS 1.3 suites are fixed by Go runtime.
-	cfg.CipherSuites = []uint16{
-		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
-		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
-	}
+	cfg.CipherSuites = append([]uint16(nil), defaultCipherSuites...)
 	return cfg, nil
 }
 
+func loadServerCert(opts ServerTLSOptions) (tls.Certificate, error) {
+	chainOpts := ChainOptions{FetchAIA: opts.FetchAIA, HTTPClient: opts.AIAClient}
+	roots, err := loadPoolFile(opts.IssuerCAFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	chainOpts.Roots = roots
+	if opts.IntermediatesFile != "" {
+		chainOpts.Intermediates, err = LoadCertsFile(opts.IntermediatesFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("load intermediates: %w", err)
+		}
+	}
+	cert, res, err := LoadChainedKeyPair(opts.CertFile, opts.KeyFile, chainOpts)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	for _, w := range res.Warnings {
+		slog.Warn("cert chain", "cert", opts.CertFile, "detail", w)
+	}
+	for _, u := range res.Fetched {
+		slog.Info("cert chain: fetched missing issuer", "url", u)
+	}
+	return cert, nil
+}
+
 // NewClientTLSConfig builds a hardened tls.Config for clients.
 func NewClientTLSConfig(opts ClientTLSOptions) (*tls.Config, error) {
 	cfg := &tls.Config{
-		MinVersion: opts.MinVersion,
-		CurvePreferences: []tls.CurveID{
-			tls.X25519,
-			tls.CurveP256,
-			tls.CurveP384,
-		},
+		MinVersion:       opts.MinVersion,
+		CurvePreferences: append([]tls.CurveID(nil), defaultCurves...),
 	}
 	if opts.ServerName != "" {
 		cfg.ServerName = opts.ServerName
@@ -93,14 +129,7 @@ func NewClientTLSConfig(opts ClientTLSOptions) (*tls.Config, error) {
 	if !opts.EnableTLS13 {
 		cfg.MaxVersion = tls.VersionTLS12
 	}
-	cfg.CipherSuites = []uint16{
-		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
-		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
-	}
+	cfg.CipherSuites = append([]uint16(nil), defaultCipherSuites...)
 
 	// Trust store
 	if opts.CAFile != "" {
@@ -123,7 +152,9 @@ func NewClientTLSConfig(opts ClientTLSOptions) (*tls.Config, error) {
 		}
 		cfg.Certificates = []tls.Certificate{cert}
 	}
+
+	if err := applyDANE(cfg, opts); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
-
-
@@ -0,0 +1,351 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DANEMode selects how upstream servers are authenticated with TLSA records.
+type DANEMode string
+
+const (
+	DANEOff   DANEMode = ""
+	DANEOnly  DANEMode = "dane"    // TLSA records replace the CA bundle
+	DANEAndCA DANEMode = "dane+ca" // both TLSA and the CA bundle must pass
+)
+
+// ParseDANEMode parses a -dane flag value.
+func ParseDANEMode(s string) (DANEMode, error) {
+	switch m := DANEMode(strings.ToLower(s)); m {
+	case DANEOnly, DANEAndCA:
+		return m, nil
+	case "", "off":
+		return DANEOff, nil
+	}
+	return "", fmt.Errorf("unknown DANE mode %q (want off, dane or dane+ca)", s)
+}
+
+// TLSA usages, selectors and matching types (RFC 6698). Only DANE-TA and
+// DANE-EE are supported; PKIX-TA/PKIX-EE records are ignored.
+const (
+	TLSAUsageDANETA = 2
+	TLSAUsageDANEEE = 3
+
+	TLSASelectorCert = 0
+	TLSASelectorSPKI = 1
+
+	TLSAMatchFull   = 0
+	TLSAMatchSHA256 = 1
+	TLSAMatchSHA512 = 2
+)
+
+const typeTLSA = dnsmessage.Type(52)
+
+// TLSARecord is one TLSA resource record.
+type TLSARecord struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Data         []byte
+}
+
+func (r TLSARecord) String() string {
+	return fmt.Sprintf("%d %d %d %x", r.Usage, r.Selector, r.MatchingType, r.Data)
+}
+
+// Matches reports whether cert matches the record's selector and data.
+func (r TLSARecord) Matches(cert *x509.Certificate) bool {
+	var sel []byte
+	switch r.Selector {
+	case TLSASelectorCert:
+		sel = cert.Raw
+	case TLSASelectorSPKI:
+		sel = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+	switch r.MatchingType {
+	case TLSAMatchFull:
+		return bytes.Equal(sel, r.Data)
+	case TLSAMatchSHA256:
+		sum := sha256.Sum256(sel)
+		return bytes.Equal(sum[:], r.Data)
+	case TLSAMatchSHA512:
+		sum := sha512.Sum512(sel)
+		return bytes.Equal(sum[:], r.Data)
+	}
+	return false
+}
+
+// TLSAName returns the owner name of the TLSA records for a TCP service at
+// hostport, e.g. _443._tcp.example.com.
+func TLSAName(hostport string) (string, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", err
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	return "_" + port + "._tcp." + strings.TrimSuffix(host, ".") + ".", nil
+}
+
+// DANETarget joins the verify name (or the host of addr if serverName is
+// empty) with the port of addr, for use as ClientTLSOptions.DANETarget.
+func DANETarget(addr, serverName string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if serverName != "" {
+		host = serverName
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// TLSAResolver looks up TLSA records with plain DNS against a single server.
+// The server must be trusted to validate DNSSEC (typically a local validating
+// resolver); with RequireAD set, answers without the AD bit are rejected.
+type TLSAResolver struct {
+	Server    string // host:port of the DNS server
+	Timeout   time.Duration
+	RequireAD bool
+
+	mu    sync.Mutex
+	cache map[string]tlsaCacheEntry
+}
+
+type tlsaCacheEntry struct {
+	records []TLSARecord
+	expires time.Time
+}
+
+// Lookup returns the TLSA records for name (see TLSAName).
+func (r *TLSAResolver) Lookup(name string) ([]TLSARecord, error) {
+	r.mu.Lock()
+	if e, ok := r.cache[name]; ok && time.Now().Before(e.expires) {
+		r.mu.Unlock()
+		return e.records, nil
+	}
+	r.mu.Unlock()
+
+	records, ttl, err := r.query(name)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if r.cache == nil {
+		r.cache = map[string]tlsaCacheEntry{}
+	}
+	r.cache[name] = tlsaCacheEntry{records: records, expires: time.Now().Add(ttl)}
+	r.mu.Unlock()
+	return records, nil
+}
+
+func (r *TLSAResolver) query(name string) ([]TLSARecord, time.Duration, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("tlsa name: %w", err)
+	}
+	var idb [2]byte
+	_, _ = rand.Read(idb[:])
+	id := binary.BigEndian.Uint16(idb[:])
+	q := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true, AuthenticData: true},
+		Questions: []dnsmessage.Question{{
+			Name:  qname,
+			Type:  typeTLSA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packed, err := q.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	resp, err := exchangeDNS("udp", r.Server, packed, timeout)
+	if err == nil && resp.Header.Truncated {
+		resp, err = exchangeDNS("tcp", r.Server, packed, timeout)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("tlsa lookup %s: %w", name, err)
+	}
+	if resp.Header.ID != id {
+		return nil, 0, fmt.Errorf("tlsa lookup %s: mismatched DNS response id", name)
+	}
+	if resp.Header.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("tlsa lookup %s: %v", name, resp.Header.RCode)
+	}
+	if r.RequireAD && !resp.Header.AuthenticData {
+		return nil, 0, fmt.Errorf("tlsa lookup %s: answer not DNSSEC-validated (AD bit unset)", name)
+	}
+
+	var out []TLSARecord
+	ttl := time.Hour
+	for _, a := range resp.Answers {
+		u, ok := a.Body.(*dnsmessage.UnknownResource)
+		if a.Header.Type != typeTLSA || !ok || len(u.Data) < 3 {
+			continue
+		}
+		out = append(out, TLSARecord{
+			Usage:        u.Data[0],
+			Selector:     u.Data[1],
+			MatchingType: u.Data[2],
+			Data:         append([]byte(nil), u.Data[3:]...),
+		})
+		if d := time.Duration(a.Header.TTL) * time.Second; d < ttl {
+			ttl = d
+		}
+	}
+	if len(out) == 0 {
+		return nil, 0, fmt.Errorf("tlsa lookup %s: no TLSA records", name)
+	}
+	return out, ttl, nil
+}
+
+func exchangeDNS(network, server string, query []byte, timeout time.Duration) (*dnsmessage.Message, error) {
+	c, err := net.DialTimeout(network, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(timeout))
+
+	var b []byte
+	if network == "tcp" {
+		msg := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(msg, uint16(len(query)))
+		copy(msg[2:], query)
+		if _, err := c.Write(msg); err != nil {
+			return nil, err
+		}
+		var lb [2]byte
+		if _, err := io.ReadFull(c, lb[:]); err != nil {
+			return nil, err
+		}
+		b = make([]byte, binary.BigEndian.Uint16(lb[:]))
+		if _, err := io.ReadFull(c, b); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := c.Write(query); err != nil {
+			return nil, err
+		}
+		b = make([]byte, 65535)
+		n, err := c.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[:n]
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ErrDANENoMatch is returned when no usable TLSA record matches the peer chain.
+var ErrDANENoMatch = errors.New("dane: no TLSA record matches the server certificate")
+
+// VerifyDANE checks a peer chain against TLSA records. DANE-EE matches the
+// leaf only and skips name and validity checks (RFC 7671); DANE-TA requires
+// a matching certificate in the presented chain to act as the trust anchor
+// for a normal chain and hostname verification of serverName.
+func VerifyDANE(chain []*x509.Certificate, serverName string, records []TLSARecord) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("dane: no peer certificates")
+	}
+	leaf := chain[0]
+	var lastErr error = ErrDANENoMatch
+	for _, rec := range records {
+		switch rec.Usage {
+		case TLSAUsageDANEEE:
+			if rec.Matches(leaf) {
+				return nil
+			}
+		case TLSAUsageDANETA:
+			for i, c := range chain {
+				if !rec.Matches(c) {
+					continue
+				}
+				roots := x509.NewCertPool()
+				roots.AddCert(c)
+				if i == 0 {
+					// Leaf is its own trust anchor; still check the name.
+					if err := leaf.VerifyHostname(serverName); err != nil {
+						lastErr = err
+						continue
+					}
+					return nil
+				}
+				inter := x509.NewCertPool()
+				for _, ic := range chain[1:i] {
+					inter.AddCert(ic)
+				}
+				_, err := leaf.Verify(x509.VerifyOptions{
+					DNSName:       serverName,
+					Roots:         roots,
+					Intermediates: inter,
+				})
+				if err == nil {
+					return nil
+				}
+				lastErr = fmt.Errorf("dane-ta: %w", err)
+			}
+		}
+	}
+	return lastErr
+}
+
+// applyDANE wires TLSA verification into a client config.
+func applyDANE(cfg *tls.Config, opts ClientTLSOptions) error {
+	if opts.DANE == DANEOff {
+		return nil
+	}
+	if opts.DANEResolver == "" || opts.DANETarget == "" {
+		return fmt.Errorf("dane: resolver and target are required")
+	}
+	name, err := TLSAName(opts.DANETarget)
+	if err != nil {
+		return fmt.Errorf("dane target: %w", err)
+	}
+	verifyName := opts.ServerName
+	if verifyName == "" {
+		verifyName, _, _ = net.SplitHostPort(opts.DANETarget)
+	}
+	resolver := &TLSAResolver{Server: opts.DANEResolver, RequireAD: opts.DANERequireAD}
+	if opts.DANE == DANEOnly {
+		// Chain and name checks move into VerifyConnection.
+		cfg.InsecureSkipVerify = true
+	}
+	// VerifyConnection, unlike VerifyPeerCertificate, also runs on resumed
+	// sessions, so a ticket from an earlier connection cannot skip DANE.
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		records, err := resolver.Lookup(name)
+		if err != nil {
+			return fmt.Errorf("dane: %w", err)
+		}
+		return VerifyDANE(cs.PeerCertificates, verifyName, records)
+	}
+	return nil
+}
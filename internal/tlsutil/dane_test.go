@@ -0,0 +1,337 @@
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsStub answers TLSA queries over UDP and TCP on the same port.
+type dnsStub struct {
+	addr string
+
+	mu       sync.Mutex
+	records  map[string][]TLSARecord
+	truncate bool // answer UDP queries with TC set
+	ad       bool
+	udp, tcp int // queries seen
+}
+
+func newDNSStub(t *testing.T) *dnsStub {
+	t.Helper()
+	s := &dnsStub{records: map[string][]TLSARecord{}, ad: true}
+	var (
+		ln net.Listener
+		pc net.PacketConn
+	)
+	for range 10 {
+		var err error
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc, err = net.ListenPacket("udp", ln.Addr().String())
+		if err == nil {
+			break
+		}
+		ln.Close()
+		ln = nil
+	}
+	if ln == nil {
+		t.Fatal("no port free for both UDP and TCP")
+	}
+	s.addr = ln.Addr().String()
+	t.Cleanup(func() { ln.Close(); pc.Close() })
+
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, from, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.udp++
+			s.mu.Unlock()
+			if resp := s.answer(b[:n], true); resp != nil {
+				_, _ = pc.WriteTo(resp, from)
+			}
+		}
+	}()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var lb [2]byte
+				if _, err := io.ReadFull(c, lb[:]); err != nil {
+					return
+				}
+				q := make([]byte, binary.BigEndian.Uint16(lb[:]))
+				if _, err := io.ReadFull(c, q); err != nil {
+					return
+				}
+				s.mu.Lock()
+				s.tcp++
+				s.mu.Unlock()
+				resp := s.answer(q, false)
+				binary.BigEndian.PutUint16(lb[:], uint16(len(resp)))
+				_, _ = c.Write(append(lb[:], resp...))
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *dnsStub) set(name string, records ...TLSARecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[name] = records
+}
+
+func (s *dnsStub) answer(query []byte, udp bool) []byte {
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil || len(q.Questions) != 1 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.Header.ID, Response: true, AuthenticData: s.ad},
+		Questions: q.Questions,
+	}
+	records, ok := s.records[q.Questions[0].Name.String()]
+	switch {
+	case !ok:
+		resp.Header.RCode = dnsmessage.RCodeNameError
+	case udp && s.truncate:
+		resp.Header.Truncated = true
+	default:
+		for _, r := range records {
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: typeTLSA, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.UnknownResource{Type: typeTLSA, Data: append([]byte{r.Usage, r.Selector, r.MatchingType}, r.Data...)},
+			})
+		}
+	}
+	b, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (s *dnsStub) counts() (udp, tcp int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.udp, s.tcp
+}
+
+func tlsaFor(usage, selector uint8, c *testCert) TLSARecord {
+	data := c.cert.Raw
+	if selector == TLSASelectorSPKI {
+		data = c.cert.RawSubjectPublicKeyInfo
+	}
+	sum := sha256.Sum256(data)
+	return TLSARecord{Usage: usage, Selector: selector, MatchingType: TLSAMatchSHA256, Data: sum[:]}
+}
+
+func TestTLSAResolver(t *testing.T) {
+	p := newPKI(t)
+	stub := newDNSStub(t)
+	const name = "_443._tcp.example.com."
+	rec := tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, p.leaf)
+	stub.set(name, rec)
+
+	r := &TLSAResolver{Server: stub.addr, RequireAD: true}
+	got, err := r.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].String() != rec.String() {
+		t.Fatalf("records = %v, want %v", got, rec)
+	}
+
+	stub.mu.Lock()
+	stub.truncate = true
+	stub.mu.Unlock()
+	got, err = (&TLSAResolver{Server: stub.addr}).Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if udp, tcp := stub.counts(); len(got) != 1 || udp != 2 || tcp != 1 {
+		t.Fatalf("truncated answer: %d records after %d UDP and %d TCP queries", len(got), udp, tcp)
+	}
+
+	if _, err := r.Lookup("_443._tcp.missing.example.com."); err == nil {
+		t.Error("NXDOMAIN: no error")
+	}
+	stub.mu.Lock()
+	stub.ad = false
+	stub.mu.Unlock()
+	if _, err := (&TLSAResolver{Server: stub.addr, RequireAD: true}).Lookup(name); err == nil {
+		t.Error("RequireAD without AD bit: no error")
+	}
+}
+
+func TestVerifyDANE(t *testing.T) {
+	p := newPKI(t)
+	other := newPKI(t)
+	chain := certsOf([]*testCert{p.leaf, p.inter})
+
+	tests := []struct {
+		name    string
+		server  string
+		records []TLSARecord
+		ok      bool
+	}{
+		{"dane-ee spki", "localhost", []TLSARecord{tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, p.leaf)}, true},
+		{"dane-ee cert, any name", "other.example", []TLSARecord{tlsaFor(TLSAUsageDANEEE, TLSASelectorCert, p.leaf)}, true},
+		{"dane-ee full", "localhost", []TLSARecord{{TLSAUsageDANEEE, TLSASelectorCert, TLSAMatchFull, p.leaf.cert.Raw}}, true},
+		{"dane-ta intermediate", "localhost", []TLSARecord{tlsaFor(TLSAUsageDANETA, TLSASelectorCert, p.inter)}, true},
+		{"dane-ta wrong name", "other.example", []TLSARecord{tlsaFor(TLSAUsageDANETA, TLSASelectorCert, p.inter)}, false},
+		{"dane-ta root not presented", "localhost", []TLSARecord{tlsaFor(TLSAUsageDANETA, TLSASelectorCert, p.root)}, false},
+		{"no match", "localhost", []TLSARecord{tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, other.leaf)}, false},
+		{"pkix usage ignored", "localhost", []TLSARecord{{1, TLSASelectorCert, TLSAMatchFull, p.leaf.cert.Raw}}, false},
+		{"second record matches", "localhost", []TLSARecord{tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, other.leaf), tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, p.leaf)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyDANE(chain, tt.server, tt.records)
+			if (err == nil) != tt.ok {
+				t.Fatalf("VerifyDANE = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+	if err := VerifyDANE(chain, "localhost", []TLSARecord{tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, other.leaf)}); !errors.Is(err, ErrDANENoMatch) {
+		t.Errorf("no match: %v, want ErrDANENoMatch", err)
+	}
+}
+
+// TestDANEHandshake runs handshakes through NewClientTLSConfig against a
+// server whose TLSA records come from the stub.
+func TestDANEHandshake(t *testing.T) {
+	p := newPKI(t)
+	other := newPKI(t)
+	dir := t.TempDir()
+	caFile := writePEM(t, dir, "ca.crt", p.root)
+	otherCA := writePEM(t, dir, "other.crt", other.root)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{p.leaf.cert.Raw, p.inter.cert.Raw},
+			PrivateKey:  p.leaf.key,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	target := net.JoinHostPort("localhost", port)
+	name, _ := TLSAName(target)
+	stub := newDNSStub(t)
+
+	dial := func(opts ClientTLSOptions, cache tls.ClientSessionCache) (*tls.Conn, error) {
+		opts.EnableTLS13 = true
+		opts.DANEResolver = stub.addr
+		opts.DANETarget = target
+		cfg, err := NewClientTLSConfig(opts)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientSessionCache = cache
+		c, err := tls.Dial("tcp", ln.Addr().String(), cfg)
+		if err != nil {
+			return nil, err
+		}
+		// A round trip, so the client has processed the session ticket.
+		if _, err := c.Write([]byte("x")); err != nil {
+			c.Close()
+			return nil, err
+		}
+		if _, err := io.ReadFull(c, make([]byte, 1)); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+
+	tests := []struct {
+		name   string
+		opts   ClientTLSOptions
+		record TLSARecord
+		ok     bool
+	}{
+		{"dane-ee", ClientTLSOptions{DANE: DANEOnly}, tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, p.leaf), true},
+		{"dane-ta", ClientTLSOptions{DANE: DANEOnly}, tlsaFor(TLSAUsageDANETA, TLSASelectorCert, p.inter), true},
+		{"no match", ClientTLSOptions{DANE: DANEOnly}, tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, other.leaf), false},
+		{"dane+ca", ClientTLSOptions{DANE: DANEAndCA, CAFile: caFile}, tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, p.leaf), true},
+		{"dane+ca wrong CA", ClientTLSOptions{DANE: DANEAndCA, CAFile: otherCA}, tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, p.leaf), false},
+		{"dane+ca no match", ClientTLSOptions{DANE: DANEAndCA, CAFile: caFile}, tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, other.leaf), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub.set(name, tt.record)
+			c, err := dial(tt.opts, nil)
+			if (err == nil) != tt.ok {
+				t.Fatalf("dial = %v, want ok %v", err, tt.ok)
+			}
+			if c != nil {
+				c.Close()
+			}
+		})
+	}
+
+	t.Run("resumption", func(t *testing.T) {
+		cache := tls.NewLRUClientSessionCache(4)
+		opts := ClientTLSOptions{DANE: DANEOnly}
+		stub.set(name, tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, p.leaf))
+		for i := range 2 {
+			c, err := dial(opts, cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resumed := c.ConnectionState().DidResume; resumed != (i == 1) {
+				t.Fatalf("handshake %d: resumed %v", i, resumed)
+			}
+			c.Close()
+		}
+
+		// The records no longer match: a resumed session must fail too.
+		// The resolver caches for the record TTL, which the stub sets to 0.
+		stub.set(name, tlsaFor(TLSAUsageDANEEE, TLSASelectorSPKI, other.leaf))
+		c, err := dial(opts, cache)
+		if err == nil {
+			resumed := c.ConnectionState().DidResume
+			c.Close()
+			t.Fatalf("handshake succeeded (resumed %v) after TLSA records changed", resumed)
+		}
+	})
+
+	if _, err := NewClientTLSConfig(ClientTLSOptions{DANE: DANEOnly}); err == nil {
+		t.Error("DANE without resolver and target: no error")
+	}
+}
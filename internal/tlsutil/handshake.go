@@ -0,0 +1,120 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+)
+
+// HandshakeReason is a stable code for why a TLS handshake failed. Codes are
+// used as log fields and metric labels, so they never change once added.
+type HandshakeReason string
+
+const (
+	ReasonEOF             HandshakeReason = "eof"               // peer hung up mid-handshake
+	ReasonTimeout         HandshakeReason = "timeout"           // peer stopped responding
+	ReasonNotTLS          HandshakeReason = "not_tls"           // peer did not speak TLS
+	ReasonUnknownCA       HandshakeReason = "unknown_ca"        // chain does not lead to a trusted root
+	ReasonCertExpired     HandshakeReason = "cert_expired"      // outside the validity period
+	ReasonHostname        HandshakeReason = "hostname_mismatch" // server cert does not cover the name
+	ReasonBadCertificate  HandshakeReason = "bad_certificate"   // any other certificate rejection
+	ReasonNoClientCert    HandshakeReason = "no_client_cert"    // mTLS required, none sent
+	ReasonProtocolVersion HandshakeReason = "protocol_version"  // no common TLS version
+	ReasonNoSharedCipher  HandshakeReason = "no_shared_cipher"  // no common cipher suite
+	ReasonALPN            HandshakeReason = "alpn"              // no common application protocol
+	ReasonDANE            HandshakeReason = "dane"              // certificate does not match TLSA records
+	ReasonRemoteAlert     HandshakeReason = "remote_alert"      // peer aborted with another alert
+	ReasonOther           HandshakeReason = "other"
+)
+
+var handshakeHints = map[HandshakeReason]string{
+	ReasonEOF:             "the peer closed the connection during the handshake; usually a port scan or health check, otherwise the peer rejected us without an alert (check its logs)",
+	ReasonTimeout:         "the peer stopped responding during the handshake; check the network path, peer load and handshake timeouts",
+	ReasonNotTLS:          "the peer did not speak TLS; check the port, plaintext clients, STARTTLS and PROXY protocol settings",
+	ReasonUnknownCA:       "the certificate is not issued by a trusted CA; pass the issuing CA (-ca) or have the peer send its intermediates",
+	ReasonCertExpired:     "a certificate is expired or not yet valid; renew it and check the clocks on both hosts",
+	ReasonHostname:        "the server certificate does not cover the name used; connect with a name from its SANs (-servername) or reissue it",
+	ReasonBadCertificate:  "a certificate was rejected (key usage, signature or format); inspect it with the check subcommand",
+	ReasonNoClientCert:    "the server requires a client certificate; pass -cert and -key issued by the server's client CA",
+	ReasonProtocolVersion: "the two sides share no TLS version; compare their minimum and maximum versions",
+	ReasonNoSharedCipher:  "the two sides share no cipher suite; compare their profiles and the certificate key type (RSA/ECDSA)",
+	ReasonALPN:            "the two sides share no application protocol; compare the ALPN protocols offered and accepted",
+	ReasonDANE:            "the certificate does not match the target's TLSA records; publish records for the current certificate or check -dane",
+	ReasonRemoteAlert:     "the peer aborted the handshake; its logs have the details",
+}
+
+// Hint returns remediation advice for r, or "" when there is none.
+func (r HandshakeReason) Hint() string { return handshakeHints[r] }
+
+// handshakeMessages classify errors by text, which is all that is left once
+// a library (gRPC, QUIC) has flattened them. Remote alerts land here too:
+// "remote error: tls: <alert>". Earlier entries win.
+var handshakeMessages = []struct {
+	substr string
+	reason HandshakeReason
+}{
+	{"dane", ReasonDANE},
+	{"first record does not look like a TLS handshake", ReasonNotTLS},
+	{"signed by unknown authority", ReasonUnknownCA},
+	{"unknown certificate authority", ReasonUnknownCA},
+	{"has expired or is not yet valid", ReasonCertExpired},
+	{"expired certificate", ReasonCertExpired},
+	{"certificate is valid for", ReasonHostname},
+	{"certificate is not valid for any names", ReasonHostname},
+	{"didn't provide a certificate", ReasonNoClientCert},
+	{"certificate required", ReasonNoClientCert},
+	{"unsupported versions", ReasonProtocolVersion},
+	{"protocol version", ReasonProtocolVersion},
+	{"no cipher suite supported by both", ReasonNoSharedCipher},
+	{"application protocol", ReasonALPN},
+	{"bad certificate", ReasonBadCertificate},
+	{"failed to verify certificate", ReasonBadCertificate},
+	{"remote error", ReasonRemoteAlert},
+	{"EOF", ReasonEOF},
+	{"timeout", ReasonTimeout},
+	{"deadline exceeded", ReasonTimeout},
+}
+
+// ClassifyHandshake maps a handshake or certificate verification error, on
+// the server or the client side, to a HandshakeReason. It returns "" for nil
+// and for errors from before the handshake, such as a refused TCP connect.
+func ClassifyHandshake(err error) HandshakeReason {
+	var (
+		oe  *net.OpError
+		ne  net.Error
+		rhe tls.RecordHeaderError
+		uae x509.UnknownAuthorityError
+		he  x509.HostnameError
+		cie x509.CertificateInvalidError
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &oe) && oe.Op == "dial":
+		return ""
+	case errors.Is(err, ErrDANENoMatch):
+		return ReasonDANE
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return ReasonEOF
+	case errors.As(err, &ne) && ne.Timeout():
+		return ReasonTimeout
+	case errors.As(err, &rhe):
+		return ReasonNotTLS
+	case errors.As(err, &uae):
+		return ReasonUnknownCA
+	case errors.As(err, &he):
+		return ReasonHostname
+	case errors.As(err, &cie) && cie.Reason == x509.Expired:
+		return ReasonCertExpired
+	}
+	msg := err.Error()
+	for _, m := range handshakeMessages {
+		if strings.Contains(msg, m.substr) {
+			return m.reason
+		}
+	}
+	return ReasonOther
+}
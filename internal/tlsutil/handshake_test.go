@@ -0,0 +1,241 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClassifyHandshake(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want HandshakeReason
+	}{
+		{"nil", nil, ""},
+		{"refused dial", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ""},
+		{"dane", fmt.Errorf("verify: %w", ErrDANENoMatch), ReasonDANE},
+		{"eof", io.EOF, ReasonEOF},
+		{"unexpected eof", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), ReasonEOF},
+		{"deadline", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, ReasonTimeout},
+		{"record header", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, ReasonNotTLS},
+		{"unknown authority", &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}, ReasonUnknownCA},
+		{"hostname", x509.HostnameError{Certificate: &x509.Certificate{}, Host: "example.com"}, ReasonHostname},
+		{"expired", x509.CertificateInvalidError{Cert: &x509.Certificate{}, Reason: x509.Expired}, ReasonCertExpired},
+		{"other invalid", x509.CertificateInvalidError{Cert: &x509.Certificate{}, Reason: x509.NotAuthorizedToSign}, ReasonOther},
+
+		// Flattened by gRPC or QUIC: only the text is left.
+		{"text not tls", errors.New("tls: first record does not look like a TLS handshake"), ReasonNotTLS},
+		{"text unknown authority", errors.New(`connection error: desc = "transport: authentication handshake failed: tls: failed to verify certificate: x509: certificate signed by unknown authority"`), ReasonUnknownCA},
+		{"alert unknown ca", errors.New("remote error: tls: unknown certificate authority"), ReasonUnknownCA},
+		{"alert expired", errors.New("remote error: tls: expired certificate"), ReasonCertExpired},
+		{"text hostname", errors.New("x509: certificate is valid for localhost, not example.com"), ReasonHostname},
+		{"text no names", errors.New("x509: certificate is not valid for any names, but wanted to match example.com"), ReasonHostname},
+		{"no client cert", errors.New("tls: client didn't provide a certificate"), ReasonNoClientCert},
+		{"alert certificate required", errors.New("remote error: tls: certificate required"), ReasonNoClientCert},
+		{"unsupported versions", errors.New("tls: client offered only unsupported versions: [303]"), ReasonProtocolVersion},
+		{"alert protocol version", errors.New("remote error: tls: protocol version not supported"), ReasonProtocolVersion},
+		{"no cipher", errors.New("tls: no cipher suite supported by both client and server"), ReasonNoSharedCipher},
+		{"alpn", errors.New("tls: client requested unsupported application protocols ([foo])"), ReasonALPN},
+		{"alert alpn", errors.New("remote error: tls: no application protocol"), ReasonALPN},
+		{"alert bad certificate", errors.New("remote error: tls: bad certificate"), ReasonBadCertificate},
+		{"failed to verify", errors.New("tls: failed to verify certificate: x509: unhandled critical extension"), ReasonBadCertificate},
+		{"other alert", errors.New("remote error: tls: handshake failure"), ReasonRemoteAlert},
+		{"text eof", errors.New("connection closed: EOF"), ReasonEOF},
+		{"text deadline", errors.New("rpc error: code = Unavailable desc = context deadline exceeded"), ReasonTimeout},
+		{"dane before alerts", errors.New("remote error: tls: bad certificate (dane: no TLSA record matches)"), ReasonDANE},
+		{"unknown", errors.New("something else"), ReasonOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyHandshake(tt.err); got != tt.want {
+				t.Errorf("ClassifyHandshake(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestClassifyHandshakeLive classifies the errors of real failed handshakes
+// on both ends, so a change in crypto/tls error texts shows up here.
+func TestClassifyHandshakeLive(t *testing.T) {
+	p := newPKI(t)
+	other := newPKI(t)
+	expired := newCert(t, certSpec{
+		cn:        "localhost",
+		dns:       []string{"localhost"},
+		eku:       []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		notBefore: time.Now().Add(-48 * time.Hour),
+		notAfter:  time.Now().Add(-24 * time.Hour),
+	}, p.inter)
+	client := newCert(t, certSpec{cn: "client", eku: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, p.inter)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(p.root.cert)
+	serverCfg := func(leaf *testCert) *tls.Config {
+		return &tls.Config{Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leaf.cert.Raw, p.inter.cert.Raw},
+			PrivateKey:  leaf.key,
+		}}}
+	}
+	clientCfg := func() *tls.Config { return &tls.Config{RootCAs: roots, ServerName: "localhost"} }
+
+	tests := []struct {
+		name           string
+		server, client func() *tls.Config
+		raw            func(net.Conn) // instead of a TLS client
+		timeout        time.Duration  // server handshake deadline; default 5s
+		serverWant     HandshakeReason
+		clientWant     HandshakeReason
+	}{
+		{
+			name:       "unknown CA",
+			server:     func() *tls.Config { return serverCfg(other.leaf) },
+			client:     clientCfg,
+			serverWant: ReasonBadCertificate,
+			clientWant: ReasonUnknownCA,
+		},
+		{
+			name:       "hostname",
+			server:     func() *tls.Config { return serverCfg(p.leaf) },
+			client:     func() *tls.Config { c := clientCfg(); c.ServerName = "example.com"; return c },
+			serverWant: ReasonBadCertificate,
+			clientWant: ReasonHostname,
+		},
+		{
+			name:       "expired",
+			server:     func() *tls.Config { return serverCfg(expired) },
+			client:     clientCfg,
+			serverWant: ReasonBadCertificate,
+			clientWant: ReasonCertExpired,
+		},
+		{
+			name: "no client cert",
+			server: func() *tls.Config {
+				c := serverCfg(p.leaf)
+				c.ClientAuth, c.ClientCAs = tls.RequireAndVerifyClientCert, roots
+				return c
+			},
+			client:     clientCfg,
+			serverWant: ReasonNoClientCert,
+			clientWant: ReasonNoClientCert,
+		},
+		{
+			name: "client cert from another CA",
+			server: func() *tls.Config {
+				c := serverCfg(p.leaf)
+				c.ClientAuth, c.ClientCAs = tls.RequireAndVerifyClientCert, x509.NewCertPool()
+				return c
+			},
+			client: func() *tls.Config {
+				c := clientCfg()
+				c.Certificates = []tls.Certificate{{Certificate: [][]byte{client.cert.Raw, p.inter.cert.Raw}, PrivateKey: client.key}}
+				return c
+			},
+			serverWant: ReasonUnknownCA,
+			clientWant: ReasonUnknownCA,
+		},
+		{
+			name:       "protocol version",
+			server:     func() *tls.Config { c := serverCfg(p.leaf); c.MaxVersion = tls.VersionTLS12; return c },
+			client:     func() *tls.Config { c := clientCfg(); c.MinVersion = tls.VersionTLS13; return c },
+			serverWant: ReasonProtocolVersion,
+			clientWant: ReasonProtocolVersion,
+		},
+		{
+			name:       "alpn",
+			server:     func() *tls.Config { c := serverCfg(p.leaf); c.NextProtos = []string{"h2"}; return c },
+			client:     func() *tls.Config { c := clientCfg(); c.NextProtos = []string{"foo"}; return c },
+			serverWant: ReasonALPN,
+			clientWant: ReasonALPN,
+		},
+		{
+			name:       "not tls",
+			server:     func() *tls.Config { return serverCfg(p.leaf) },
+			raw:        func(c net.Conn) { _, _ = c.Write([]byte("GET / HTTP/1.0\r\n\r\n")) },
+			serverWant: ReasonNotTLS,
+		},
+		{
+			name:       "hang up",
+			server:     func() *tls.Config { return serverCfg(p.leaf) },
+			raw:        func(net.Conn) {},
+			serverWant: ReasonEOF,
+		},
+		{
+			name:       "silent peer",
+			server:     func() *tls.Config { return serverCfg(p.leaf) },
+			raw:        func(net.Conn) { time.Sleep(time.Second) },
+			timeout:    100 * time.Millisecond,
+			serverWant: ReasonTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = 5 * time.Second
+			}
+			serverErr := make(chan error, 1)
+			go func() {
+				c, err := ln.Accept()
+				if err != nil {
+					serverErr <- err
+					return
+				}
+				defer c.Close()
+				_ = c.SetDeadline(time.Now().Add(timeout))
+				serverErr <- tls.Server(c, tt.server()).Handshake()
+			}()
+
+			c, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+			if tt.raw != nil {
+				tt.raw(c)
+				_ = c.Close()
+			} else {
+				tc := tls.Client(c, tt.client())
+				err := tc.Handshake()
+				if err == nil {
+					// TLS 1.3 servers reject client certificates after
+					// the client's side of the handshake is done.
+					_, err = tc.Read(make([]byte, 1))
+				}
+				_ = tc.Close()
+				if got := ClassifyHandshake(err); got != tt.clientWant {
+					t.Errorf("client: %q for %v, want %q", got, err, tt.clientWant)
+				}
+			}
+			if err := <-serverErr; ClassifyHandshake(err) != tt.serverWant {
+				t.Errorf("server: %q for %v, want %q", ClassifyHandshake(err), err, tt.serverWant)
+			}
+		})
+	}
+}
+
+func TestHandshakeHints(t *testing.T) {
+	for _, r := range []HandshakeReason{
+		ReasonEOF, ReasonTimeout, ReasonNotTLS, ReasonUnknownCA, ReasonCertExpired, ReasonHostname,
+		ReasonBadCertificate, ReasonNoClientCert, ReasonProtocolVersion, ReasonNoSharedCipher,
+		ReasonALPN, ReasonDANE, ReasonRemoteAlert,
+	} {
+		if r.Hint() == "" {
+			t.Errorf("%s has no hint", r)
+		}
+	}
+	if ReasonOther.Hint() != "" {
+		t.Errorf("other has hint %q", ReasonOther.Hint())
+	}
+}
@@ -0,0 +1,22 @@
+package tlsutil
+
+import "crypto/tls"
+
+// PeerIdentity returns the identity of the verified client certificate: its
+// subject CN, or the first DNS/URI SAN when the CN is empty. It is "" when
+// the peer sent no certificate.
+func PeerIdentity(cs tls.ConnectionState) string {
+	if len(cs.PeerCertificates) == 0 {
+		return ""
+	}
+	leaf := cs.PeerCertificates[0]
+	switch {
+	case leaf.Subject.CommonName != "":
+		return leaf.Subject.CommonName
+	case len(leaf.DNSNames) > 0:
+		return leaf.DNSNames[0]
+	case len(leaf.URIs) > 0:
+		return leaf.URIs[0].String()
+	}
+	return ""
+}
@@ -0,0 +1,300 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LintFinding is one issue reported by Lint, with a remediation hint.
+type LintFinding struct {
+	ID          string   `json:"id"`
+	Severity    Severity `json:"severity"`
+	Target      string   `json:"target"`
+	Message     string   `json:"message"`
+	Remediation string   `json:"remediation,omitempty"`
+}
+
+// LintInput selects what Lint inspects; any field may be left empty.
+type LintInput struct {
+	Server    *ServerTLSOptions
+	Client    *ClientTLSOptions
+	Config    *tls.Config
+	CertFiles []string
+}
+
+// maxLeafValidity is the CA/Browser Forum limit for publicly trusted leaves.
+const maxLeafValidity = 398 * 24 * time.Hour
+
+// Lint inspects TLS options, a built config and certificate files for weak
+// settings. Findings are sorted by severity (errors first), then target.
+func Lint(in LintInput) []LintFinding {
+	var out []LintFinding
+	if in.Server != nil {
+		out = append(out, LintServerOptions(*in.Server)...)
+	}
+	if in.Client != nil {
+		out = append(out, LintClientOptions(*in.Client)...)
+	}
+	if in.Config != nil {
+		out = append(out, LintConfig("tls.Config", in.Config)...)
+	}
+	for _, f := range in.CertFiles {
+		out = append(out, LintCertFile(f)...)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if ri, rj := severityRank(out[i].Severity), severityRank(out[j].Severity); ri != rj {
+			return ri > rj
+		}
+		return out[i].Target < out[j].Target
+	})
+	return out
+}
+
+// LintServerOptions checks ServerTLSOptions and the certificate it points to.
+func LintServerOptions(opts ServerTLSOptions) []LintFinding {
+	const target = "ServerTLSOptions"
+	out := lintVersions(target, opts.MinVersion, opts.EnableTLS13)
+	if opts.RequireClientCert && opts.CAFile == "" {
+		out = append(out, LintFinding{"mtls-no-ca", SeverityError, target,
+			"RequireClientCert is set without CAFile; every client certificate will be rejected",
+			"set CAFile to the CA that issues client certificates"})
+	}
+	if !opts.RequireClientCert && opts.CAFile != "" {
+		out = append(out, LintFinding{"ca-unused", SeverityInfo, target,
+			"CAFile is set but RequireClientCert is off; clients are not authenticated",
+			"enable RequireClientCert (-mtls) if client authentication is intended"})
+	}
+	if opts.CheckPolicy == "" || opts.CheckPolicy == CheckOff {
+		out = append(out, LintFinding{"cert-check-off", SeverityInfo, target,
+			"startup certificate checks are disabled",
+			"set CheckPolicy to warn or fail (-cert-check)"})
+	}
+	if opts.CertFile != "" {
+		out = append(out, lintCertFile(opts.CertFile, true)...)
+	}
+	return out
+}
+
+// LintClientOptions checks ClientTLSOptions and an optional client certificate.
+func LintClientOptions(opts ClientTLSOptions) []LintFinding {
+	const target = "ClientTLSOptions"
+	out := lintVersions(target, opts.MinVersion, opts.EnableTLS13)
+	if opts.CAFile == "" && opts.DANE != DANEOnly {
+		out = append(out, LintFinding{"system-roots", SeverityInfo, target,
+			"CAFile is empty; the system trust store is used",
+			"pin a private CA with CAFile for internal services"})
+	}
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		out = append(out, LintFinding{"client-cert-incomplete", SeverityError, target,
+			"only one of CertFile/KeyFile is set; no client certificate will be sent",
+			"set both CertFile and KeyFile, or neither"})
+	}
+	if opts.DANE == DANEOnly && !opts.DANERequireAD {
+		out = append(out, LintFinding{"dane-no-ad", SeverityWarn, target,
+			"DANE replaces the CA bundle but DNSSEC validation is not required",
+			"set DANERequireAD (-dane-require-ad) and use a validating resolver"})
+	}
+	if opts.CertFile != "" {
+		out = append(out, lintCertFile(opts.CertFile, true)...)
+	}
+	return out
+}
+
+// LintConfig checks a tls.Config as built by library users.
+func LintConfig(target string, cfg *tls.Config) []LintFinding {
+	var out []LintFinding
+	enable13 := cfg.MaxVersion == 0 || cfg.MaxVersion >= tls.VersionTLS13
+	out = append(out, lintVersions(target, cfg.MinVersion, enable13)...)
+	if cfg.InsecureSkipVerify && cfg.VerifyPeerCertificate == nil && cfg.VerifyConnection == nil {
+		out = append(out, LintFinding{"insecure-skip-verify", SeverityError, target,
+			"InsecureSkipVerify is set with no custom verification; any certificate is accepted",
+			"remove InsecureSkipVerify and trust the right CA via RootCAs"})
+	}
+	insecure := map[uint16]bool{}
+	for _, cs := range tls.InsecureCipherSuites() {
+		insecure[cs.ID] = true
+	}
+	for _, id := range cfg.CipherSuites {
+		name := tls.CipherSuiteName(id)
+		switch {
+		case insecure[id]:
+			out = append(out, LintFinding{"weak-cipher", SeverityError, target,
+				fmt.Sprintf("insecure cipher suite %s is enabled", name),
+				"restrict CipherSuites to ECDHE with AES-GCM or ChaCha20-Poly1305"})
+		case !strings.HasPrefix(name, "TLS_ECDHE_") && !strings.HasPrefix(name, "TLS_AES_") && !strings.HasPrefix(name, "TLS_CHACHA20_"):
+			out = append(out, LintFinding{"no-pfs", SeverityWarn, target,
+				fmt.Sprintf("cipher suite %s has no forward secrecy", name),
+				"use only ECDHE key exchange suites"})
+		case strings.Contains(name, "_CBC_"):
+			out = append(out, LintFinding{"cbc-cipher", SeverityWarn, target,
+				fmt.Sprintf("CBC cipher suite %s is enabled", name),
+				"prefer AEAD suites (GCM, ChaCha20-Poly1305)"})
+		}
+	}
+	for _, c := range cfg.Certificates {
+		if c.Leaf != nil {
+			out = append(out, lintCert(target+" certificate", c.Leaf, true)...)
+		}
+	}
+	return out
+}
+
+// LintCertFile checks every certificate in a PEM file. The first one is
+// checked as a leaf unless it is a CA certificate.
+func LintCertFile(path string) []LintFinding {
+	return lintCertFile(path, false)
+}
+
+// lintCertFile is LintCertFile; served means the file is presented in a
+// handshake, so its first certificate is a leaf even if it has the CA flag.
+func lintCertFile(path string, served bool) []LintFinding {
+	certs, err := LoadCertsFile(path)
+	if err != nil {
+		return []LintFinding{{"cert-unreadable", SeverityError, path, err.Error(), "check the path and PEM format"}}
+	}
+	var out []LintFinding
+	for i, c := range certs {
+		target := path
+		if len(certs) > 1 {
+			target = fmt.Sprintf("%s[%d] %s", path, i, c.Subject.CommonName)
+		}
+		out = append(out, lintCert(target, c, i == 0 && (served || !c.IsCA))...)
+	}
+	return out
+}
+
+func lintVersions(target string, minVersion uint16, enableTLS13 bool) []LintFinding {
+	var out []LintFinding
+	switch {
+	case minVersion == 0:
+		out = append(out, LintFinding{"min-version-unset", SeverityWarn, target,
+			"MinVersion is 0; the floor depends on the Go version and GODEBUG settings",
+			"set MinVersion to tls.VersionTLS12 explicitly"})
+	case minVersion < tls.VersionTLS12:
+		out = append(out, LintFinding{"min-version-legacy", SeverityError, target,
+			fmt.Sprintf("MinVersion %s allows deprecated protocol versions", tls.VersionName(minVersion)),
+			"set MinVersion to tls.VersionTLS12 or higher"})
+	}
+	if !enableTLS13 {
+		out = append(out, LintFinding{"tls13-disabled", SeverityWarn, target,
+			"TLS 1.3 is disabled",
+			"enable TLS 1.3 (EnableTLS13 / leave MaxVersion unset)"})
+	}
+	return out
+}
+
+func lintCert(target string, c *x509.Certificate, leaf bool) []LintFinding {
+	var out []LintFinding
+	switch k := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		bits := k.N.BitLen()
+		switch {
+		case bits < 2048:
+			out = append(out, LintFinding{"rsa-key-weak", SeverityError, target,
+				fmt.Sprintf("RSA key is %d bits", bits),
+				"reissue with RSA 3072+ or ECDSA P-256"})
+		case bits < 3072:
+			out = append(out, LintFinding{"rsa-key-2048", SeverityWarn, target,
+				fmt.Sprintf("RSA key is %d bits (below the 3072-bit recommendation for use past 2030)", bits),
+				"reissue with RSA 3072+ or ECDSA P-256 (e.g. openssl genrsa 3072 in gen-certs.ps1)"})
+		}
+	case *ecdsa.PublicKey:
+		if k.Curve.Params().BitSize < 256 {
+			out = append(out, LintFinding{"ecdsa-key-weak", SeverityError, target,
+				fmt.Sprintf("ECDSA key uses %s", k.Curve.Params().Name),
+				"reissue with P-256 or P-384"})
+		}
+	case ed25519.PublicKey:
+	default:
+		out = append(out, LintFinding{"key-type", SeverityWarn, target,
+			fmt.Sprintf("unusual public key type %T", k), "use RSA 3072+, ECDSA P-256 or Ed25519"})
+	}
+
+	switch c.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		// The signature of a root is never checked, and isSelfSigned
+		// cannot verify a SHA-1 one either.
+		if !bytes.Equal(c.RawIssuer, c.RawSubject) {
+			out = append(out, LintFinding{"weak-signature", SeverityError, target,
+				fmt.Sprintf("certificate is signed with %s", c.SignatureAlgorithm),
+				"reissue with a SHA-256 based signature (-sha256)"})
+		}
+	}
+	if c.Version < 3 {
+		out = append(out, LintFinding{"x509-v1", SeverityWarn, target,
+			fmt.Sprintf("X.509 v%d certificate without extensions", c.Version),
+			"reissue as v3 with basicConstraints and keyUsage (openssl req -addext / -extfile)"})
+	}
+
+	now := time.Now()
+	if now.After(c.NotAfter) {
+		out = append(out, LintFinding{"expired", SeverityError, target,
+			fmt.Sprintf("certificate expired at %s", c.NotAfter.Format(time.RFC3339)),
+			"renew the certificate"})
+	}
+	if !leaf {
+		return out
+	}
+
+	if c.IsCA {
+		out = append(out, LintFinding{"ca-leaf", SeverityError, target,
+			"leaf certificate has the CA flag set; whoever holds its key can issue certificates",
+			"reissue the leaf with basicConstraints CA:FALSE, signed by a separate CA"})
+	}
+	clientOnly := len(c.ExtKeyUsage) == 1 && c.ExtKeyUsage[0] == x509.ExtKeyUsageClientAuth
+	if !clientOnly && len(c.DNSNames) == 0 && len(c.IPAddresses) == 0 && len(c.URIs) == 0 {
+		out = append(out, LintFinding{"no-san", SeverityError, target,
+			"certificate has no subjectAltName; Go and browsers ignore the CN",
+			"add DNS/IP entries under subjectAltName"})
+	}
+	for _, n := range c.DNSNames {
+		if strings.HasPrefix(n, "*.") {
+			out = append(out, LintFinding{"wildcard-san", SeverityWarn, target,
+				fmt.Sprintf("wildcard SAN %q covers every host in the zone", n),
+				"issue per-service certificates with explicit names"})
+		}
+	}
+	if v := c.NotAfter.Sub(c.NotBefore); v > maxLeafValidity {
+		out = append(out, LintFinding{"long-validity", SeverityInfo, target,
+			fmt.Sprintf("leaf validity is %d days (public CAs allow at most 398)", int(v.Hours()/24)),
+			"shorten -days and automate renewal"})
+	}
+	if len(c.ExtKeyUsage) == 0 {
+		out = append(out, LintFinding{"no-eku", SeverityWarn, target,
+			"leaf has no extendedKeyUsage; it can be used for any purpose",
+			"set extendedKeyUsage = serverAuth or clientAuth"})
+	}
+	return out
+}
+
+func severityRank(s Severity) int {
+	switch s {
+	case SeverityError:
+		return 2
+	case SeverityWarn:
+		return 1
+	}
+	return 0
+}
+
+// ParseSeverity parses a severity flag value such as -fail-on.
+func ParseSeverity(s string) (Severity, error) {
+	switch sev := Severity(strings.ToLower(s)); sev {
+	case SeverityInfo, SeverityWarn, SeverityError:
+		return sev, nil
+	}
+	return "", fmt.Errorf("unknown severity %q (want info, warn or error)", s)
+}
+
+// SeverityAtLeast reports whether s is at least as severe as min.
+func SeverityAtLeast(s, min Severity) bool {
+	return severityRank(s) >= severityRank(min)
+}
@@ -0,0 +1,148 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+)
+
+// ids returns the finding IDs, in order.
+func ids(findings []LintFinding) []string {
+	var out []string
+	for _, f := range findings {
+		out = append(out, f.ID)
+	}
+	return out
+}
+
+// rsaKey is a public key of the given size; lintCert only looks at it.
+func rsaKey(bits int) *rsa.PublicKey {
+	return &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), uint(bits-1)), E: 65537}
+}
+
+func TestLintCert(t *testing.T) {
+	p := newPKI(t)
+	tests := []struct {
+		name   string
+		modify func(c *x509.Certificate)
+		leaf   bool
+		want   []string
+	}{
+		{"clean leaf", nil, true, nil},
+		{"weak rsa key", func(c *x509.Certificate) { c.PublicKey = rsaKey(1024) }, true, []string{"rsa-key-weak"}},
+		{"rsa 2048", func(c *x509.Certificate) { c.PublicKey = rsaKey(2048) }, true, []string{"rsa-key-2048"}},
+		{"rsa 3072", func(c *x509.Certificate) { c.PublicKey = rsaKey(3072) }, true, nil},
+		{"weak ecdsa curve", func(c *x509.Certificate) { c.PublicKey = &ecdsa.PublicKey{Curve: elliptic.P224()} }, true, []string{"ecdsa-key-weak"}},
+		{"sha-1 signature", func(c *x509.Certificate) { c.SignatureAlgorithm = x509.SHA1WithRSA }, true, []string{"weak-signature"}},
+		{"md5 signature", func(c *x509.Certificate) { c.SignatureAlgorithm = x509.MD5WithRSA }, false, []string{"weak-signature"}},
+		{"x509 v1", func(c *x509.Certificate) { c.Version = 1 }, true, []string{"x509-v1"}},
+		{"expired", func(c *x509.Certificate) { c.NotAfter = time.Now().Add(-time.Hour) }, true, []string{"expired"}},
+		{"missing san", func(c *x509.Certificate) { c.DNSNames, c.IPAddresses = nil, nil }, true, []string{"no-san"}},
+		{"client cert without san", func(c *x509.Certificate) {
+			c.DNSNames, c.IPAddresses = nil, nil
+			c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		}, true, nil},
+		{"wildcard san", func(c *x509.Certificate) { c.DNSNames = []string{"*.example.com"} }, true, []string{"wildcard-san"}},
+		{"over-long validity", func(c *x509.Certificate) { c.NotAfter = c.NotBefore.Add(400 * 24 * time.Hour) }, true, []string{"long-validity"}},
+		{"398 days", func(c *x509.Certificate) { c.NotAfter = c.NotBefore.Add(398 * 24 * time.Hour) }, true, nil},
+		{"ca flag on leaf", func(c *x509.Certificate) { c.IsCA = true }, true, []string{"ca-leaf"}},
+		{"no eku", func(c *x509.Certificate) { c.ExtKeyUsage = nil }, true, []string{"no-eku"}},
+		// Leaf-only checks are skipped for CA certificates.
+		{"not a leaf", func(c *x509.Certificate) { c.DNSNames, c.IPAddresses, c.ExtKeyUsage = nil, nil, nil }, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := *p.leaf.cert
+			if tt.modify != nil {
+				tt.modify(&c)
+			}
+			if got := ids(lintCert("leaf", &c, tt.leaf)); !slices.Equal(got, tt.want) {
+				t.Errorf("findings = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// A self-signed root may keep a SHA-1 signature: it is not checked.
+	root := *p.root.cert
+	root.SignatureAlgorithm = x509.ECDSAWithSHA1
+	if got := ids(lintCert("root", &root, false)); len(got) != 0 {
+		t.Errorf("self-signed root: findings = %q", got)
+	}
+}
+
+func TestLintCertFile(t *testing.T) {
+	p := newPKI(t)
+	dir := t.TempDir()
+	chain := writePEM(t, dir, "chain.pem", p.leaf, p.inter)
+	if got := LintCertFile(chain); len(got) != 0 {
+		t.Errorf("leaf and intermediate: findings = %q", ids(got))
+	}
+
+	// A CA certificate on its own is not a leaf, unless it is served.
+	ca := writePEM(t, dir, "ca.pem", p.inter)
+	if got := LintCertFile(ca); len(got) != 0 {
+		t.Errorf("CA file: findings = %q", ids(got))
+	}
+	opts := ServerTLSOptions{CertFile: ca, MinVersion: tls.VersionTLS12, EnableTLS13: true, CheckPolicy: CheckWarn}
+	if got := ids(LintServerOptions(opts)); !slices.Contains(got, "ca-leaf") {
+		t.Errorf("served CA certificate: findings = %q, want ca-leaf", got)
+	}
+
+	if got := ids(LintCertFile(dir + "/missing.pem")); !slices.Equal(got, []string{"cert-unreadable"}) {
+		t.Errorf("missing file: findings = %q", got)
+	}
+}
+
+func TestLintOrder(t *testing.T) {
+	findings := Lint(LintInput{
+		Client: &ClientTLSOptions{MinVersion: tls.VersionTLS12, EnableTLS13: true},
+		Config: &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS12, InsecureSkipVerify: true},
+	})
+	want := []string{"insecure-skip-verify", "min-version-legacy", "tls13-disabled", "system-roots"}
+	if got := ids(findings); !slices.Equal(slices.Sorted(slices.Values(got)), slices.Sorted(slices.Values(want))) {
+		t.Fatalf("findings = %q, want %q", got, want)
+	}
+	// Errors first, then warnings, then info.
+	for i := 1; i < len(findings); i++ {
+		if severityRank(findings[i].Severity) > severityRank(findings[i-1].Severity) {
+			t.Errorf("%s (%s) sorted after %s (%s)", findings[i].ID, findings[i].Severity, findings[i-1].ID, findings[i-1].Severity)
+		}
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Severity
+		ok   bool
+	}{
+		{"info", SeverityInfo, true},
+		{"warn", SeverityWarn, true},
+		{"ERROR", SeverityError, true},
+		{"warning", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ParseSeverity(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseSeverity(%q) = %q, %v; want %q, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSeverityAtLeast(t *testing.T) {
+	levels := []Severity{SeverityInfo, SeverityWarn, SeverityError}
+	for i, s := range levels {
+		for j, min := range levels {
+			if got, want := SeverityAtLeast(s, min), i >= j; got != want {
+				t.Errorf("SeverityAtLeast(%s, %s) = %v, want %v", s, min, got, want)
+			}
+		}
+	}
+}
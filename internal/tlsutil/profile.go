@@ -0,0 +1,98 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sort"
+)
+
+// Strong TLS 1.2 cipher suites; TLS 1.3 suites are fixed by the Go runtime.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// PFS key exchange groups in preference order.
+var defaultCurves = []tls.CurveID{
+	tls.X25519,
+	tls.CurveP256,
+	tls.CurveP384,
+}
+
+// Profile is a named TLS policy that deployed endpoints can be checked against.
+type Profile struct {
+	Name         string
+	MinVersion   uint16
+	MaxVersion   uint16
+	CipherSuites []uint16 // allowed TLS 1.2 suites
+	Curves       []tls.CurveID
+}
+
+var profiles = map[string]Profile{
+	// What NewServerTLSConfig/NewClientTLSConfig build with EnableTLS13.
+	"default": {
+		Name:         "default",
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS13,
+		CipherSuites: defaultCipherSuites,
+		Curves:       defaultCurves,
+	},
+	// EnableTLS13 = false.
+	"tls12": {
+		Name:         "tls12",
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: defaultCipherSuites,
+		Curves:       defaultCurves,
+	},
+	// TLS 1.3 only.
+	"modern": {
+		Name:       "modern",
+		MinVersion: tls.VersionTLS13,
+		MaxVersion: tls.VersionTLS13,
+		Curves:     defaultCurves,
+	},
+}
+
+// ProfileByName returns a built-in profile: default, tls12 or modern.
+func ProfileByName(name string) (Profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown TLS profile %q (want one of %v)", name, ProfileNames())
+	}
+	return p, nil
+}
+
+// ProfileNames lists the built-in profile names.
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for n := range profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AllowsSuite reports whether a TLS 1.2 suite is allowed by the profile.
+func (p Profile) AllowsSuite(id uint16) bool {
+	for _, s := range p.CipherSuites {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsCurve reports whether a key exchange group is allowed by the profile.
+func (p Profile) AllowsCurve(id tls.CurveID) bool {
+	for _, c := range p.Curves {
+		if c == id {
+			return true
+		}
+	}
+	return false
+}